@@ -0,0 +1,2063 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var ErrDuplicate = errors.New("duplicate")
+var ErrDuplicateTitle = errors.New("duplicate title")
+var ErrNotFound = errors.New("not found")
+
+// connectionOptions are appended to the database file name to form the DSN:
+//   - busy_timeout makes a connection wait for a lock held by another
+//     connection instead of failing immediately with SQLITE_BUSY,
+//   - journal_mode=WAL lets readers and the writer work concurrently, so an
+//     ongoing read no longer blocks a write,
+//   - _txlock=immediate takes the write lock when the transaction begins,
+//     where the busy timeout applies, rather than when it first writes.
+//     This also means every transaction must be assumed to write: a
+//     read-only transaction added later would needlessly block writers.
+//
+// The default synchronous=FULL is kept, WAL is fast enough without
+// trading away durability.
+const connectionOptions = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+// maxOpenConns bounds the connection pool, so that a burst of requests cannot
+// open an unbounded number of SQLite connections. WAL allows several
+// concurrent readers alongside a single writer; writers beyond the first wait
+// for the busy timeout.
+const maxOpenConns = 8
+
+// maxIdleConns matches maxOpenConns: connections to a local file are cheap to
+// keep but not to churn, since reopening one re-applies the pragmas above and
+// re-maps the WAL shared memory index.
+const maxIdleConns = maxOpenConns
+
+// Link represents a saved web link.
+// The JSON field names are snake_case since API version 2.0; times marshal
+// as RFC3339 through time.Time.
+type Link struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	AddedAt     time.Time `json:"added_at"`
+	// ExpiresAt is when the link drops out of the default list, nil for a
+	// link which never expires.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Source records which add path saved the link, such as "web",
+	// "bookmarklet", "batch" or "cli". Empty for links saved before sources
+	// were recorded.
+	Source string `json:"source"`
+	// Position orders a link manually: positioned links list before the
+	// others, lowest position first. Nil for a link ordered by date.
+	Position *int64 `json:"position"`
+	// Meta holds the link's arbitrary key/value metadata. Filled in by
+	// GetLink; the list queries leave it nil.
+	Meta map[string]string `json:"meta,omitempty"`
+	// PublishedAt is when the page says it was published, extracted from
+	// its metadata. Nil when the page doesn't say.
+	PublishedAt *time.Time `json:"published_at"`
+	// FetchMs is how long the metadata fetch took when the link was saved,
+	// in milliseconds. Nil for links saved without timing.
+	FetchMs *int64 `json:"fetch_ms,omitempty"`
+	// Tags are the link's tags in alphabetical order, always lowercase.
+	// Nil for an untagged link.
+	Tags []string `json:"tags,omitempty"`
+	// Read marks a link as read, for triaging reading. False for every
+	// newly added link.
+	Read bool `json:"read"`
+	// LastCheckStatus is the HTTP status the URL answered the most recent
+	// dead-link check with, 0 when the connection itself failed. Nil for a
+	// link never checked.
+	LastCheckStatus *int64 `json:"last_check_status,omitempty"`
+	// LastCheckAt is when the dead-link checker last tried the URL. Nil for
+	// a link never checked.
+	LastCheckAt *time.Time `json:"last_check_at,omitempty"`
+}
+
+// DB is a wrapper around sql.DB.
+type DB struct {
+	*sql.DB
+
+	// SearchLimit caps how many links Search returns,
+	// DefaultSearchLimit unless changed. Zero or less means no limit.
+	// SearchWithLimit takes the limit as a parameter instead.
+	SearchLimit int
+
+	// LikeSearch makes Search use SearchLike instead of the FTS index, for
+	// deployments where FTS5 is problematic. Field qualifiers in the search
+	// string are not supported in this mode.
+	LikeSearch bool
+
+	// DedupeTitles makes AddLink reject a link whose normalized title matches
+	// an existing one with ErrDuplicateTitle, in addition to the URL
+	// uniqueness which is always enforced. Off by default: saving two
+	// different pages which happen to share a title is legitimate, so this is
+	// for whoever saves the same article from several URLs (AMP vs canonical).
+	DedupeTitles bool
+}
+
+// InitDB initializes the database.
+func InitDB(databaseFile string) (*DB, error) {
+	// An absolute path cannot be mistaken for a "file:" URI by the driver.
+	databaseFile, err := filepath.Abs(databaseFile)
+	if err != nil {
+		return nil, err
+	}
+	// The driver splits the DSN at the first '?', so a file name containing
+	// one would silently open a different database.
+	if strings.ContainsRune(databaseFile, '?') {
+		return nil, fmt.Errorf("database file name must not contain '?': %s", databaseFile)
+	}
+
+	// A restore staged by StageRestore replaces the database before it is
+	// opened, when nothing holds a connection to the old file.
+	if err := applyStagedRestore(databaseFile); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", databaseFile+connectionOptions)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	// Leaving the database open on a failure here would leave its WAL file
+	// behind as well.
+	defer func() {
+		if err != nil {
+			_ = db.Close()
+		}
+	}()
+
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS links (
+			id INTEGER PRIMARY KEY,
+			url TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL,
+			added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP,
+			source TEXT NOT NULL DEFAULT '',
+			position INTEGER,
+			published_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	// A database created before the expiry column existed lacks it, the
+	// CREATE TABLE above does nothing for it.
+	var hasExpiresAt bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'expires_at')").Scan(&hasExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !hasExpiresAt {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN expires_at TIMESTAMP")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Likewise for the source column.
+	var hasSource bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'source')").Scan(&hasSource)
+	if err != nil {
+		return nil, err
+	}
+	if !hasSource {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN source TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// And for the position column.
+	var hasPosition bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'position')").Scan(&hasPosition)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPosition {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN position INTEGER")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// And for the published_at column.
+	var hasPublishedAt bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'published_at')").Scan(&hasPublishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPublishedAt {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN published_at TIMESTAMP")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// And for the fetch_ms column.
+	var hasFetchMs bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'fetch_ms')").Scan(&hasFetchMs)
+	if err != nil {
+		return nil, err
+	}
+	if !hasFetchMs {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN fetch_ms INTEGER")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// And for the deleted_at column.
+	var hasDeletedAt bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'deleted_at')").Scan(&hasDeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !hasDeletedAt {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN deleted_at TIMESTAMP")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// And for the read column.
+	var hasRead bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'read')").Scan(&hasRead)
+	if err != nil {
+		return nil, err
+	}
+	if !hasRead {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN read BOOLEAN NOT NULL DEFAULT FALSE")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// And for the last_check_status column.
+	var hasLastCheckStatus bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'last_check_status')").Scan(&hasLastCheckStatus)
+	if err != nil {
+		return nil, err
+	}
+	if !hasLastCheckStatus {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN last_check_status INTEGER")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// And for the last_check_at column.
+	var hasLastCheckAt bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('links') WHERE name = 'last_check_at')").Scan(&hasLastCheckAt)
+	if err != nil {
+		return nil, err
+	}
+	if !hasLastCheckAt {
+		_, err = tx.Exec("ALTER TABLE links ADD COLUMN last_check_at TIMESTAMP")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS link_meta (
+			link_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (link_id, key)
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS fetch_errors (
+			id INTEGER PRIMARY KEY,
+			url TEXT NOT NULL,
+			error TEXT NOT NULL,
+			at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS links_fts USING fts5(title, description, body, content='', contentless_delete=1);
+
+		-- The FTS index is contentless, so the indexed text cannot be read back
+		-- out of it. The page body is kept here as well, so that UpdateLink can
+		-- rebuild the FTS row for a link without losing body matching. A link
+		-- whose page yielded no body, and one added before this table existed,
+		-- has no row here, which is what HasBody reports on.
+		-- captured_at is when the body was fetched; NULL for bodies stored
+		-- before it was recorded, for which the link's added_at stands in.
+		CREATE TABLE IF NOT EXISTS link_bodies (
+			link_id INTEGER PRIMARY KEY,
+			body BLOB NOT NULL,
+			captured_at TIMESTAMP
+		);
+
+		-- Share tokens make a single link publicly viewable; a link without a
+		-- row here is not shared.
+		CREATE TABLE IF NOT EXISTS share_tokens (
+			link_id INTEGER PRIMARY KEY,
+			token TEXT NOT NULL UNIQUE
+		);
+
+		-- Tags organize links. Each tag name lives once in tags, and
+		-- link_tags joins links to them.
+		CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE
+		);
+
+		CREATE TABLE IF NOT EXISTS link_tags (
+			link_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (link_id, tag_id)
+		);
+
+		-- Links whose screenshot capture failed, waiting for the background
+		-- retrier. A successful capture removes the row.
+		CREATE TABLE IF NOT EXISTS screenshot_retries (
+			link_id INTEGER PRIMARY KEY,
+			attempts INTEGER NOT NULL,
+			last_attempt TIMESTAMP NOT NULL
+		);
+
+		-- Site favicons, fetched when a link is added. A link whose site
+		-- declares none, or whose icon could not be fetched, has no row.
+		CREATE TABLE IF NOT EXISTS favicons (
+			link_id INTEGER PRIMARY KEY,
+			content_type TEXT NOT NULL,
+			favicon BLOB NOT NULL
+		);
+
+		-- API tokens authenticate programmatic clients with a bearer token
+		-- instead of basic auth. Only the SHA-256 hash of a token is
+		-- stored, so a leaked database does not leak the tokens. Deleting
+		-- a row revokes the token. The name labels a token for revocation,
+		-- and is where a token would be scoped to a user, should the
+		-- server ever become multi-user.
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		-- Trigger to keep the FTS index and the bodies up to date.
+		-- Dropped first, so that an existing database picks up the current
+		-- definition rather than keeping the one it was created with.
+		DROP TRIGGER IF EXISTS links_ad;
+		CREATE TRIGGER links_ad AFTER DELETE ON links BEGIN
+		  DELETE FROM links_fts WHERE ROWID=old.id;
+		  DELETE FROM link_bodies WHERE link_id=old.id;
+		  DELETE FROM link_meta WHERE link_id=old.id;
+		  DELETE FROM share_tokens WHERE link_id=old.id;
+		  DELETE FROM link_tags WHERE link_id=old.id;
+		  DELETE FROM screenshot_retries WHERE link_id=old.id;
+		  DELETE FROM favicons WHERE link_id=old.id;
+		END;
+	`)
+	if err != nil {
+		// A SQLite build without FTS5 fails here, not at open. Search
+		// requires it, so this is a hard error rather than a degraded mode,
+		// but one which names the actual problem.
+		if fts5Unavailable(err) {
+			return nil, fmt.Errorf("this SQLite build does not include the FTS5 module, which search requires: %w", err)
+		}
+		return nil, err
+	}
+
+	// And the captured_at column for databases whose link_bodies table was
+	// created before it existed.
+	var hasCapturedAt bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('link_bodies') WHERE name = 'captured_at')").Scan(&hasCapturedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !hasCapturedAt {
+		_, err = tx.Exec("ALTER TABLE link_bodies ADD COLUMN captured_at TIMESTAMP")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ensureWritable(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{DB: db, SearchLimit: DefaultSearchLimit}, nil
+}
+
+// fts5Unavailable reports whether an error from creating the FTS index means
+// that the SQLite build lacks the FTS5 module. SQLite reports this as a plain
+// SQLITE_ERROR, so the message is all there is to go on.
+func fts5Unavailable(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such module: fts5")
+}
+
+func ensureWritable(db *sql.DB) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer func(conn *sql.Conn) {
+		_ = conn.Close()
+	}(conn)
+
+	return conn.Raw(func(c any) error {
+		if d, ok := c.(interface{ IsReadOnly(string) (bool, error) }); ok {
+			// Use "main" for the primary database schema
+			isReadOnly, err := d.IsReadOnly("main")
+			if err != nil {
+				return err
+			}
+			if isReadOnly {
+				return fmt.Errorf("database is read-only")
+			}
+			return nil
+		}
+
+		return fmt.Errorf("cannot check if database is read-only")
+	})
+}
+
+// LinkSort is the order QueryLinks returns links in.
+type LinkSort int
+
+const (
+	// SortPositioned lists manually positioned links first in position
+	// order and the rest newest first, the order of the main listing.
+	SortPositioned LinkSort = iota
+	// SortAdded lists newest saved first, ignoring manual positions.
+	SortAdded
+	// SortPublished lists by the page's published time, newest first.
+	// Links whose page did not declare one come last, newest added first.
+	SortPublished
+	// SortAddedAsc lists oldest saved first, ignoring manual positions.
+	SortAddedAsc
+	// SortTitle lists by title, case-insensitively, A to Z. Links with the
+	// same title fall back to newest saved first.
+	SortTitle
+	// SortTitleDesc lists by title, case-insensitively, Z to A.
+	SortTitleDesc
+)
+
+// LinkQuery is the set of optional filters, sort and pagination QueryLinks
+// combines into one query. The zero value returns every unexpired link in
+// the main listing order.
+type LinkQuery struct {
+	// Source only returns links recorded from this add path; empty matches
+	// every source.
+	Source string
+	// Expired returns only links whose expiry time has passed, instead of
+	// excluding them.
+	Expired bool
+	// AddedAfter and AddedBefore bound when the links were saved; either
+	// may be nil to leave that end open.
+	AddedAfter  *time.Time
+	AddedBefore *time.Time
+	// Unread only returns links not yet marked as read.
+	Unread bool
+	// Dead only returns links whose last dead-link check failed: a 4xx or
+	// 5xx status, or a connection failure recorded as status 0. Links never
+	// checked don't count as dead.
+	Dead bool
+	// Sort is the order of the result, SortPositioned unless set.
+	Sort LinkSort
+	// Limit caps how many links are returned, 0 for all of them. Offset
+	// skips that many links first, for pagination.
+	Limit  int
+	Offset int
+}
+
+// QueryLinks returns the links matching the query, building one
+// parameterized statement from its filters so that they combine freely
+// instead of needing a method per combination.
+func (db *DB) QueryLinks(ctx context.Context, query LinkQuery) ([]Link, error) {
+	stmt := "SELECT id, url, title, description, source, position, added_at, expires_at, published_at, fetch_ms, read, last_check_status, last_check_at FROM links"
+	var conditions []string
+	var args []any
+	// Soft-deleted links only list through GetDeletedLinks.
+	conditions = append(conditions, "deleted_at IS NULL")
+	if query.Expired {
+		conditions = append(conditions, "expires_at IS NOT NULL AND expires_at <= ?")
+	} else {
+		conditions = append(conditions, "(expires_at IS NULL OR expires_at > ?)")
+	}
+	args = append(args, time.Now().UTC())
+	if query.Source != "" {
+		conditions = append(conditions, "source = ?")
+		args = append(args, query.Source)
+	}
+	if query.AddedAfter != nil {
+		conditions = append(conditions, "added_at >= ?")
+		args = append(args, query.AddedAfter.UTC())
+	}
+	if query.AddedBefore != nil {
+		conditions = append(conditions, "added_at < ?")
+		args = append(args, query.AddedBefore.UTC())
+	}
+	if query.Unread {
+		conditions = append(conditions, "NOT read")
+	}
+	if query.Dead {
+		conditions = append(conditions, "last_check_at IS NOT NULL AND (last_check_status = 0 OR last_check_status >= 400)")
+	}
+	stmt += " WHERE " + strings.Join(conditions, " AND ")
+
+	switch query.Sort {
+	case SortAdded:
+		stmt += " ORDER BY added_at DESC"
+	case SortAddedAsc:
+		stmt += " ORDER BY added_at ASC"
+	case SortTitle:
+		stmt += " ORDER BY title COLLATE NOCASE ASC, added_at DESC"
+	case SortTitleDesc:
+		stmt += " ORDER BY title COLLATE NOCASE DESC, added_at DESC"
+	case SortPublished:
+		stmt += " ORDER BY published_at IS NULL, published_at DESC, added_at DESC"
+	default:
+		stmt += " ORDER BY position IS NULL, position, added_at DESC"
+	}
+
+	if query.Limit > 0 || query.Offset > 0 {
+		// SQLite needs a LIMIT to accept an OFFSET; -1 means unlimited
+		limit := query.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		stmt += " LIMIT ? OFFSET ?"
+		args = append(args, limit, query.Offset)
+	}
+
+	return db.queryLinks(ctx, stmt, args...)
+}
+
+// GetAllLinks returns all links from the database, except expired ones.
+// Manually positioned links come first in position order, the rest follow
+// newest first.
+func (db *DB) GetAllLinks(ctx context.Context) ([]Link, error) {
+	return db.QueryLinks(ctx, LinkQuery{})
+}
+
+// GetAllLinksSorted returns all links from the database except expired ones,
+// in the given order.
+func (db *DB) GetAllLinksSorted(ctx context.Context, sort LinkSort) ([]Link, error) {
+	return db.QueryLinks(ctx, LinkQuery{Sort: sort})
+}
+
+// GetLinksPage returns one page of links, newest saved first, except expired
+// ones. For listings too large to load at once; an offset past the end
+// returns an empty page.
+func (db *DB) GetLinksPage(ctx context.Context, limit, offset int) ([]Link, error) {
+	return db.QueryLinks(ctx, LinkQuery{Sort: SortAdded, Limit: limit, Offset: offset})
+}
+
+// CountLinks returns how many links the database holds, except expired ones.
+func (db *DB) CountLinks(ctx context.Context) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM links WHERE deleted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now().UTC()).Scan(&count)
+	return count, err
+}
+
+// TagCount is one entry of the top tags in Stats: a tag and how many links
+// carry it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// topTagCount is how many tags Stats reports at most.
+const topTagCount = 10
+
+// Stats summarizes the collection for dashboards: totals, recent activity,
+// how many distinct sites are saved and the most used tags. Trashed links
+// count nowhere.
+type Stats struct {
+	// Total counts all links, including expired ones.
+	Total int `json:"total"`
+	// AddedLast7Days and AddedLast30Days count links saved within the
+	// respective window.
+	AddedLast7Days  int `json:"added_last_7_days"`
+	AddedLast30Days int `json:"added_last_30_days"`
+	// DistinctHosts counts how many different hosts the saved URLs point
+	// at. Notes have no host and are left out.
+	DistinctHosts int `json:"distinct_hosts"`
+	// TopTags are the most used tags, up to topTagCount of them, most used
+	// first and alphabetical among equals. Omitted when nothing is tagged.
+	TopTags []TagCount `json:"top_tags,omitempty"`
+}
+
+// Stats computes the collection summary with one aggregate query per metric,
+// instead of loading rows, so it stays quick however large the collection.
+func (db *DB) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM links WHERE deleted_at IS NULL").Scan(&stats.Total)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	now := time.Now().UTC()
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM links WHERE deleted_at IS NULL AND added_at >= ?", now.AddDate(0, 0, -7)).Scan(&stats.AddedLast7Days)
+	if err != nil {
+		return Stats{}, err
+	}
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM links WHERE deleted_at IS NULL AND added_at >= ?", now.AddDate(0, 0, -30)).Scan(&stats.AddedLast30Days)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	// The host is cut out of the URL in SQL: the text between :// and the
+	// next slash, with a slash appended so instr always finds one. Note
+	// pseudo-URLs carry no host and are filtered out by the scheme check.
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT substr(
+			substr(url, instr(url, '://') + 3) || '/', 1,
+			instr(substr(url, instr(url, '://') + 3) || '/', '/') - 1))
+		FROM links
+		WHERE deleted_at IS NULL AND (url LIKE 'http://%' OR url LIKE 'https://%')
+		`).Scan(&stats.DistinctHosts)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.name, COUNT(*) FROM link_tags lt
+		INNER JOIN tags t ON t.id = lt.tag_id
+		INNER JOIN links l ON l.id = lt.link_id
+		WHERE l.deleted_at IS NULL
+		GROUP BY t.name
+		ORDER BY COUNT(*) DESC, t.name
+		LIMIT ?`, topTagCount)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return Stats{}, err
+		}
+		stats.TopTags = append(stats.TopTags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return Stats{}, err
+	}
+
+	return stats, nil
+}
+
+// GetExpiredLinks returns the links whose expiry time has passed.
+func (db *DB) GetExpiredLinks(ctx context.Context) ([]Link, error) {
+	return db.QueryLinks(ctx, LinkQuery{Expired: true, Sort: SortAdded})
+}
+
+// GetAllLinksByPublished returns all links from the database except expired
+// ones, ordered by their published time, newest first. Links whose page did
+// not declare one come last, newest added first.
+func (db *DB) GetAllLinksByPublished(ctx context.Context) ([]Link, error) {
+	return db.QueryLinks(ctx, LinkQuery{Sort: SortPublished})
+}
+
+// GetLinksBySource returns the links recorded from one add path, except
+// expired ones.
+func (db *DB) GetLinksBySource(ctx context.Context, source string) ([]Link, error) {
+	return db.QueryLinks(ctx, LinkQuery{Source: source, Sort: SortAdded})
+}
+
+// LinkRef is just a link's id and URL, for callers which correlate links
+// with files on disk without needing the full rows.
+type LinkRef struct {
+	ID  int64
+	URL string
+}
+
+// GetAllLinkRefs returns the id and URL of every link, including expired
+// ones.
+func (db *DB) GetAllLinkRefs(ctx context.Context) ([]LinkRef, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, url FROM links")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []LinkRef
+	for rows.Next() {
+		var ref LinkRef
+		if err := rows.Scan(&ref.ID, &ref.URL); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+func (db *DB) queryLinks(ctx context.Context, query string, args ...any) ([]Link, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []Link
+	for rows.Next() {
+		var link Link
+		if err := rows.Scan(&link.ID, &link.URL, &link.Title, &link.Description, &link.Source, &link.Position, &link.AddedAt, &link.ExpiresAt, &link.PublishedAt, &link.FetchMs, &link.Read, &link.LastCheckStatus, &link.LastCheckAt); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.fillTags(ctx, links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// fillTags attaches each link's tags. One query covers the whole tag table,
+// so listing does not fan out into a query per link.
+func (db *DB) fillTags(ctx context.Context, links []Link) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT lt.link_id, t.name FROM link_tags lt
+		INNER JOIN tags t ON t.id = lt.tag_id
+		ORDER BY t.name`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tagsByLink := make(map[int64][]string)
+	for rows.Next() {
+		var linkID int64
+		var name string
+		if err := rows.Scan(&linkID, &name); err != nil {
+			return err
+		}
+		tagsByLink[linkID] = append(tagsByLink[linkID], name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range links {
+		links[i].Tags = tagsByLink[links[i].ID]
+	}
+	return nil
+}
+
+// DefaultSearchLimit is how many links a search returns at most, unless
+// changed through SearchLimit. A broad term can match the entire collection,
+// which is slow to render and rarely what anyone wants to scroll through.
+const DefaultSearchLimit = 200
+
+// searchFields maps the field qualifiers accepted in a search string to FTS
+// index columns. Only these names ever reach the FTS query, so a stray ':' in
+// a search string cannot alter it.
+var searchFields = map[string]string{
+	"title": "title",
+	"desc":  "description",
+	"body":  "body",
+}
+
+// Search returns links from the database matching a search string, at most
+// SearchLimit of them.
+// A search string of the form "field:terms", with field being one of title,
+// desc or body, restricts matching to that field; anything else searches all
+// of them.
+func (db *DB) Search(ctx context.Context, s string) ([]Link, error) {
+	links, _, err := db.SearchWithLimit(ctx, s, db.SearchLimit)
+	return links, err
+}
+
+// SearchWithLimit returns at most limit links matching a search string, and
+// whether more links matched than were returned. A limit of 0 or less means
+// no limit.
+func (db *DB) SearchWithLimit(ctx context.Context, s string, limit int) ([]Link, bool, error) {
+	// One extra row tells truncation from an exact fit; -1 is no limit to SQLite
+	sqlLimit := -1
+	if limit > 0 {
+		sqlLimit = limit + 1
+	}
+
+	var links []Link
+	var err error
+	if db.LikeSearch {
+		links, err = db.searchLike(ctx, s, sqlLimit)
+	} else {
+		match := s
+		if qualifier, rest, found := strings.Cut(s, ":"); found {
+			if field, ok := searchFields[strings.ToLower(strings.TrimSpace(qualifier))]; ok {
+				match = fieldMatch(field, strings.TrimSpace(rest))
+			}
+		}
+		links, err = db.search(ctx, match, sqlLimit)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if limit > 0 && len(links) > limit {
+		return links[:limit], true, nil
+	}
+	return links, false, nil
+}
+
+// SearchInField returns links from the database matching a search string in a
+// single field: title, description or body.
+func (db *DB) SearchInField(ctx context.Context, field string, s string) ([]Link, error) {
+	valid := false
+	for _, column := range searchFields {
+		if field == column {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid search field: %s", field)
+	}
+
+	return db.search(ctx, fieldMatch(field, s), -1)
+}
+
+// fieldMatch builds an FTS match expression restricted to a single column.
+func fieldMatch(field string, s string) string {
+	return fmt.Sprintf("{%s}: (%s)", field, s)
+}
+
+// SearchLike returns links matching a search string with LIKE over URL, title
+// and description. Unlike the FTS search it does not rank, match word
+// boundaries or search page bodies, but it works without FTS5.
+func (db *DB) SearchLike(ctx context.Context, s string) ([]Link, error) {
+	return db.searchLike(ctx, s, -1)
+}
+
+func (db *DB) searchLike(ctx context.Context, s string, sqlLimit int) ([]Link, error) {
+	pattern := "%" + escapeLike(s) + "%"
+	return db.queryLinks(ctx, `
+		SELECT id, url, title, description, source, position, added_at, expires_at, published_at, fetch_ms, read, last_check_status, last_check_at FROM links
+		WHERE deleted_at IS NULL AND (url LIKE ? ESCAPE '\' OR title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')
+		ORDER BY added_at DESC LIMIT ?
+		`, pattern, pattern, pattern, sqlLimit)
+}
+
+// escapeLike escapes the LIKE wildcards in a search string, so that they
+// match literally.
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(s)
+}
+
+func (db *DB) search(ctx context.Context, match string, sqlLimit int) ([]Link, error) {
+	return db.queryLinks(ctx, `
+		SELECT l.id, l.url, l.title, l.description, l.source, l.position, l.added_at, l.expires_at, l.published_at, l.fetch_ms, l.read, l.last_check_status, l.last_check_at
+		FROM links_fts f INNER JOIN links l ON l.id=f.rowid
+		WHERE links_fts MATCH ? AND l.deleted_at IS NULL ORDER BY rank LIMIT ?
+		`, match, sqlLimit)
+}
+
+// AddLink adds a new link to the database. The source records which add path
+// saved it, empty is allowed. Adding a URL whose link sits in the trash
+// replaces the trashed copy.
+func (db *DB) AddLink(ctx context.Context, url, title, description, source string, body []byte) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if db.DedupeTitles {
+		// Inside the transaction, so a concurrent add of the same title is
+		// serialized rather than slipping past the check.
+		var duplicate bool
+		err = tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM links WHERE deleted_at IS NULL AND lower(trim(title)) = lower(trim(?)))",
+			title).Scan(&duplicate)
+		if err != nil {
+			return 0, err
+		}
+		if duplicate {
+			return 0, ErrDuplicateTitle
+		}
+	}
+
+	// Re-adding a URL which sits in the trash replaces the trashed copy,
+	// which would otherwise collide with the URL uniqueness. The links_ad
+	// trigger cleans up its dependent rows.
+	_, err = tx.ExecContext(ctx, "DELETE FROM links WHERE url = ? AND deleted_at IS NOT NULL", url)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO links (url, title, description, source) VALUES (?, ?, ?, ?)", url, title, description, source)
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return 0, ErrDuplicate
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO links_fts(rowid, title, description, body) VALUES (?, ?, ?, ?)", id, title, description, body)
+	if err != nil {
+		return 0, err
+	}
+
+	if body != nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO link_bodies (link_id, body, captured_at) VALUES (?, ?, CURRENT_TIMESTAMP)", id, body)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetLink returns a single link from the database,
+// returns ErrNotFound if no row with the given id is found or the link is in
+// the trash.
+func (db *DB) GetLink(ctx context.Context, id int64) (Link, error) {
+	var link Link
+	err := db.QueryRowContext(ctx, "SELECT id, url, title, description, source, position, added_at, expires_at, published_at, fetch_ms, read, last_check_status, last_check_at FROM links WHERE id = ? AND deleted_at IS NULL", id).
+		Scan(&link.ID, &link.URL, &link.Title, &link.Description, &link.Source, &link.Position, &link.AddedAt, &link.ExpiresAt, &link.PublishedAt, &link.FetchMs, &link.Read, &link.LastCheckStatus, &link.LastCheckAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Link{}, ErrNotFound
+	case err != nil:
+		return Link{}, err
+	}
+
+	link.Meta, err = db.GetMeta(ctx, id)
+	if err != nil {
+		return Link{}, err
+	}
+	link.Tags, err = db.GetTags(ctx, id)
+	if err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// GetLinkIDByURL returns the id of the link with the given URL,
+// returns ErrNotFound if no row with the given URL is found.
+func (db *DB) GetLinkIDByURL(ctx context.Context, url string) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, "SELECT id FROM links WHERE url = ?", url).Scan(&id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, ErrNotFound
+	case err != nil:
+		return 0, err
+	}
+	return id, nil
+}
+
+// SetMeta stores one key/value metadata pair for a link, replacing any
+// existing value for the key. An empty value removes the key.
+// Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetMeta(ctx context.Context, id int64, key, value string) error {
+	if value == "" {
+		_, err := db.ExecContext(ctx, "DELETE FROM link_meta WHERE link_id = ? AND key = ?", id, key)
+		return err
+	}
+
+	// Selecting the id from links makes the insert a no-op for a missing
+	// link, so the existence check needs no separate query.
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO link_meta (link_id, key, value)
+		SELECT id, ?, ? FROM links WHERE id = ?
+		ON CONFLICT (link_id, key) DO UPDATE SET value = excluded.value
+		`, key, value, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetMeta returns the metadata of a link, nil when it has none.
+func (db *DB) GetMeta(ctx context.Context, id int64) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT key, value FROM link_meta WHERE link_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var meta map[string]string
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// normalizeTag lowercases and trims a tag, so that "Go " and "go" are the
+// same tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// AddTag tags a link. The tag is normalized to lowercase and trimmed, and
+// tagging with a tag the link already has is a no-op.
+// Returns ErrNotFound if no row with the given id is found.
+func (db *DB) AddTag(ctx context.Context, id int64, tag string) error {
+	tag = normalizeTag(tag)
+	if tag == "" {
+		return fmt.Errorf("tag must not be empty")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO tags (name) VALUES (?)", tag)
+	if err != nil {
+		return err
+	}
+	// Selecting the id from links makes the insert a no-op for a missing
+	// link, like SetMeta.
+	result, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO link_tags (link_id, tag_id)
+		SELECT l.id, t.id FROM links l, tags t WHERE l.id = ? AND t.name = ?
+		`, id, tag)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// Either the link does not exist or it already has the tag; only
+		// the former is an error.
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM links WHERE id = ?)", id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveTag removes one tag from a link. Removing a tag the link does not
+// have is a no-op.
+func (db *DB) RemoveTag(ctx context.Context, id int64, tag string) error {
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM link_tags
+		WHERE link_id = ? AND tag_id = (SELECT id FROM tags WHERE name = ?)
+		`, id, normalizeTag(tag))
+	return err
+}
+
+// GetTags returns a link's tags in alphabetical order, nil when it has none.
+func (db *DB) GetTags(ctx context.Context, id int64) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.name FROM link_tags lt
+		INNER JOIN tags t ON t.id = lt.tag_id
+		WHERE lt.link_id = ?
+		ORDER BY t.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetLinksByTag returns the links tagged with a tag, except expired ones,
+// newest first.
+func (db *DB) GetLinksByTag(ctx context.Context, tag string) ([]Link, error) {
+	return db.queryLinks(ctx, `
+		SELECT l.id, l.url, l.title, l.description, l.source, l.position, l.added_at, l.expires_at, l.published_at, l.fetch_ms, l.read, l.last_check_status, l.last_check_at
+		FROM links l
+		INNER JOIN link_tags lt ON lt.link_id = l.id
+		INNER JOIN tags t ON t.id = lt.tag_id
+		WHERE t.name = ? AND l.deleted_at IS NULL AND (l.expires_at IS NULL OR l.expires_at > ?)
+		ORDER BY l.added_at DESC`, normalizeTag(tag), time.Now().UTC())
+}
+
+// DeleteLink moves a link to the trash. The row stays, hidden from every
+// listing and from GetLink, until RestoreLink brings it back or PurgeDeleted
+// removes it for good. Returns ErrNotFound if no row with the given id is
+// found or the link is already in the trash.
+func (db *DB) DeleteLink(ctx context.Context, id int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	result, err := tx.ExecContext(ctx, "UPDATE links SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	// The FTS row goes with the link, so a trashed link cannot surface
+	// through search; RestoreLink writes it back.
+	_, err = tx.ExecContext(ctx, "DELETE FROM links_fts WHERE rowid = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteLinks moves several links to the trash in a single transaction. Ids
+// without a link, or whose link is already in the trash, are skipped rather
+// than failing the batch. Returns how many links were actually moved.
+func (db *DB) DeleteLinks(ctx context.Context, ids []int64) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	deletedAt := time.Now().UTC()
+	deleted := 0
+	for _, id := range ids {
+		result, err := tx.ExecContext(ctx, "UPDATE links SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", deletedAt, id)
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if rowsAffected == 0 {
+			continue
+		}
+
+		// The FTS row goes with the link, like in DeleteLink.
+		_, err = tx.ExecContext(ctx, "DELETE FROM links_fts WHERE rowid = ?", id)
+		if err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// RestoreLink brings a link back from the trash and re-indexes it.
+// Returns ErrNotFound if no row with the given id is in the trash.
+func (db *DB) RestoreLink(ctx context.Context, id int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	result, err := tx.ExecContext(ctx, "UPDATE links SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	var title, description string
+	err = tx.QueryRowContext(ctx, "SELECT title, description FROM links WHERE id = ?", id).Scan(&title, &description)
+	if err != nil {
+		return err
+	}
+	// The stored body is missing for a link whose page yielded none, the FTS
+	// row is then rebuilt without one, like it was first indexed.
+	var body []byte
+	err = tx.QueryRowContext(ctx, "SELECT body FROM link_bodies WHERE link_id = ?", id).Scan(&body)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO links_fts(rowid, title, description, body) VALUES (?, ?, ?, ?)", id, title, description, body)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDeletedLinks returns the links in the trash, most recently deleted
+// first.
+func (db *DB) GetDeletedLinks(ctx context.Context) ([]Link, error) {
+	return db.queryLinks(ctx, `
+		SELECT id, url, title, description, source, position, added_at, expires_at, published_at, fetch_ms, read, last_check_status, last_check_at FROM links
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC`)
+}
+
+// PurgeDeleted permanently removes every link in the trash, and returns their
+// refs so the caller can remove the screenshot files, which the database does
+// not know about.
+func (db *DB) PurgeDeleted(ctx context.Context) ([]LinkRef, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, url FROM links WHERE deleted_at IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []LinkRef
+	for rows.Next() {
+		var ref LinkRef
+		if err := rows.Scan(&ref.ID, &ref.URL); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The links_ad trigger cleans up the dependent tables row by row.
+	_, err = tx.ExecContext(ctx, "DELETE FROM links WHERE deleted_at IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// SetExpiry sets when a link expires out of the default list, nil for never.
+// Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetExpiry(ctx context.Context, id int64, expiresAt *time.Time) error {
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+	result, err := db.ExecContext(ctx, "UPDATE links SET expires_at = ? WHERE id = ?", expiresAt, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetPublishedAt sets when a link's page says it was published, nil for
+// unknown. Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetPublishedAt(ctx context.Context, id int64, publishedAt *time.Time) error {
+	if publishedAt != nil {
+		utc := publishedAt.UTC()
+		publishedAt = &utc
+	}
+	result, err := db.ExecContext(ctx, "UPDATE links SET published_at = ? WHERE id = ?", publishedAt, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetFetchMs sets how long the metadata fetch of a link took, in
+// milliseconds. Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetFetchMs(ctx context.Context, id int64, fetchMs int64) error {
+	result, err := db.ExecContext(ctx, "UPDATE links SET fetch_ms = ? WHERE id = ?", fetchMs, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetRead marks a link as read or unread. Returns ErrNotFound if no row with
+// the given id is found.
+func (db *DB) SetRead(ctx context.Context, id int64, read bool) error {
+	result, err := db.ExecContext(ctx, "UPDATE links SET read = ? WHERE id = ?", read, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetCheckResult records the outcome of a dead-link check: the HTTP status
+// the URL answered with, 0 for a connection failure, and when the check ran.
+// Returns ErrNotFound if no row with the given id is found or the link is in
+// the trash.
+func (db *DB) SetCheckResult(ctx context.Context, id int64, status int64) error {
+	result, err := db.ExecContext(ctx, "UPDATE links SET last_check_status = ?, last_check_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", status, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetAddedAt overrides when a link was added, for imports which carry their
+// own timestamps. Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetAddedAt(ctx context.Context, id int64, addedAt time.Time) error {
+	result, err := db.ExecContext(ctx, "UPDATE links SET added_at = ? WHERE id = ?", addedAt.UTC(), id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetDescription sets just a link's description, leaving the title and body
+// alone. Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetDescription(ctx context.Context, id int64, description string) error {
+	result, err := db.ExecContext(ctx, "UPDATE links SET description = ? WHERE id = ?", description, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetLinkPosition sets the manual position of a link, nil to return it to
+// date ordering. Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetLinkPosition(ctx context.Context, id int64, position *int64) error {
+	result, err := db.ExecContext(ctx, "UPDATE links SET position = ? WHERE id = ?", position, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// HasBody reports whether a body is stored for a link, so that a caller which
+// is able to fetch the page again can supply one to UpdateLink.
+// Returns ErrNotFound if no row with the given id is found.
+func (db *DB) HasBody(ctx context.Context, id int64) (bool, error) {
+	var hasBody bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM link_bodies WHERE link_id = l.id)
+		FROM links l WHERE l.id = ?
+		`, id).Scan(&hasBody)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, ErrNotFound
+	case err != nil:
+		return false, err
+	default:
+		return hasBody, nil
+	}
+}
+
+// GetBody returns the stored page body of a link and when it was captured.
+// For bodies stored before the capture time was recorded, the link's added_at
+// stands in.
+// Returns ErrNotFound if no row with the given id is found, the link is in
+// the trash, or no body is stored for it.
+func (db *DB) GetBody(ctx context.Context, id int64) ([]byte, time.Time, error) {
+	var body []byte
+	var capturedAt sql.NullTime
+	var addedAt time.Time
+	err := db.QueryRowContext(ctx, `
+		SELECT b.body, b.captured_at, l.added_at
+		FROM link_bodies b
+		INNER JOIN links l ON l.id = b.link_id
+		WHERE b.link_id = ? AND l.deleted_at IS NULL
+		`, id).Scan(&body, &capturedAt, &addedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, time.Time{}, ErrNotFound
+	case err != nil:
+		return nil, time.Time{}, err
+	}
+	if capturedAt.Valid {
+		return body, capturedAt.Time, nil
+	}
+	return body, addedAt, nil
+}
+
+// FetchError records a failed fetch of a URL being added, so that past
+// failures can be reviewed later.
+type FetchError struct {
+	URL   string    `json:"url"`
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// fetchErrorRetention is how many fetch errors are kept; recording a new one
+// drops the oldest beyond this. Enough to review a bad day, small enough that
+// a site which keeps failing cannot grow the database.
+const fetchErrorRetention = 100
+
+// AddFetchError records a failed fetch of a URL.
+func (db *DB) AddFetchError(ctx context.Context, url string, message string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO fetch_errors (url, error) VALUES (?, ?)", url, message)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM fetch_errors
+		WHERE id NOT IN (SELECT id FROM fetch_errors ORDER BY id DESC LIMIT ?)
+		`, fetchErrorRetention)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFetchErrors returns the recorded fetch errors, newest first.
+func (db *DB) GetFetchErrors(ctx context.Context) ([]FetchError, error) {
+	rows, err := db.QueryContext(ctx, "SELECT url, error, at FROM fetch_errors ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fetchErrors []FetchError
+	for rows.Next() {
+		var fetchError FetchError
+		if err := rows.Scan(&fetchError.URL, &fetchError.Error, &fetchError.At); err != nil {
+			return nil, err
+		}
+		fetchErrors = append(fetchErrors, fetchError)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fetchErrors, nil
+}
+
+// ScreenshotRetry is one link whose screenshot capture failed, waiting for
+// the background retrier.
+type ScreenshotRetry struct {
+	LinkID      int64
+	URL         string
+	Attempts    int
+	LastAttempt time.Time
+}
+
+// RecordScreenshotFailure notes that capturing a link's screenshot failed,
+// counting the attempt. Returns ErrNotFound if no row with the given id is
+// found.
+func (db *DB) RecordScreenshotFailure(ctx context.Context, id int64) error {
+	// Selecting the id from links makes the insert a no-op for a missing
+	// link, like SetMeta.
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO screenshot_retries (link_id, attempts, last_attempt)
+		SELECT id, 1, ? FROM links WHERE id = ?
+		ON CONFLICT (link_id) DO UPDATE SET attempts = attempts + 1, last_attempt = excluded.last_attempt
+		`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ClearScreenshotFailure removes a link from the screenshot retries, after a
+// capture succeeded. Clearing a link with no recorded failure is a no-op.
+func (db *DB) ClearScreenshotFailure(ctx context.Context, id int64) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM screenshot_retries WHERE link_id = ?", id)
+	return err
+}
+
+// GetScreenshotRetries returns every link whose screenshot capture failed,
+// oldest failure first, with the link's URL joined in for the re-capture.
+func (db *DB) GetScreenshotRetries(ctx context.Context) ([]ScreenshotRetry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.link_id, l.url, r.attempts, r.last_attempt
+		FROM screenshot_retries r
+		INNER JOIN links l ON l.id = r.link_id
+		ORDER BY r.last_attempt`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var retries []ScreenshotRetry
+	for rows.Next() {
+		var retry ScreenshotRetry
+		if err := rows.Scan(&retry.LinkID, &retry.URL, &retry.Attempts, &retry.LastAttempt); err != nil {
+			return nil, err
+		}
+		retries = append(retries, retry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return retries, nil
+}
+
+// CountScreenshotRetries returns how many failed captures still wait for a
+// retry, not counting those which exhausted maxAttempts.
+func (db *DB) CountScreenshotRetries(ctx context.Context, maxAttempts int) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM screenshot_retries WHERE attempts < ?", maxAttempts).Scan(&count)
+	return count, err
+}
+
+// UpdateLinkBody replaces just the stored body of a link and re-indexes it,
+// leaving the title and description alone. For making links saved before
+// bodies were stored body-searchable again.
+// Returns ErrNotFound if no row with the given id is found.
+func (db *DB) UpdateLinkBody(ctx context.Context, id int64, body []byte) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var title, description string
+	err = tx.QueryRowContext(ctx, "SELECT title, description FROM links WHERE id = ?", id).Scan(&title, &description)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case err != nil:
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO link_bodies (link_id, body, captured_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (link_id) DO UPDATE SET body = excluded.body, captured_at = excluded.captured_at
+		`, id, body)
+	if err != nil {
+		return err
+	}
+
+	// The FTS index is contentless, so the row is rewritten in full with the
+	// unchanged title and description.
+	_, err = tx.ExecContext(ctx, "DELETE FROM links_fts WHERE rowid = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO links_fts(rowid, title, description, body) VALUES (?, ?, ?, ?)", id, title, description, body)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateShareToken makes a link publicly viewable through a token, creating
+// one if the link has none yet and returning the existing token otherwise.
+// Returns ErrNotFound if no row with the given id is found.
+func (db *DB) CreateShareToken(ctx context.Context, id int64) (string, error) {
+	var token string
+	err := db.QueryRowContext(ctx, "SELECT token FROM share_tokens WHERE link_id = ?", id).Scan(&token)
+	switch {
+	case err == nil:
+		return token, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return "", err
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token = hex.EncodeToString(raw)
+
+	// The insert selects from links, so a token cannot be created for a
+	// link which does not exist.
+	result, err := db.ExecContext(ctx, "INSERT INTO share_tokens (link_id, token) SELECT id, ? FROM links WHERE id = ?", token, id)
+	if err != nil {
+		return "", err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rowsAffected == 0 {
+		return "", ErrNotFound
+	}
+	return token, nil
+}
+
+// GetLinkByShareToken returns the link a share token points at.
+// Returns ErrNotFound for an unknown or revoked token.
+func (db *DB) GetLinkByShareToken(ctx context.Context, token string) (Link, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, "SELECT link_id FROM share_tokens WHERE token = ?", token).Scan(&id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Link{}, ErrNotFound
+	case err != nil:
+		return Link{}, err
+	}
+	return db.GetLink(ctx, id)
+}
+
+// RevokeShareToken removes the share token of a link, making it private
+// again. Returns ErrNotFound if the link has no token.
+func (db *DB) RevokeShareToken(ctx context.Context, id int64) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM share_tokens WHERE link_id = ?", id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetFavicon stores a link's favicon with its content type, replacing any
+// existing one. Returns ErrNotFound if no row with the given id is found.
+func (db *DB) SetFavicon(ctx context.Context, id int64, contentType string, favicon []byte) error {
+	// Selecting the id from links makes the insert a no-op for a missing
+	// link, so the existence check needs no separate query.
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO favicons (link_id, content_type, favicon)
+		SELECT id, ?, ? FROM links WHERE id = ?
+		ON CONFLICT (link_id) DO UPDATE SET content_type = excluded.content_type, favicon = excluded.favicon
+		`, contentType, favicon, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetFavicon returns a link's favicon and its content type. Returns
+// ErrNotFound when the link does not exist or has no favicon.
+func (db *DB) GetFavicon(ctx context.Context, id int64) ([]byte, string, error) {
+	var favicon []byte
+	var contentType string
+	err := db.QueryRowContext(ctx, "SELECT favicon, content_type FROM favicons WHERE link_id = ?", id).Scan(&favicon, &contentType)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, "", ErrNotFound
+	case err != nil:
+		return nil, "", err
+	}
+	return favicon, contentType, nil
+}
+
+// hashAPIToken is the stored form of an API token. SHA-256 is enough here,
+// unlike for passwords: the tokens are long random strings, which cannot be
+// found from their hash by guessing.
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateAPIToken mints an API token under the given name and returns it.
+// Only its hash is stored, so this is the one time the token is readable.
+// Returns ErrDuplicate if the name is already taken.
+func (db *DB) CreateAPIToken(ctx context.Context, name string) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", fmt.Errorf("token name must not be empty")
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	_, err := db.ExecContext(ctx, "INSERT INTO api_tokens (name, token_hash) VALUES (?, ?)", name, hashAPIToken(token))
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			return "", ErrDuplicate
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// CheckAPIToken reports whether token is a minted, unrevoked API token.
+// The check is one SHA-256 and a constant-time compare, so it is cheap
+// enough to run on every request, where bcrypt would not be.
+func (db *DB) CheckAPIToken(ctx context.Context, token string) (bool, error) {
+	hash := hashAPIToken(token)
+	var stored string
+	err := db.QueryRowContext(ctx, "SELECT token_hash FROM api_tokens WHERE token_hash = ?", hash).Scan(&stored)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(stored)) == 1, nil
+}
+
+// RevokeAPIToken deletes the named API token, so that CheckAPIToken no
+// longer accepts it. Returns ErrNotFound for an unknown name.
+func (db *DB) RevokeAPIToken(ctx context.Context, name string) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM api_tokens WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// BackupTo writes a consistent snapshot of the database to path using
+// VACUUM INTO, without blocking other readers or writers. VACUUM INTO
+// refuses to overwrite, so path must not exist yet.
+func (db *DB) BackupTo(ctx context.Context, path string) error {
+	_, err := db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
+// restoreSuffix marks a staged restore next to the database file.
+const restoreSuffix = ".restore"
+
+// StageRestore validates a backup and stages it next to the database file,
+// to be swapped in by InitDB at the next start. The live file is never
+// replaced while connections are open to it; until the restart the server
+// keeps serving the current data.
+func StageRestore(databaseFile string, backup io.Reader) error {
+	staged := databaseFile + restoreSuffix
+	tmp := staged + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, backup)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ValidateBackup(tmp)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, staged)
+}
+
+// ValidateBackup checks that path is a SQLite database with a compatible
+// links table. Only the original columns are required: columns added since
+// are filled in by the InitDB migrations.
+func ValidateBackup(path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	rows, err := db.Query("SELECT id, url, title, description, added_at FROM links LIMIT 1")
+	if err != nil {
+		return fmt.Errorf("not a compatible backup: %w", err)
+	}
+	return rows.Close()
+}
+
+// applyStagedRestore replaces the database file with a staged restore, if one
+// exists. The WAL and SHM files of the replaced database are removed as well,
+// they belong to the old file.
+func applyStagedRestore(databaseFile string) error {
+	staged := databaseFile + restoreSuffix
+	if _, err := os.Stat(staged); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := ValidateBackup(staged); err != nil {
+		return fmt.Errorf("staged restore %s is not usable: %w", staged, err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		_ = os.Remove(databaseFile + suffix)
+	}
+	return os.Rename(staged, databaseFile)
+}
+
+// LinkUpdate names the link fields UpdateLink changes; a nil field keeps the
+// stored value.
+type LinkUpdate struct {
+	URL         *string
+	Title       *string
+	Description *string
+}
+
+// UpdateLink updates the link fields named in update, and the FTS index
+// entry. A new URL colliding with another link returns ErrDuplicate. A nil
+// body keeps the stored one, pass a non-nil body to replace it.
+func (db *DB) UpdateLink(ctx context.Context, id int64, update LinkUpdate, body []byte) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var assignments []string
+	var args []any
+	if update.URL != nil {
+		assignments = append(assignments, "url = ?")
+		args = append(args, *update.URL)
+	}
+	if update.Title != nil {
+		assignments = append(assignments, "title = ?")
+		args = append(args, *update.Title)
+	}
+	if update.Description != nil {
+		assignments = append(assignments, "description = ?")
+		args = append(args, *update.Description)
+	}
+	if len(assignments) > 0 {
+		args = append(args, id)
+		result, err := tx.ExecContext(ctx, "UPDATE links SET "+strings.Join(assignments, ", ")+" WHERE id = ?", args...)
+		if err != nil {
+			var sqliteErr *sqlite.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+				return ErrDuplicate
+			}
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNotFound
+		}
+	}
+
+	// The FTS row is rewritten from the final values, so a partial update
+	// keeps the untouched fields indexed.
+	var title, description string
+	err = tx.QueryRowContext(ctx, "SELECT title, description FROM links WHERE id = ?", id).Scan(&title, &description)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO link_bodies (link_id, body, captured_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT (link_id) DO UPDATE SET body = excluded.body, captured_at = excluded.captured_at
+			`, id, body)
+		if err != nil {
+			return err
+		}
+	} else {
+		// The FTS index is contentless, so the row has to be written in full
+		// rather than updated in place. The body is not part of the edit, but
+		// must be supplied again to keep it searchable; it is missing for links
+		// added before link_bodies existed.
+		err = tx.QueryRowContext(ctx, "SELECT body FROM link_bodies WHERE link_id = ?", id).Scan(&body)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM links_fts WHERE rowid = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO links_fts(rowid, title, description, body) VALUES (?, ?, ?, ?)", id, title, description, body)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
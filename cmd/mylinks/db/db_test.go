@@ -0,0 +1,1398 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// titleAndDescription builds the LinkUpdate of a full edit, setting both
+// fields.
+func titleAndDescription(title, description string) LinkUpdate {
+	return LinkUpdate{Title: &title, Description: &description}
+}
+
+//goland:noinspection GoDirectComparisonOfErrors
+func TestDB(t *testing.T) {
+	// Use a temporary database file for testing, in a directory which is
+	// removed afterwards along with the WAL and shared memory files.
+	dbFile := filepath.Join(t.TempDir(), "test.database")
+
+	// Initialize the database
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+
+	t.Cleanup(func() {
+		if database != nil {
+			_ = database.Close()
+		}
+	})
+
+	// Verify the connection options which keep concurrent access working
+	var journalMode string
+	err = database.QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+	require.NoError(t, err, "Failed to query journal_mode")
+	assert.Equal(t, "wal", journalMode)
+	var busyTimeout int
+	err = database.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout)
+	require.NoError(t, err, "Failed to query busy_timeout")
+	assert.Positive(t, busyTimeout, "Expected a non-zero busy timeout")
+
+	// Test adding a link
+	url := "https://example.com"
+	title := "Example Website"
+	description := "This is an example website"
+	body := "<body><p>Some peculiar text in the body</p></body>"
+	id, err := database.AddLink(t.Context(), url, title, description, "", []byte(body))
+	require.NoError(t, err, "Failed to add link")
+	assert.Positive(t, id, "Got %d, expected positive ID", id)
+
+	// Test adding another link
+	url2 := "https://other.com"
+	title2 := "Fun page"
+	description2 := "Here some completely different content"
+	body2 := "<body><p>Other body data</p></body>"
+	id2, err := database.AddLink(t.Context(), url2, title2, description2, "", []byte(body2))
+	require.NoError(t, err, "Failed to add link 2")
+	assert.Positive(t, id2, "Got %d, expected positive ID", id)
+	assert.NotEqual(t, id, id2, "Expected different id")
+
+	// Test adding a link without a body
+	url3 := "https://empty.com"
+	title3 := "PDF document"
+	description3 := "application/pdf"
+	id3, err := database.AddLink(t.Context(), url3, title3, description3, "", nil)
+	require.NoError(t, err, "Failed to add link 3")
+	assert.Positive(t, id3, "Got %d, expected positive ID", id)
+	assert.NotEqual(t, id, id3, "Expected different id")
+	assert.NotEqual(t, id2, id3, "Expected different id")
+
+	// Test adding duplicate link
+	_, err = database.AddLink(t.Context(), url, "bogus", "", "", nil)
+	assert.ErrorIs(t, err, ErrDuplicate, "Expected error adding duplicate link")
+
+	// Test getting all links
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Len(t, links, 3, "Got %d links, expected 3", len(links))
+	assert.Equal(t, url, links[0].URL)
+	assert.Equal(t, title, links[0].Title)
+	assert.Equal(t, description, links[0].Description)
+	assert.False(t, links[0].AddedAt.IsZero(), "Expected non-zero AddedAt")
+
+	assert.Equal(t, url2, links[1].URL)
+	assert.Equal(t, title2, links[1].Title)
+	assert.Equal(t, description2, links[1].Description)
+	assert.False(t, links[1].AddedAt.IsZero(), "Expected non-zero AddedAt")
+
+	// Test search
+	linksSearch, err := database.Search(t.Context(), "peculiar")
+	require.NoError(t, err, "Failed to search")
+	assert.Len(t, linksSearch, 1, "Got %d links, expected 1", len(linksSearch))
+	assert.Equal(t, url, linksSearch[0].URL)
+	assert.Equal(t, title, linksSearch[0].Title)
+	assert.Equal(t, description, linksSearch[0].Description)
+	assert.False(t, linksSearch[0].AddedAt.IsZero(), "Expected single non-zero AddedAt")
+
+	// Test successful retrieval
+	link, err := database.GetLink(t.Context(), id)
+	assert.NoError(t, err, "Failed to get link")
+	assert.Equal(t, url, link.URL)
+	assert.Equal(t, title, link.Title)
+	assert.Equal(t, description, link.Description)
+	assert.False(t, link.AddedAt.IsZero(), "Expected single non-zero AddedAt")
+
+	// Test non-existent link
+	_, err = database.GetLink(t.Context(), 99999)
+	assert.ErrorIs(t, err, ErrNotFound, "Got %v, expected ErrNotFound for fetching non-existent link", err)
+
+	// Test updating a link
+	err = database.UpdateLink(t.Context(), id, titleAndDescription("Updated title", "Updated description"), nil)
+	require.NoError(t, err, "Failed to update link")
+	link, err = database.GetLink(t.Context(), id)
+	assert.NoError(t, err, "Failed to get updated link")
+	assert.Equal(t, "Updated title", link.Title)
+	assert.Equal(t, "Updated description", link.Description)
+
+	// The FTS index must follow the edit: the new text is searchable, the
+	// replaced text is not, and the body is still matched.
+	linksSearch, err = database.Search(t.Context(), "Updated")
+	require.NoError(t, err, "Failed to search for updated link")
+	assert.Len(t, linksSearch, 1, "Got %d links, expected the updated link", len(linksSearch))
+	assert.Equal(t, "Updated title", linksSearch[0].Title)
+
+	linksSearch, err = database.Search(t.Context(), "Example")
+	require.NoError(t, err, "Failed to search for replaced title")
+	assert.Empty(t, linksSearch, "Expected the replaced title to be gone from the index")
+
+	linksSearch, err = database.Search(t.Context(), "peculiar")
+	require.NoError(t, err, "Failed to search body after update")
+	assert.Len(t, linksSearch, 1, "Got %d links, expected the body to still be indexed", len(linksSearch))
+	assert.Equal(t, "Updated title", linksSearch[0].Title)
+
+	// Updating a link without a body must not fail.
+	err = database.UpdateLink(t.Context(), id3, titleAndDescription("Updated PDF", "application/pdf"), nil)
+	require.NoError(t, err, "Failed to update link without a body")
+	linksSearch, err = database.Search(t.Context(), "Updated")
+	require.NoError(t, err, "Failed to search after updating link without a body")
+	assert.Len(t, linksSearch, 2, "Got %d links, expected both updated links", len(linksSearch))
+
+	// Test updating a non-existing link
+	err = database.UpdateLink(t.Context(), 9999, titleAndDescription("Nothing", "Nothing"), nil)
+	assert.ErrorIs(t, err, ErrNotFound, "Got %v, expected ErrNotFound for updating non-existent link", err)
+
+	// Test which links have a body stored, so that a caller knows when a
+	// refetch is worthwhile
+	hasBody, err := database.HasBody(t.Context(), id)
+	require.NoError(t, err, "Failed to check for a body")
+	assert.True(t, hasBody, "Expected a body for a link added with one")
+	hasBody, err = database.HasBody(t.Context(), id3)
+	require.NoError(t, err, "Failed to check for a missing body")
+	assert.False(t, hasBody, "Expected no body for a link added without one")
+	_, err = database.HasBody(t.Context(), 9999)
+	assert.ErrorIs(t, err, ErrNotFound, "Got %v, expected ErrNotFound for a non-existent link", err)
+
+	// Test supplying a body on update, as a refetch does
+	err = database.UpdateLink(t.Context(), id3, titleAndDescription("Updated PDF", "application/pdf"), []byte("Refetched singular content"))
+	require.NoError(t, err, "Failed to update link with a refetched body")
+	hasBody, err = database.HasBody(t.Context(), id3)
+	require.NoError(t, err, "Failed to check for the refetched body")
+	assert.True(t, hasBody, "Expected the refetched body to be stored")
+	linksSearch, err = database.Search(t.Context(), "singular")
+	require.NoError(t, err, "Failed to search for the refetched body")
+	assert.Len(t, linksSearch, 1, "Got %d links, expected the refetched body to be indexed", len(linksSearch))
+	assert.Equal(t, url3, linksSearch[0].URL)
+
+	// A body supplied again replaces the stored one
+	err = database.UpdateLink(t.Context(), id3, titleAndDescription("Updated PDF", "application/pdf"), []byte("Replaced idiosyncratic content"))
+	require.NoError(t, err, "Failed to replace the body")
+	linksSearch, err = database.Search(t.Context(), "idiosyncratic")
+	require.NoError(t, err, "Failed to search for the replaced body")
+	assert.Len(t, linksSearch, 1, "Got %d links, expected the new body to be indexed", len(linksSearch))
+	linksSearch, err = database.Search(t.Context(), "singular")
+	require.NoError(t, err, "Failed to search for the previous body")
+	assert.Empty(t, linksSearch, "Expected the previous body to be gone from the index")
+
+	// A later update without a body carries the stored one forward
+	err = database.UpdateLink(t.Context(), id3, titleAndDescription("Updated PDF again", "application/pdf"), nil)
+	require.NoError(t, err, "Failed to update link after the refetch")
+	linksSearch, err = database.Search(t.Context(), "idiosyncratic")
+	require.NoError(t, err, "Failed to search for the carried over body")
+	assert.Len(t, linksSearch, 1, "Got %d links, expected the body to be carried over", len(linksSearch))
+
+	// Test deleting a link
+	err = database.DeleteLink(t.Context(), id)
+	require.NoError(t, err, "Failed to delete link")
+
+	// Test deleting a non-existing link
+	err = database.DeleteLink(t.Context(), 9999)
+	assert.ErrorIs(t, err, ErrNotFound, "Got %v, expected ErrNotFound for deleting non-existent link", err)
+
+	// Verify the link was deleted
+	links, err = database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links after deletion")
+	assert.Len(t, links, 2, "Got %d links after deletion, expected 2", len(links))
+
+	linksSearch, err = database.Search(t.Context(), "peculiar")
+	require.NoError(t, err, "Failed to search after deletion")
+	assert.Empty(t, linksSearch, "Expected the deleted link to be gone from the index")
+
+	// The body stays while the link sits in the trash, and goes with it when
+	// the trash is purged
+	var bodies int
+	err = database.QueryRow("SELECT count(*) FROM link_bodies WHERE link_id = ?", id).Scan(&bodies)
+	require.NoError(t, err, "Failed to count bodies")
+	assert.Equal(t, 1, bodies, "Expected the body to stay for a trashed link")
+	refs, err := database.PurgeDeleted(t.Context())
+	require.NoError(t, err, "Failed to purge trash")
+	require.Len(t, refs, 1, "Wrong number of purged links")
+	assert.Equal(t, id, refs[0].ID, "Wrong purged link")
+	err = database.QueryRow("SELECT count(*) FROM link_bodies WHERE link_id = ?", id).Scan(&bodies)
+	require.NoError(t, err, "Failed to count bodies")
+	assert.Zero(t, bodies, "Expected the body to be deleted with the purge")
+
+	// Close the database
+	err = database.Close()
+	require.NoError(t, err, "Failed to close database")
+
+	// Make the database file read-only
+	err = os.Chmod(dbFile, 0400)
+	require.NoError(t, err)
+
+	// Attempt to open the database again - should fail
+	database, err = InitDB(dbFile)
+	assert.Error(t, err, "Unable to detect read-only database")
+	if err == nil {
+		_ = database.Close()
+	}
+}
+
+// TestExpiry verifies that an expired link drops out of the default list but
+// stays reachable through GetExpiredLinks and GetLink.
+func TestExpiry(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_expiry.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com/event", "Event page", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// No expiry set: the link is listed and not expired
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Len(t, links, 1, "Got %d links, expected 1", len(links))
+	assert.Nil(t, links[0].ExpiresAt, "Expected no expiry")
+	expired, err := database.GetExpiredLinks(t.Context())
+	require.NoError(t, err, "Failed to get expired links")
+	assert.Empty(t, expired, "Expected no expired links")
+
+	// A future expiry keeps the link in the default list
+	future := time.Now().Add(time.Hour)
+	err = database.SetExpiry(t.Context(), id, &future)
+	require.NoError(t, err, "Failed to set expiry")
+	links, err = database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links with a future expiry")
+	assert.Len(t, links, 1, "Got %d links, expected the not yet expired link", len(links))
+	require.NotNil(t, links[0].ExpiresAt, "Expected the expiry to be returned")
+	assert.WithinDuration(t, future, *links[0].ExpiresAt, time.Second)
+
+	// A past expiry moves the link to the expired view
+	past := time.Now().Add(-time.Hour)
+	err = database.SetExpiry(t.Context(), id, &past)
+	require.NoError(t, err, "Failed to set a past expiry")
+	links, err = database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links with a past expiry")
+	assert.Empty(t, links, "Expected the expired link to be gone from the default list")
+	expired, err = database.GetExpiredLinks(t.Context())
+	require.NoError(t, err, "Failed to get expired links after expiry")
+	assert.Len(t, expired, 1, "Got %d expired links, expected 1", len(expired))
+
+	// The expired link is still directly reachable
+	link, err := database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get expired link")
+	assert.Equal(t, "Event page", link.Title)
+
+	// Clearing the expiry brings the link back
+	err = database.SetExpiry(t.Context(), id, nil)
+	require.NoError(t, err, "Failed to clear expiry")
+	links, err = database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links after clearing expiry")
+	assert.Len(t, links, 1, "Got %d links, expected the link back", len(links))
+
+	// Setting an expiry on a non-existent link reports ErrNotFound
+	err = database.SetExpiry(t.Context(), 9999, &past)
+	assert.ErrorIs(t, err, ErrNotFound, "Got %v, expected ErrNotFound for a non-existent link", err)
+}
+
+// TestLinkPositions verifies that manually positioned links sort ahead of the
+// rest in position order, and that clearing a position returns a link to date
+// ordering.
+func TestLinkPositions(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_positions.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	var ids []int64
+	for i := range 4 {
+		id, err := database.AddLink(t.Context(), fmt.Sprintf("https://example.com/%d", i), fmt.Sprintf("Page %d", i), "", "", nil)
+		require.NoError(t, err, "Failed to add link %d", i)
+		// Spread the timestamps out, adding within the same second would
+		// leave the date ordering ambiguous
+		_, err = database.ExecContext(t.Context(), "UPDATE links SET added_at = datetime('now', ?) WHERE id = ?", fmt.Sprintf("-%d minutes", 4-i), id)
+		require.NoError(t, err, "Failed to adjust added_at for link %d", i)
+		ids = append(ids, id)
+	}
+
+	// No positions set: newest first
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	require.Len(t, links, 4, "Wrong number of links")
+	assert.Equal(t, ids[3], links[0].ID, "Expected date ordering without positions")
+
+	// Position two links: they lead, in position order
+	position := func(p int64) *int64 { return &p }
+	require.NoError(t, database.SetLinkPosition(t.Context(), ids[0], position(2)), "Failed to set position")
+	require.NoError(t, database.SetLinkPosition(t.Context(), ids[2], position(1)), "Failed to set position")
+
+	links, err = database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	require.Len(t, links, 4, "Wrong number of links")
+	assert.Equal(t, ids[2], links[0].ID, "Expected the link at position 1 first")
+	assert.Equal(t, ids[0], links[1].ID, "Expected the link at position 2 second")
+	assert.Equal(t, ids[3], links[2].ID, "Expected the unpositioned links after, newest first")
+	assert.Equal(t, ids[1], links[3].ID, "Expected the oldest unpositioned link last")
+
+	// Clearing a position returns the link to date ordering
+	require.NoError(t, database.SetLinkPosition(t.Context(), ids[2], nil), "Failed to clear position")
+	links, err = database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Equal(t, ids[0], links[0].ID, "Expected only the remaining positioned link first")
+	assert.Nil(t, links[1].Position, "Expected the cleared position to be nil")
+
+	// An unknown id is reported
+	assert.ErrorIs(t, database.SetLinkPosition(t.Context(), 9999, position(1)), ErrNotFound, "Expected ErrNotFound for an unknown id")
+}
+
+// TestQueryLinks verifies that the query filters combine in one statement:
+// source together with a date range, sorting and pagination.
+func TestQueryLinks(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_query.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	var ids []int64
+	for i := range 6 {
+		source := ""
+		if i%2 == 0 {
+			source = "cli"
+		}
+		id, err := database.AddLink(t.Context(), fmt.Sprintf("https://example.com/%d", i), fmt.Sprintf("Page %d", i), "", source, nil)
+		require.NoError(t, err, "Failed to add link %d", i)
+		// Spread the timestamps out: link i was added 6-i days ago
+		_, err = database.ExecContext(t.Context(), "UPDATE links SET added_at = datetime('now', ?) WHERE id = ?", fmt.Sprintf("-%d days", 6-i), id)
+		require.NoError(t, err, "Failed to adjust added_at for link %d", i)
+		ids = append(ids, id)
+	}
+
+	t.Run("zero value lists everything", func(t *testing.T) {
+		links, err := database.QueryLinks(t.Context(), LinkQuery{})
+		require.NoError(t, err, "Failed to query links")
+		require.Len(t, links, 6, "Wrong number of links")
+		assert.Equal(t, ids[5], links[0].ID, "Expected newest first")
+	})
+
+	t.Run("source with date range and sort", func(t *testing.T) {
+		// The cli links added within the last 4.5 days: links 2 and 4
+		after := time.Now().AddDate(0, 0, -4).Add(-12 * time.Hour)
+		links, err := database.QueryLinks(t.Context(), LinkQuery{
+			Source:     "cli",
+			AddedAfter: &after,
+			Sort:       SortAdded,
+		})
+		require.NoError(t, err, "Failed to query links")
+		require.Len(t, links, 2, "Wrong number of links for the combined filters")
+		assert.Equal(t, ids[4], links[0].ID, "Expected the newest matching link first")
+		assert.Equal(t, ids[2], links[1].ID, "Expected the older matching link second")
+	})
+
+	t.Run("date range with both ends", func(t *testing.T) {
+		after := time.Now().AddDate(0, 0, -4).Add(-12 * time.Hour)
+		before := time.Now().AddDate(0, 0, -2).Add(-12 * time.Hour)
+		links, err := database.QueryLinks(t.Context(), LinkQuery{
+			AddedAfter:  &after,
+			AddedBefore: &before,
+		})
+		require.NoError(t, err, "Failed to query links")
+		require.Len(t, links, 2, "Wrong number of links in the range")
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		links, err := database.QueryLinks(t.Context(), LinkQuery{Sort: SortAdded, Limit: 2, Offset: 2})
+		require.NoError(t, err, "Failed to query links")
+		require.Len(t, links, 2, "Wrong page size")
+		assert.Equal(t, ids[3], links[0].ID, "Expected the page to start after the offset")
+
+		links, err = database.QueryLinks(t.Context(), LinkQuery{Sort: SortAdded, Offset: 5})
+		require.NoError(t, err, "Failed to query links")
+		require.Len(t, links, 1, "Expected an offset without a limit to work")
+		assert.Equal(t, ids[0], links[0].ID, "Expected the oldest link on the last page")
+	})
+}
+
+// TestSortOrders verifies each listing order: by date in both directions and
+// by title in both directions, case-insensitively.
+func TestSortOrders(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_sort.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	// Added oldest to newest, with titles out of alphabetical order and
+	// mixed case to exercise the case-insensitive collation
+	titles := []string{"cherry", "Apple", "banana"}
+	for i, title := range titles {
+		id, err := database.AddLink(t.Context(), fmt.Sprintf("https://example.com/%d", i), title, "", "", nil)
+		require.NoError(t, err, "Failed to add link %q", title)
+		require.NoError(t, database.SetAddedAt(t.Context(), id, time.Date(2026, 1, 1+i, 12, 0, 0, 0, time.UTC)), "Failed to set added_at for %q", title)
+	}
+
+	byTitle := func(links []Link) []string {
+		titles := make([]string, 0, len(links))
+		for _, link := range links {
+			titles = append(titles, link.Title)
+		}
+		return titles
+	}
+
+	tests := []struct {
+		name string
+		sort LinkSort
+		want []string
+	}{
+		{"newest first", SortAdded, []string{"banana", "Apple", "cherry"}},
+		{"oldest first", SortAddedAsc, []string{"cherry", "Apple", "banana"}},
+		{"title ascending", SortTitle, []string{"Apple", "banana", "cherry"}},
+		{"title descending", SortTitleDesc, []string{"cherry", "banana", "Apple"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			links, err := database.GetAllLinksSorted(t.Context(), test.sort)
+			require.NoError(t, err, "Failed to get links")
+			assert.Equal(t, test.want, byTitle(links), "Wrong order")
+		})
+	}
+}
+
+// TestLinkMeta verifies storing, overwriting, removing and retrieving the
+// key/value metadata of a link.
+func TestLinkMeta(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_meta.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// A link starts without metadata
+	meta, err := database.GetMeta(t.Context(), id)
+	require.NoError(t, err, "Failed to get metadata")
+	assert.Nil(t, meta, "Expected no metadata on a new link")
+
+	require.NoError(t, database.SetMeta(t.Context(), id, "author", "Jane Doe"), "Failed to set metadata")
+	require.NoError(t, database.SetMeta(t.Context(), id, "published", "2024-05-01"), "Failed to set metadata")
+
+	meta, err = database.GetMeta(t.Context(), id)
+	require.NoError(t, err, "Failed to get metadata")
+	assert.Equal(t, map[string]string{"author": "Jane Doe", "published": "2024-05-01"}, meta, "Wrong metadata")
+
+	// GetLink carries the metadata along
+	link, err := database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get link")
+	assert.Equal(t, meta, link.Meta, "Expected GetLink to include the metadata")
+
+	// Setting an existing key replaces its value
+	require.NoError(t, database.SetMeta(t.Context(), id, "author", "John Doe"), "Failed to overwrite metadata")
+	meta, err = database.GetMeta(t.Context(), id)
+	require.NoError(t, err, "Failed to get metadata")
+	assert.Equal(t, "John Doe", meta["author"], "Expected the value to be replaced")
+
+	// An empty value removes the key
+	require.NoError(t, database.SetMeta(t.Context(), id, "author", ""), "Failed to remove metadata")
+	meta, err = database.GetMeta(t.Context(), id)
+	require.NoError(t, err, "Failed to get metadata")
+	assert.Equal(t, map[string]string{"published": "2024-05-01"}, meta, "Expected the key to be removed")
+
+	// An unknown id is reported
+	assert.ErrorIs(t, database.SetMeta(t.Context(), 9999, "author", "Nobody"), ErrNotFound, "Expected ErrNotFound for an unknown id")
+
+	// Purging a deleted link deletes its metadata
+	require.NoError(t, database.DeleteLink(t.Context(), id), "Failed to delete link")
+	_, err = database.PurgeDeleted(t.Context())
+	require.NoError(t, err, "Failed to purge trash")
+	meta, err = database.GetMeta(t.Context(), id)
+	require.NoError(t, err, "Failed to get metadata")
+	assert.Nil(t, meta, "Expected the metadata to be deleted with the link")
+}
+
+// TestGetLinksPage verifies paging through the links newest first, that a
+// page past the end is empty rather than an error, and that CountLinks
+// counts the unexpired links.
+func TestGetLinksPage(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_links_page.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	for i := 1; i <= 3; i++ {
+		id, err := database.AddLink(t.Context(), fmt.Sprintf("https://example.com/%d", i), fmt.Sprintf("Link %d", i), "", "", nil)
+		require.NoError(t, err, "Failed to add link")
+		// added_at only has second precision, so links added in one test
+		// run would tie; spread them out for a deterministic order.
+		_, err = database.ExecContext(t.Context(), "UPDATE links SET added_at = ? WHERE id = ?", time.Now().UTC().Add(time.Duration(i)*time.Minute), id)
+		require.NoError(t, err, "Failed to spread added times")
+	}
+
+	links, err := database.GetLinksPage(t.Context(), 2, 0)
+	require.NoError(t, err, "Failed to get page")
+	require.Len(t, links, 2, "Wrong page size")
+	assert.Equal(t, "Link 3", links[0].Title, "Expected the newest link first")
+	assert.Equal(t, "Link 2", links[1].Title, "Expected the second newest link next")
+
+	links, err = database.GetLinksPage(t.Context(), 2, 2)
+	require.NoError(t, err, "Failed to get page")
+	require.Len(t, links, 1, "Wrong size for the last page")
+	assert.Equal(t, "Link 1", links[0].Title, "Expected the oldest link last")
+
+	// A page past the end is empty, not an error
+	links, err = database.GetLinksPage(t.Context(), 2, 10)
+	require.NoError(t, err, "Failed to get page")
+	assert.Empty(t, links, "Expected an empty page past the end")
+
+	count, err := database.CountLinks(t.Context())
+	require.NoError(t, err, "Failed to count links")
+	assert.Equal(t, 3, count, "Wrong link count")
+
+	// An expired link drops out of the count
+	expired := time.Now().Add(-time.Hour)
+	require.NoError(t, database.SetExpiry(t.Context(), 1, &expired), "Failed to expire link")
+	count, err = database.CountLinks(t.Context())
+	require.NoError(t, err, "Failed to count links")
+	assert.Equal(t, 2, count, "Expected the expired link not to be counted")
+}
+
+// TestTags verifies tagging links, filtering by tag, normalization, and
+// that deleting a link drops its tag associations.
+func TestTags(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_tags.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	first, err := database.AddLink(t.Context(), "https://example.com/go", "Go Article", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	second, err := database.AddLink(t.Context(), "https://example.com/web", "Web Article", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// A link starts without tags
+	tags, err := database.GetTags(t.Context(), first)
+	require.NoError(t, err, "Failed to get tags")
+	assert.Nil(t, tags, "Expected no tags on a new link")
+
+	// Tags are normalized to lowercase and trimmed, duplicates are no-ops
+	require.NoError(t, database.AddTag(t.Context(), first, " Go "), "Failed to add tag")
+	require.NoError(t, database.AddTag(t.Context(), first, "programming"), "Failed to add tag")
+	require.NoError(t, database.AddTag(t.Context(), first, "go"), "Failed to re-add tag")
+	require.NoError(t, database.AddTag(t.Context(), second, "programming"), "Failed to add tag")
+
+	tags, err = database.GetTags(t.Context(), first)
+	require.NoError(t, err, "Failed to get tags")
+	assert.Equal(t, []string{"go", "programming"}, tags, "Wrong tags")
+
+	// GetLink and the list queries carry the tags along
+	link, err := database.GetLink(t.Context(), first)
+	require.NoError(t, err, "Failed to get link")
+	assert.Equal(t, tags, link.Tags, "Expected GetLink to include the tags")
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	require.Len(t, links, 2, "Wrong number of links")
+	tagsByID := map[int64][]string{}
+	for _, l := range links {
+		tagsByID[l.ID] = l.Tags
+	}
+	assert.Equal(t, []string{"go", "programming"}, tagsByID[first], "Expected the first link's tags in the listing")
+	assert.Equal(t, []string{"programming"}, tagsByID[second], "Expected the second link's tags in the listing")
+
+	// GetLinksByTag filters, with the tag normalized like AddTag
+	links, err = database.GetLinksByTag(t.Context(), "GO ")
+	require.NoError(t, err, "Failed to get links by tag")
+	require.Len(t, links, 1, "Wrong number of links for the tag")
+	assert.Equal(t, first, links[0].ID, "Wrong link for the tag")
+	links, err = database.GetLinksByTag(t.Context(), "programming")
+	require.NoError(t, err, "Failed to get links by tag")
+	assert.Len(t, links, 2, "Wrong number of links for the shared tag")
+	links, err = database.GetLinksByTag(t.Context(), "nosuchtag")
+	require.NoError(t, err, "Failed to get links by tag")
+	assert.Empty(t, links, "Expected no links for an unknown tag")
+
+	// Removing a tag, and removing one the link does not have
+	require.NoError(t, database.RemoveTag(t.Context(), first, "go"), "Failed to remove tag")
+	require.NoError(t, database.RemoveTag(t.Context(), first, "nosuchtag"), "Expected removing an absent tag to be a no-op")
+	tags, err = database.GetTags(t.Context(), first)
+	require.NoError(t, err, "Failed to get tags")
+	assert.Equal(t, []string{"programming"}, tags, "Expected only the remaining tag")
+
+	// An empty tag and an unknown id are reported
+	assert.Error(t, database.AddTag(t.Context(), first, "  "), "Expected an error for an empty tag")
+	assert.ErrorIs(t, database.AddTag(t.Context(), 9999, "orphan"), ErrNotFound, "Expected ErrNotFound for an unknown id")
+
+	// Purging a deleted link deletes its tag associations
+	require.NoError(t, database.DeleteLink(t.Context(), first), "Failed to delete link")
+	_, err = database.PurgeDeleted(t.Context())
+	require.NoError(t, err, "Failed to purge trash")
+	tags, err = database.GetTags(t.Context(), first)
+	require.NoError(t, err, "Failed to get tags")
+	assert.Nil(t, tags, "Expected the tags to be deleted with the link")
+}
+
+// TestScreenshotRetries verifies recording, counting and clearing failed
+// screenshot captures, and that deleting a link drops its retry.
+func TestScreenshotRetries(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_screenshot_retries.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// Repeated failures count attempts on one row
+	require.NoError(t, database.RecordScreenshotFailure(t.Context(), id), "Failed to record failure")
+	require.NoError(t, database.RecordScreenshotFailure(t.Context(), id), "Failed to record failure")
+	retries, err := database.GetScreenshotRetries(t.Context())
+	require.NoError(t, err, "Failed to get retries")
+	require.Len(t, retries, 1, "Wrong number of retries")
+	assert.Equal(t, id, retries[0].LinkID, "Wrong link")
+	assert.Equal(t, "https://example.com", retries[0].URL, "Wrong URL")
+	assert.Equal(t, 2, retries[0].Attempts, "Wrong attempt count")
+	assert.WithinDuration(t, time.Now(), retries[0].LastAttempt, time.Minute, "Wrong attempt time")
+
+	// The count excludes retries which exhausted their attempts
+	count, err := database.CountScreenshotRetries(t.Context(), 5)
+	require.NoError(t, err, "Failed to count retries")
+	assert.Equal(t, 1, count, "Wrong pending count")
+	count, err = database.CountScreenshotRetries(t.Context(), 2)
+	require.NoError(t, err, "Failed to count retries")
+	assert.Equal(t, 0, count, "Expected an exhausted retry not to be counted")
+
+	// An unknown id is reported
+	assert.ErrorIs(t, database.RecordScreenshotFailure(t.Context(), 9999), ErrNotFound, "Expected ErrNotFound for an unknown id")
+
+	// Clearing removes the row, clearing again is a no-op
+	require.NoError(t, database.ClearScreenshotFailure(t.Context(), id), "Failed to clear failure")
+	require.NoError(t, database.ClearScreenshotFailure(t.Context(), id), "Expected clearing an absent failure to be a no-op")
+	retries, err = database.GetScreenshotRetries(t.Context())
+	require.NoError(t, err, "Failed to get retries")
+	assert.Empty(t, retries, "Expected no retries after clearing")
+
+	// Purging a deleted link deletes its retry
+	require.NoError(t, database.RecordScreenshotFailure(t.Context(), id), "Failed to record failure")
+	require.NoError(t, database.DeleteLink(t.Context(), id), "Failed to delete link")
+	_, err = database.PurgeDeleted(t.Context())
+	require.NoError(t, err, "Failed to purge trash")
+	retries, err = database.GetScreenshotRetries(t.Context())
+	require.NoError(t, err, "Failed to get retries")
+	assert.Empty(t, retries, "Expected the retry to be deleted with the link")
+}
+
+// TestFavicons verifies storing, replacing and serving favicons, and that a
+// link's favicon is deleted with it.
+func TestFavicons(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_favicons.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// Setting stores the icon, setting again replaces it
+	require.NoError(t, database.SetFavicon(t.Context(), id, "image/png", []byte("png bytes")), "Failed to set favicon")
+	favicon, contentType, err := database.GetFavicon(t.Context(), id)
+	require.NoError(t, err, "Failed to get favicon")
+	assert.Equal(t, "png bytes", string(favicon), "Wrong favicon content")
+	assert.Equal(t, "image/png", contentType, "Wrong content type")
+	require.NoError(t, database.SetFavicon(t.Context(), id, "image/x-icon", []byte("ico bytes")), "Failed to replace favicon")
+	favicon, contentType, err = database.GetFavicon(t.Context(), id)
+	require.NoError(t, err, "Failed to get favicon")
+	assert.Equal(t, "ico bytes", string(favicon), "Expected the favicon to be replaced")
+	assert.Equal(t, "image/x-icon", contentType, "Expected the content type to be replaced")
+
+	// Unknown ids are reported, for setting and getting alike
+	assert.ErrorIs(t, database.SetFavicon(t.Context(), 9999, "image/png", []byte("x")), ErrNotFound, "Expected ErrNotFound for an unknown id")
+	_, _, err = database.GetFavicon(t.Context(), 9999)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected ErrNotFound for an unknown id")
+
+	// Purging a deleted link deletes its favicon
+	require.NoError(t, database.DeleteLink(t.Context(), id), "Failed to delete link")
+	_, err = database.PurgeDeleted(t.Context())
+	require.NoError(t, err, "Failed to purge trash")
+	_, _, err = database.GetFavicon(t.Context(), id)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected the favicon to be deleted with the purge")
+}
+
+// TestAPITokens verifies minting, checking and revoking API tokens.
+func TestAPITokens(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_api_tokens.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	// A minted token checks out, anything else does not
+	token, err := database.CreateAPIToken(t.Context(), "ci")
+	require.NoError(t, err, "Failed to mint token")
+	require.NotEmpty(t, token, "Expected a token")
+	valid, err := database.CheckAPIToken(t.Context(), token)
+	require.NoError(t, err, "Failed to check token")
+	assert.True(t, valid, "Expected the minted token to be valid")
+	valid, err = database.CheckAPIToken(t.Context(), "not-a-token")
+	require.NoError(t, err, "Failed to check token")
+	assert.False(t, valid, "Expected an unknown token to be invalid")
+
+	// Names are unique, and an empty name is rejected
+	_, err = database.CreateAPIToken(t.Context(), "ci")
+	assert.ErrorIs(t, err, ErrDuplicate, "Expected ErrDuplicate for a taken name")
+	_, err = database.CreateAPIToken(t.Context(), " ")
+	assert.Error(t, err, "Expected an error for an empty name")
+
+	// Revoking invalidates the token; revoking an unknown name is reported
+	require.NoError(t, database.RevokeAPIToken(t.Context(), "ci"), "Failed to revoke token")
+	valid, err = database.CheckAPIToken(t.Context(), token)
+	require.NoError(t, err, "Failed to check token")
+	assert.False(t, valid, "Expected a revoked token to be invalid")
+	assert.ErrorIs(t, database.RevokeAPIToken(t.Context(), "ci"), ErrNotFound, "Expected ErrNotFound for an unknown name")
+}
+
+// TestTrash verifies the soft-delete lifecycle: a deleted link hides from the
+// listings and search but stays in the trash, a restore brings it back
+// searchable, and re-adding a trashed URL replaces the trashed copy.
+func TestTrash(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_trash.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example", "A description", "", []byte("Searchable body"))
+	require.NoError(t, err, "Failed to add link")
+
+	require.NoError(t, database.DeleteLink(t.Context(), id), "Failed to delete link")
+
+	// The link is hidden everywhere except the trash
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Empty(t, links, "Expected a trashed link not to list")
+	count, err := database.CountLinks(t.Context())
+	require.NoError(t, err, "Failed to count links")
+	assert.Zero(t, count, "Expected a trashed link not to count")
+	_, err = database.GetLink(t.Context(), id)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected ErrNotFound for a trashed link")
+	found, err := database.Search(t.Context(), "searchable")
+	require.NoError(t, err, "Failed to search")
+	assert.Empty(t, found, "Expected a trashed link to be gone from the index")
+	trash, err := database.GetDeletedLinks(t.Context())
+	require.NoError(t, err, "Failed to get trash")
+	require.Len(t, trash, 1, "Wrong trash size")
+	assert.Equal(t, id, trash[0].ID, "Wrong link in the trash")
+
+	// Deleting a trashed or unknown link is reported
+	assert.ErrorIs(t, database.DeleteLink(t.Context(), id), ErrNotFound, "Expected ErrNotFound for a link already in the trash")
+
+	// Restoring brings the link back, searchable again
+	require.NoError(t, database.RestoreLink(t.Context(), id), "Failed to restore link")
+	link, err := database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get restored link")
+	assert.Equal(t, "Example", link.Title, "Wrong restored link")
+	found, err = database.Search(t.Context(), "searchable")
+	require.NoError(t, err, "Failed to search after restore")
+	assert.Len(t, found, 1, "Expected a restored link to be indexed again")
+	trash, err = database.GetDeletedLinks(t.Context())
+	require.NoError(t, err, "Failed to get trash")
+	assert.Empty(t, trash, "Expected the trash to be empty after restore")
+
+	// Restoring a link which is not in the trash is reported
+	assert.ErrorIs(t, database.RestoreLink(t.Context(), id), ErrNotFound, "Expected ErrNotFound for a link not in the trash")
+
+	// Re-adding a trashed URL replaces the trashed copy
+	require.NoError(t, database.DeleteLink(t.Context(), id), "Failed to delete link")
+	newID, err := database.AddLink(t.Context(), "https://example.com", "Example again", "", "", nil)
+	require.NoError(t, err, "Failed to re-add a trashed URL")
+	link, err = database.GetLink(t.Context(), newID)
+	require.NoError(t, err, "Failed to get re-added link")
+	assert.Equal(t, "Example again", link.Title, "Expected the re-added copy, not the trashed one")
+	trash, err = database.GetDeletedLinks(t.Context())
+	require.NoError(t, err, "Failed to get trash")
+	assert.Empty(t, trash, "Expected the trashed copy to be replaced by the re-add")
+}
+
+// TestDeleteLinks verifies that a batch delete moves the given links to the
+// trash in one go, skipping ids without a link instead of failing.
+func TestDeleteLinks(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_delete_links.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	first, err := database.AddLink(t.Context(), "https://example.com/first", "First", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	second, err := database.AddLink(t.Context(), "https://example.com/second", "Second", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	third, err := database.AddLink(t.Context(), "https://example.com/third", "Third", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// An id without a link is skipped, not an error
+	deleted, err := database.DeleteLinks(t.Context(), []int64{first, second, 9999})
+	require.NoError(t, err, "Failed to delete links")
+	assert.Equal(t, 2, deleted, "Expected the two existing links deleted")
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	require.Len(t, links, 1, "Expected one link to remain")
+	assert.Equal(t, third, links[0].ID, "Wrong link remained")
+
+	trashed, err := database.GetDeletedLinks(t.Context())
+	require.NoError(t, err, "Failed to get deleted links")
+	assert.Len(t, trashed, 2, "Expected the deleted links in the trash")
+
+	// A second round finds the links already in the trash
+	deleted, err = database.DeleteLinks(t.Context(), []int64{first, second})
+	require.NoError(t, err, "Failed to delete links")
+	assert.Zero(t, deleted, "Expected already trashed links to be skipped")
+}
+
+// TestSetRead verifies that a link starts unread, can be marked read and
+// back, and that the unread filter composes with the link queries.
+func TestSetRead(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_set_read.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	other, err := database.AddLink(t.Context(), "https://example.com/other", "Other", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	link, err := database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get link")
+	assert.False(t, link.Read, "Expected a new link to start unread")
+
+	require.NoError(t, database.SetRead(t.Context(), id, true), "Failed to mark link read")
+	link, err = database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get link")
+	assert.True(t, link.Read, "Expected the link to be read")
+
+	// The unread filter leaves only the other link
+	links, err := database.QueryLinks(t.Context(), LinkQuery{Unread: true})
+	require.NoError(t, err, "Failed to query links")
+	require.Len(t, links, 1, "Expected one unread link")
+	assert.Equal(t, other, links[0].ID, "Wrong link unread")
+
+	// Marking it unread again brings it back
+	require.NoError(t, database.SetRead(t.Context(), id, false), "Failed to mark link unread")
+	links, err = database.QueryLinks(t.Context(), LinkQuery{Unread: true})
+	require.NoError(t, err, "Failed to query links")
+	assert.Len(t, links, 2, "Expected both links unread")
+
+	assert.ErrorIs(t, database.SetRead(t.Context(), 9999, true), ErrNotFound, "Expected ErrNotFound for an unknown id")
+}
+
+// TestCheckResult verifies that a dead-link check result is recorded and
+// that the dead filter surfaces failed links: a 4xx status or a connection
+// failure counts, a healthy or never-checked link does not.
+func TestCheckResult(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_check_result.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	alive, err := database.AddLink(t.Context(), "https://example.com/alive", "Alive", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	gone, err := database.AddLink(t.Context(), "https://example.com/gone", "Gone", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	unreachable, err := database.AddLink(t.Context(), "https://example.com/unreachable", "Unreachable", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://example.com/unchecked", "Unchecked", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	require.NoError(t, database.SetCheckResult(t.Context(), alive, 200), "Failed to record check")
+	require.NoError(t, database.SetCheckResult(t.Context(), gone, 404), "Failed to record check")
+	require.NoError(t, database.SetCheckResult(t.Context(), unreachable, 0), "Failed to record check")
+
+	link, err := database.GetLink(t.Context(), gone)
+	require.NoError(t, err, "Failed to get link")
+	require.NotNil(t, link.LastCheckStatus, "Expected a recorded check status")
+	assert.Equal(t, int64(404), *link.LastCheckStatus, "Wrong check status")
+	require.NotNil(t, link.LastCheckAt, "Expected a recorded check time")
+	assert.WithinDuration(t, time.Now(), *link.LastCheckAt, time.Minute, "Expected the check time to be now")
+
+	links, err := database.QueryLinks(t.Context(), LinkQuery{Dead: true})
+	require.NoError(t, err, "Failed to query dead links")
+	require.Len(t, links, 2, "Expected the failed and the unreachable link")
+	ids := []int64{links[0].ID, links[1].ID}
+	assert.Contains(t, ids, gone, "Expected the link with a 404")
+	assert.Contains(t, ids, unreachable, "Expected the link whose connection failed")
+
+	assert.ErrorIs(t, database.SetCheckResult(t.Context(), 9999, 200), ErrNotFound, "Expected ErrNotFound for an unknown id")
+}
+
+// TestStats verifies the collection summary: the totals and recency windows,
+// distinct hosts counted without notes, the top tags most used first, and
+// that trashed links count nowhere.
+func TestStats(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_stats.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	recent, err := database.AddLink(t.Context(), "https://a.example.com/one", "Recent", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	older, err := database.AddLink(t.Context(), "https://a.example.com/two", "Older", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.SetAddedAt(t.Context(), older, time.Now().AddDate(0, 0, -10)), "Failed to age link")
+	oldest, err := database.AddLink(t.Context(), "https://b.example.com/", "Oldest", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.SetAddedAt(t.Context(), oldest, time.Now().AddDate(0, 0, -40)), "Failed to age link")
+	_, err = database.AddLink(t.Context(), "note:12345", "A note", "", "", nil)
+	require.NoError(t, err, "Failed to add note")
+	trashed, err := database.AddLink(t.Context(), "https://c.example.com/", "Trashed", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.DeleteLink(t.Context(), trashed), "Failed to trash link")
+
+	require.NoError(t, database.AddTag(t.Context(), recent, "go"), "Failed to tag link")
+	require.NoError(t, database.AddTag(t.Context(), older, "go"), "Failed to tag link")
+	require.NoError(t, database.AddTag(t.Context(), recent, "web"), "Failed to tag link")
+
+	stats, err := database.Stats(t.Context())
+	require.NoError(t, err, "Failed to get stats")
+	assert.Equal(t, 4, stats.Total, "Wrong total, the trashed link must not count")
+	assert.Equal(t, 2, stats.AddedLast7Days, "Wrong 7 day count")
+	assert.Equal(t, 3, stats.AddedLast30Days, "Wrong 30 day count")
+	assert.Equal(t, 2, stats.DistinctHosts, "Wrong host count, notes and trashed links must not count")
+	assert.Equal(t, []TagCount{{Name: "go", Count: 2}, {Name: "web", Count: 1}}, stats.TopTags, "Wrong top tags")
+}
+
+// TestGetBody verifies that a stored body can be read back with its capture
+// time, and that links without one report ErrNotFound.
+func TestGetBody(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_get_body.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example", "", "", []byte("The stored page text"))
+	require.NoError(t, err, "Failed to add link with a body")
+	bodyless, err := database.AddLink(t.Context(), "https://example.com/pdf", "A PDF", "", "", nil)
+	require.NoError(t, err, "Failed to add link without a body")
+
+	body, capturedAt, err := database.GetBody(t.Context(), id)
+	require.NoError(t, err, "Failed to get body")
+	assert.Equal(t, []byte("The stored page text"), body, "Wrong body")
+	assert.WithinDuration(t, time.Now(), capturedAt, time.Minute, "Expected the capture time to be now")
+
+	// A refetched body replaces both the text and the capture time
+	err = database.UpdateLinkBody(t.Context(), id, []byte("The refetched page text"))
+	require.NoError(t, err, "Failed to replace the body")
+	body, capturedAt, err = database.GetBody(t.Context(), id)
+	require.NoError(t, err, "Failed to get replaced body")
+	assert.Equal(t, []byte("The refetched page text"), body, "Wrong replaced body")
+	assert.WithinDuration(t, time.Now(), capturedAt, time.Minute, "Expected the capture time to follow the refetch")
+
+	_, _, err = database.GetBody(t.Context(), bodyless)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected ErrNotFound for a link without a body")
+	_, _, err = database.GetBody(t.Context(), 9999)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected ErrNotFound for an unknown id")
+
+	// A trashed link keeps its body out of reach until restored
+	require.NoError(t, database.DeleteLink(t.Context(), id), "Failed to trash link")
+	_, _, err = database.GetBody(t.Context(), id)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected ErrNotFound for a trashed link")
+}
+
+// TestDedupeTitles verifies that title deduplication rejects a second link
+// with a matching normalized title, and only when it is enabled.
+func TestDedupeTitles(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_dedupe_titles.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	_, err = database.AddLink(t.Context(), "https://example.com/canonical", "Example Article", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// Off by default: the same title from another URL is allowed
+	_, err = database.AddLink(t.Context(), "https://amp.example.com/amp", "Example Article", "", "", nil)
+	require.NoError(t, err, "Expected a duplicate title to be allowed by default")
+
+	database.DedupeTitles = true
+
+	// A matching title is rejected, regardless of case and surrounding space
+	_, err = database.AddLink(t.Context(), "https://mirror.example.com/copy", "  example ARTICLE ", "", "", nil)
+	assert.ErrorIs(t, err, ErrDuplicateTitle, "Expected ErrDuplicateTitle for a matching title")
+
+	// A URL duplicate is still reported as such
+	_, err = database.AddLink(t.Context(), "https://example.com/canonical", "Something else", "", "", nil)
+	assert.ErrorIs(t, err, ErrDuplicate, "Expected ErrDuplicate for a duplicate URL")
+
+	// A different title is still allowed
+	_, err = database.AddLink(t.Context(), "https://example.com/other", "Another Article", "", "", nil)
+	require.NoError(t, err, "Failed to add link with a different title")
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Len(t, links, 3, "Got %d links, expected 3", len(links))
+}
+
+// TestUpdateLinkFields verifies that UpdateLink changes only the fields
+// named in the update, that a URL edit enforces uniqueness with ErrDuplicate,
+// and that the FTS index follows a partial update.
+func TestUpdateLinkFields(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_update_fields.database")
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	id, err := database.AddLink(t.Context(), "https://example.com/one", "First Title", "Original description", "", []byte("alpha body"))
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://example.com/two", "Second Title", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// Only the description changes, the title stays.
+	newDescription := "Corrected description"
+	err = database.UpdateLink(t.Context(), id, LinkUpdate{Description: &newDescription}, nil)
+	require.NoError(t, err, "Failed to update the description")
+	link, err := database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get link")
+	assert.Equal(t, "First Title", link.Title, "The title should be untouched")
+	assert.Equal(t, newDescription, link.Description, "Wrong description")
+
+	// The FTS row keeps the untouched title and the body.
+	links, err := database.Search(t.Context(), "Corrected")
+	require.NoError(t, err, "Failed to search for the new description")
+	assert.Len(t, links, 1, "Got %d links, expected the edited link", len(links))
+	links, err = database.Search(t.Context(), "alpha")
+	require.NoError(t, err, "Failed to search the body")
+	assert.Len(t, links, 1, "Got %d links, expected the body to still be indexed", len(links))
+	links, err = database.Search(t.Context(), "Original")
+	require.NoError(t, err, "Failed to search for the replaced description")
+	assert.Empty(t, links, "Expected the replaced description to be gone from the index")
+
+	// A URL edit to a free URL works.
+	newURL := "https://example.com/moved"
+	err = database.UpdateLink(t.Context(), id, LinkUpdate{URL: &newURL}, nil)
+	require.NoError(t, err, "Failed to update the URL")
+	link, err = database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get link")
+	assert.Equal(t, newURL, link.URL, "Wrong URL")
+
+	// A URL colliding with another link reports the duplicate.
+	takenURL := "https://example.com/two"
+	err = database.UpdateLink(t.Context(), id, LinkUpdate{URL: &takenURL}, nil)
+	assert.ErrorIs(t, err, ErrDuplicate, "Got %v, expected ErrDuplicate for a taken URL", err)
+
+	// An empty update of a missing link still reports it.
+	err = database.UpdateLink(t.Context(), 9999, LinkUpdate{}, nil)
+	assert.ErrorIs(t, err, ErrNotFound, "Got %v, expected ErrNotFound for a non-existent link", err)
+}
+
+// TestSearchInField verifies that a search can be scoped to a single field,
+// both through SearchInField directly and through a field-qualified search
+// string.
+func TestSearchInField(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_search_field.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	url := "https://example.com"
+	_, err = database.AddLink(t.Context(), url, "Aardvark facts", "Mostly about zebras", "", []byte("<body>quokka habitats</body>"))
+	require.NoError(t, err, "Failed to add link")
+
+	// Each term matches in its own field only
+	for field, term := range map[string]string{"title": "Aardvark", "description": "zebras", "body": "quokka"} {
+		links, err := database.SearchInField(t.Context(), field, term)
+		require.NoError(t, err, "Failed to search %s for %q", field, term)
+		assert.Len(t, links, 1, "Got %d links searching %s for %q, expected 1", len(links), field, term)
+
+		links, err = database.SearchInField(t.Context(), field, "penguin")
+		require.NoError(t, err, "Failed to search %s for a missing term", field)
+		assert.Empty(t, links, "Expected no match searching %s for a missing term", field)
+	}
+
+	// A term from another field must not match
+	links, err := database.SearchInField(t.Context(), "title", "zebras")
+	require.NoError(t, err, "Failed to search title for a description term")
+	assert.Empty(t, links, "Expected no match searching title for a description term")
+
+	// Only the index columns are accepted as fields
+	_, err = database.SearchInField(t.Context(), "url", "example")
+	assert.Error(t, err, "Expected error for an invalid search field")
+
+	// A field-qualified search string scopes Search the same way
+	links, err = database.Search(t.Context(), "title:Aardvark")
+	require.NoError(t, err, "Failed to search with a title qualifier")
+	assert.Len(t, links, 1, "Got %d links for title qualifier, expected 1", len(links))
+
+	links, err = database.Search(t.Context(), "desc:zebras")
+	require.NoError(t, err, "Failed to search with a desc qualifier")
+	assert.Len(t, links, 1, "Got %d links for desc qualifier, expected 1", len(links))
+
+	links, err = database.Search(t.Context(), "body:Aardvark")
+	require.NoError(t, err, "Failed to search with a body qualifier")
+	assert.Empty(t, links, "Expected no match for a title term scoped to the body")
+
+	// An unknown qualifier is not a field, the whole string is searched as-is
+	_, err = database.Search(t.Context(), "quokka")
+	require.NoError(t, err, "Failed to search without a qualifier")
+}
+
+// TestConcurrentAccess verifies that concurrent readers and writers do not
+// fail with SQLITE_BUSY.
+func TestConcurrentAccess(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_concurrent.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	const writers = 8
+	const readers = 8
+	const iterations = 20
+
+	var wg sync.WaitGroup
+	// Buffered for the maximum number of errors, so no goroutine blocks on send
+	errs := make(chan error, (writers+2*readers)*iterations)
+
+	for w := range writers {
+		wg.Go(func() {
+			for i := range iterations {
+				url := fmt.Sprintf("https://example.com/%d/%d", w, i)
+				if _, err := database.AddLink(t.Context(), url, "Example Website", "An example", "", []byte("body text")); err != nil {
+					errs <- err
+				}
+			}
+		})
+	}
+
+	for range readers {
+		wg.Go(func() {
+			for range iterations {
+				if _, err := database.GetAllLinks(t.Context()); err != nil {
+					errs <- err
+				}
+				if _, err := database.Search(t.Context(), "body"); err != nil {
+					errs <- err
+				}
+			}
+		})
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err, "Concurrent access failed")
+	}
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Len(t, links, writers*iterations, "Got %d links, expected %d", len(links), writers*iterations)
+}
+
+// TestContextCancellation verifies that the database operations honor the
+// context, so that a request which is aborted does not keep a connection busy.
+func TestContextCancellation(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_context.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	_, err = database.AddLink(t.Context(), "https://example.com", "Example Website", "An example", "", []byte("body text"))
+	require.NoError(t, err, "Failed to add link")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, err = database.AddLink(ctx, "https://other.com", "Fun page", "Something else", "", nil)
+	assert.ErrorIs(t, err, context.Canceled, "AddLink ignored the context")
+
+	_, err = database.GetAllLinks(ctx)
+	assert.ErrorIs(t, err, context.Canceled, "GetAllLinks ignored the context")
+
+	_, err = database.Search(ctx, "body")
+	assert.ErrorIs(t, err, context.Canceled, "Search ignored the context")
+
+	_, err = database.GetLink(ctx, 1)
+	assert.ErrorIs(t, err, context.Canceled, "GetLink ignored the context")
+
+	err = database.UpdateLink(ctx, 1, titleAndDescription("Updated title", "Updated description"), nil)
+	assert.ErrorIs(t, err, context.Canceled, "UpdateLink ignored the context")
+
+	err = database.DeleteLink(ctx, 1)
+	assert.ErrorIs(t, err, context.Canceled, "DeleteLink ignored the context")
+
+	// None of the cancelled operations should have changed anything
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Len(t, links, 1, "Got %d links, expected 1", len(links))
+	assert.Equal(t, "Example Website", links[0].Title)
+}
+
+// TestSearchWithLimit verifies that a search returns at most the limit and
+// reports when more links matched.
+func TestSearchWithLimit(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_search_limit.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	assert.Equal(t, DefaultSearchLimit, database.SearchLimit, "Expected the default search limit")
+
+	for i := range 5 {
+		_, err = database.AddLink(t.Context(), fmt.Sprintf("https://example.com/%d", i), fmt.Sprintf("Example page %d", i), "", "", nil)
+		require.NoError(t, err, "Failed to add link %d", i)
+	}
+
+	// A limit below the number of matches truncates and says so
+	links, truncated, err := database.SearchWithLimit(t.Context(), "Example", 3)
+	require.NoError(t, err, "Failed to search with limit")
+	assert.Len(t, links, 3, "Got %d links, expected the limit of 3", len(links))
+	assert.True(t, truncated, "Expected the result to be reported as truncated")
+
+	// A limit matching the number of matches exactly is not truncation
+	links, truncated, err = database.SearchWithLimit(t.Context(), "Example", 5)
+	require.NoError(t, err, "Failed to search with an exact limit")
+	assert.Len(t, links, 5, "Got %d links, expected all 5", len(links))
+	assert.False(t, truncated, "Expected an exact fit not to be reported as truncated")
+
+	// No limit returns everything
+	links, truncated, err = database.SearchWithLimit(t.Context(), "Example", 0)
+	require.NoError(t, err, "Failed to search without a limit")
+	assert.Len(t, links, 5, "Got %d links, expected all 5", len(links))
+	assert.False(t, truncated, "Expected no truncation without a limit")
+
+	// The limit applies to the LIKE backend as well
+	database.LikeSearch = true
+	links, truncated, err = database.SearchWithLimit(t.Context(), "Example", 2)
+	require.NoError(t, err, "Failed to search with limit through the LIKE backend")
+	assert.Len(t, links, 2, "Got %d links through the LIKE backend, expected 2", len(links))
+	assert.True(t, truncated, "Expected the LIKE result to be reported as truncated")
+	database.LikeSearch = false
+
+	// Search uses SearchLimit
+	database.SearchLimit = 4
+	links, err = database.Search(t.Context(), "Example")
+	require.NoError(t, err, "Failed to search with SearchLimit")
+	assert.Len(t, links, 4, "Got %d links, expected SearchLimit of 4", len(links))
+}
+
+// TestSearchLike verifies the LIKE-based search fallback, and that a simple
+// query finds the same link through both search backends.
+func TestSearchLike(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_search_like.database")
+
+	database, err := InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	_, err = database.AddLink(t.Context(), "https://example.com", "Example Website", "Mostly about zebras", "", []byte("quokka habitats"))
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://other.com", "Fun page", "100% unrelated", "", nil)
+	require.NoError(t, err, "Failed to add link 2")
+
+	// A simple query finds the same link through both backends
+	ftsLinks, err := database.Search(t.Context(), "zebras")
+	require.NoError(t, err, "Failed to search with FTS")
+	likeLinks, err := database.SearchLike(t.Context(), "zebras")
+	require.NoError(t, err, "Failed to search with LIKE")
+	require.Len(t, ftsLinks, 1, "Got %d links from FTS, expected 1", len(ftsLinks))
+	require.Len(t, likeLinks, 1, "Got %d links from LIKE, expected 1", len(likeLinks))
+	assert.Equal(t, ftsLinks[0].URL, likeLinks[0].URL, "Expected both backends to find the same link")
+
+	// LIKE matches substrings and the URL, which FTS does not index
+	likeLinks, err = database.SearchLike(t.Context(), "other.com")
+	require.NoError(t, err, "Failed to search the URL with LIKE")
+	assert.Len(t, likeLinks, 1, "Got %d links searching the URL, expected 1", len(likeLinks))
+
+	// LIKE wildcards in the query match literally
+	likeLinks, err = database.SearchLike(t.Context(), "100%")
+	require.NoError(t, err, "Failed to search for a literal wildcard")
+	require.Len(t, likeLinks, 1, "Got %d links for a literal wildcard, expected 1", len(likeLinks))
+	assert.Equal(t, "https://other.com", likeLinks[0].URL)
+
+	// LikeSearch switches the backend behind Search
+	database.LikeSearch = true
+	links, err := database.Search(t.Context(), "other.com")
+	require.NoError(t, err, "Failed to search with the LIKE backend")
+	assert.Len(t, links, 1, "Got %d links through the LIKE backend, expected 1", len(links))
+
+	// The LIKE backend does not search bodies
+	links, err = database.Search(t.Context(), "quokka")
+	require.NoError(t, err, "Failed to search a body term with the LIKE backend")
+	assert.Empty(t, links, "Expected no body match through the LIKE backend")
+}
+
+// Test_fts5Unavailable verifies the detection of a SQLite build without the
+// FTS5 module, which cannot be provoked with the bundled build.
+func Test_fts5Unavailable(t *testing.T) {
+	assert.True(t, fts5Unavailable(fmt.Errorf("SQL logic error: no such module: fts5 (1)")))
+	assert.False(t, fts5Unavailable(fmt.Errorf("SQL logic error: near \"BOGUS\": syntax error (1)")))
+	assert.False(t, fts5Unavailable(nil))
+}
+
+// TestInvalidDatabaseFile verifies that a file name which the driver would
+// split into a DSN is rejected rather than silently opening another database.
+func TestInvalidDatabaseFile(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test?_pragma=journal_mode(DELETE)")
+
+	database, err := InitDB(dbFile)
+	assert.Error(t, err, "Expected error for a database file name containing '?'")
+	if err == nil {
+		_ = database.Close()
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mikaelstaldal/go-server-common/auth"
+	"github.com/mikaelstaldal/go-server-common/csrf"
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/web"
+)
+
+const databaseName = "mylinks.sqlite"
+const screenshotsDir = "screenshots"
+
+// shutdownTimeout is how long in-flight requests get to finish when the
+// server is told to stop, slightly above the write timeout so a slow but
+// legitimate response is not cut off.
+const shutdownTimeout = 25 * time.Second
+
+// version is the release version, overridden at build time with
+// -ldflags "-X main.version=v1.2.3". The commit and its date come from the
+// VCS build settings the toolchain embeds on its own.
+var version = "dev"
+
+func main() {
+	// Subcommands come before the flags, a bare invocation runs the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "add":
+			os.Exit(runAdd(os.Args[2:]))
+		case "list":
+			os.Exit(runList(os.Args[2:], false, os.Stdout))
+		case "search":
+			os.Exit(runList(os.Args[2:], true, os.Stdout))
+		case "token":
+			os.Exit(runToken(os.Args[2:], os.Stdout))
+		}
+	}
+	os.Exit(run())
+}
+
+// newWebHandlers is swapped out in tests, where the fetched URL is a local
+// mock server which external URL validation would reject.
+var newWebHandlers = web.NewHandlers
+
+// runAdd implements the "add" subcommand: fetch a URL, extract its metadata
+// and save it directly to the database, without a running server. Prints the
+// id of the new link.
+func runAdd(args []string) (exitCode int) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	dataDir := flags.String("data", "data", "directory to store data in")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: mylinks add [-data <directory>] <url>")
+		return 2
+	}
+
+	executablePath, err := os.Executable()
+	if err != nil {
+		log.Fatalf("could not determine executable path: %v", err)
+	}
+	executableDir := filepath.Dir(executablePath)
+
+	databaseFile, err := prepareDataDir(*dataDir)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	database, err := db.InitDB(databaseFile)
+	if err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer func() {
+		// Closing the database checkpoints and removes its WAL file
+		if err := database.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+			exitCode = 1
+		}
+	}()
+
+	handlers := newWebHandlers(executableDir, database, "")
+	id, err := handlers.AddURL(context.Background(), flags.Arg(0), "cli")
+	if err != nil {
+		log.Printf("Failed to add link: %v", err)
+		return 1
+	}
+	fmt.Println(id)
+
+	return 0
+}
+
+// runList implements the "list" and "search" subcommands: print links from
+// the database as JSON, without a running server.
+func runList(args []string, search bool, out io.Writer) (exitCode int) {
+	name := "list"
+	if search {
+		name = "search"
+	}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	dataDir := flags.String("data", "data", "directory to store data in")
+	limit := flags.Int("limit", 0, "maximum number of links to print, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if search && flags.NArg() != 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: mylinks search [-data <directory>] [-limit <n>] <query>")
+		return 2
+	}
+	if !search && flags.NArg() != 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: mylinks list [-data <directory>] [-limit <n>]")
+		return 2
+	}
+
+	databaseFile, err := prepareDataDir(*dataDir)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	database, err := db.InitDB(databaseFile)
+	if err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer func() {
+		// Closing the database checkpoints and removes its WAL file
+		if err := database.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+			exitCode = 1
+		}
+	}()
+
+	var links []db.Link
+	if search {
+		links, err = database.Search(context.Background(), flags.Arg(0))
+	} else {
+		links, err = database.GetAllLinks(context.Background())
+	}
+	if err != nil {
+		log.Printf("Failed to get links: %v", err)
+		return 1
+	}
+	if *limit > 0 && len(links) > *limit {
+		links = links[:*limit]
+	}
+	if links == nil {
+		// An empty list prints as [], not null
+		links = []db.Link{}
+	}
+
+	if err := json.NewEncoder(out).Encode(links); err != nil {
+		log.Printf("Failed to write JSON: %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// runToken implements the "token" subcommand: mint a named API token for
+// bearer authentication, or revoke one with -revoke. The token is printed
+// once; only its hash is stored, so it cannot be shown again.
+func runToken(args []string, out io.Writer) (exitCode int) {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	dataDir := flags.String("data", "data", "directory to store data in")
+	revoke := flags.Bool("revoke", false, "revoke the named token instead of minting one")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "usage: mylinks token [-data <directory>] [-revoke] <name>")
+		return 2
+	}
+	name := flags.Arg(0)
+
+	databaseFile, err := prepareDataDir(*dataDir)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	database, err := db.InitDB(databaseFile)
+	if err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return 1
+	}
+	defer func() {
+		// Closing the database checkpoints and removes its WAL file
+		if err := database.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+			exitCode = 1
+		}
+	}()
+
+	if *revoke {
+		if err := database.RevokeAPIToken(context.Background(), name); err != nil {
+			log.Printf("Failed to revoke token %s: %v", name, err)
+			return 1
+		}
+		return 0
+	}
+
+	token, err := database.CreateAPIToken(context.Background(), name)
+	if err != nil {
+		log.Printf("Failed to mint token %s: %v", name, err)
+		return 1
+	}
+	_, _ = fmt.Fprintln(out, token)
+
+	return 0
+}
+
+// prepareDataDir ensures the data directory exists and is usable, and returns
+// the path of the database file within it.
+func prepareDataDir(dataDir string) (string, error) {
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if err := os.MkdirAll(dataDir, 0700); err != nil {
+				return "", fmt.Errorf("could not create data directory: %s", dataDir)
+			}
+		} else {
+			return "", fmt.Errorf("failed to access data directory %s: %v", dataDir, err)
+		}
+	} else {
+		if !info.IsDir() {
+			return "", fmt.Errorf("data directory path is not a directory: %s", dataDir)
+		}
+	}
+	databaseFile := filepath.Join(dataDir, databaseName)
+
+	info, err = os.Stat(databaseFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to access database file %s: %v", databaseFile, err)
+		}
+	} else {
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("database file is not a regular file: %s", databaseFile)
+		}
+	}
+
+	return databaseFile, nil
+}
+
+// redirectUnauthorized sends a browser arriving without credentials to url,
+// typically a login page in front of the server, instead of the bare 401 the
+// auth middleware would answer with. Requests that carry credentials and
+// clients asking for JSON go through to the middleware, so that API errors
+// stay a machine-readable 401.
+func redirectUnauthorized(url string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" && !strings.Contains(r.Header.Get("Accept"), "application/json") {
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerAuth lets API clients authenticate with "Authorization: Bearer
+// <token>", checked against the tokens minted by the "token" subcommand:
+// one SHA-256 and a constant-time compare per request, far cheaper than
+// the bcrypt check basic auth does. A request carrying a bearer token is
+// judged by it alone, even if it would also pass basic auth; requests
+// without one fall through to next, the basic auth chain.
+func bearerAuth(database *db.DB, protected, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		valid, err := database.CheckAPIToken(r.Context(), token)
+		if err != nil {
+			log.Printf("Failed to check API token: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			// Same form as the basic auth middleware's log line, so
+			// fail2ban-style tooling catches both
+			log.Printf("authentication failed: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
+}
+
+// run is separate from main so that deferred cleanup, notably closing the
+// database, runs before the process exits. The exit code is a named return so
+// that the deferred cleanup can report a failure of its own.
+func run() (exitCode int) {
+	// Determine the path of executable
+	executablePath, err := os.Executable()
+	if err != nil {
+		log.Fatalf("could not determine executable path: %v", err)
+	}
+	executableDir := filepath.Dir(executablePath)
+
+	// Define command line flags
+	port := flag.Int("port", 8080, "port to listen on")
+	addr := flag.String("addr", "127.0.0.1", "address to listen on")
+	dataDir := flag.String("data", "data", "directory to store data in")
+	dedupeTitles := flag.Bool("dedupe-titles", false, "also reject new links whose title matches an existing link")
+	likeSearch := flag.Bool("like-search", false, "search with LIKE over URL, title and description instead of the full text index")
+	searchLimit := flag.Int("search-limit", db.DefaultSearchLimit, "maximum number of search results, 0 for no limit")
+	fetchAccept := flag.String("fetch-accept", web.DefaultFetchAccept, "Accept header sent when fetching a page")
+	fetchTimeout := flag.Duration("fetch-timeout", 10*time.Second, "bound on a whole page fetch, including reading the body")
+	userAgent := flag.String("user-agent", "Mylinks/1.0", "User-Agent header sent when fetching a page")
+	fetchContentTypes := flag.String("fetch-content-types", strings.Join(web.DefaultContentTypes, ","), "comma-separated content types processed for metadata")
+	saveOnFetchError := flag.Bool("save-on-fetch-error", false, "save a link whose fetch returns a non-200 status, with a title from the URL, instead of failing")
+	maxIndexBodyBytes := flag.Int("max-index-body-bytes", 0, "store and index at most this many bytes of a page body for search, 0 for no limit")
+	optimizeScreenshots := flag.Bool("optimize-screenshots", false, "re-encode screenshots with maximum PNG compression before storing them")
+	retryTrailingSlash := flag.Bool("retry-trailing-slash", false, "retry a fetch answered with 404 once with the trailing slash of the path toggled")
+	screenshotMaxAge := flag.Duration("screenshot-max-age", 0, "re-capture screenshots older than this in the background, 0 to disable")
+	checkInterval := flag.Duration("check-interval", 0, "check all saved links for dead pages this often with a HEAD request, 0 to disable")
+	screenshotsByID := flag.Bool("screenshots-by-id", false, "name screenshot files by link id instead of a hash of the URL, renaming existing files at startup")
+	screenshotSelectors := flag.String("screenshot-selectors", "", "comma-separated pattern=selector entries screenshotting a CSS selector instead of the viewport on matching hosts")
+	stripQueryHosts := flag.String("strip-query-hosts", "", "comma-separated host patterns (exact, *.example.com or *) whose URLs are saved without their query string")
+	rejectIDNHosts := flag.Bool("reject-idn-hosts", false, "refuse URLs with an internationalized host, in Unicode or punycode spelling")
+	stripFragments := flag.Bool("strip-fragments", false, "remove URL fragments before saving, so page and page#top dedupe to the same link")
+	batchConcurrency := flag.Int("batch-concurrency", 0, "how many URLs of a batch add are fetched at once, 0 for the default of 4")
+	storeHeaders := flag.Bool("store-headers", false, "keep select response headers of fetched pages as link metadata under header: keys")
+	storeOriginalURL := flag.Bool("store-original-url", false, "keep the URL as submitted as link metadata when canonicalization changed it")
+	storeFetchDuration := flag.Bool("store-fetch-duration", false, "record how long the metadata fetch of each add took")
+	fetchFavicons := flag.Bool("fetch-favicons", false, "fetch each site's favicon when a link is added and show it in the list")
+	oobUpdates := flag.Bool("oob-updates", false, "update only the changed row on HTMX adds and deletes, keeping the list scroll position")
+	extractBodyText := flag.Bool("extract-body-text", false, "index only the readable text of fetched pages instead of their raw HTML")
+	fetchHostInterval := flag.Duration("fetch-host-interval", 0, "minimum spacing between fetches to the same host, 0 for none")
+	fetchURLCooldown := flag.Duration("fetch-url-cooldown", 0, "minimum interval between add attempts of the same URL, repeats within it get a 429, 0 for none")
+	searchLead := flag.String("search-lead", "title", "field search result cards lead with, title or description")
+	displayTitleLength := flag.Int("display-title-length", 0, "shorten titles in the link list to this many characters, 0 to show them in full")
+	maxDrainBytes := flag.Int64("max-drain-bytes", 0, "cap on response bytes drained past the read limit to keep connections reusable, 0 for the default of 262144, negative to always close")
+	maxTags := flag.Int("max-tags", 0, "how many tags the tags metadata key may hold, 0 for the default of 20")
+	maxTagLength := flag.Int("max-tag-length", 0, "how long one tag may be, 0 for the default of 64")
+	followMetaRefresh := flag.Bool("follow-meta-refresh", false, "follow a meta refresh redirect once when fetching a page")
+	blockSchemeDowngrade := flag.Bool("block-scheme-downgrade", false, "refuse redirects from an https page to plain http")
+	requestTimeout := flag.Duration("request-timeout", 0, "per-request deadline after which a 503 is returned, 0 for no deadline")
+	addRate := flag.Int("add-rate", 10, "max link adds per minute and client IP, answered with a 429 beyond that, 0 for no limit")
+	staticCacheMaxAge := flag.Duration("static-cache-max-age", 0, "Cache-Control max-age for static assets, 0 to leave caching to the browser")
+	hstsMaxAge := flag.Duration("hsts-max-age", 0, "send a Strict-Transport-Security header with this max-age on HTTPS requests, 0 to disable")
+	hstsIncludeSubdomains := flag.Bool("hsts-include-subdomains", false, "extend the HSTS policy to subdomains")
+	basicAuthFile := flag.String("basic-auth-file", "", "enable HTTP basic auth with username and password from given file in htpasswd format (bcrypt only)")
+	basicAuthRealm := flag.String("basic-auth-realm", "mylinks", "realm for HTTP basic auth")
+	unauthorizedRedirect := flag.String("unauthorized-redirect", "", "redirect unauthenticated browsers to this URL instead of a bare 401, clients asking for JSON still get the 401")
+	publicURL := flag.String("public-url", "", "Public-facing base URL for CSRF validation, e.g. https://example.com (defaults to http://<addr>:<port>)")
+	logFormat := flag.String("log-format", "text", "log format, text or json")
+	versionFlag := flag.Bool("version", false, "print version and build information, then exit")
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Println(web.Build(version))
+		return 0
+	}
+
+	if *port < 1 || *port > 65535 {
+		log.Fatalf("Invalid port number: %d. Must be between 1 and 65535", *port)
+	}
+
+	if *searchLead != "title" && *searchLead != "description" {
+		log.Fatalf("Invalid search-lead: %s. Must be title or description", *searchLead)
+	}
+
+	switch *logFormat {
+	case "text":
+		// The plain log package default.
+	case "json":
+		// Setting the slog default also routes the plain log package
+		// through the JSON handler, so every existing log line comes out
+		// as a structured record too.
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	default:
+		log.Fatalf("Invalid log-format: %s. Must be text or json", *logFormat)
+	}
+
+	databaseFile, err := prepareDataDir(*dataDir)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var authMiddleware func(http.Handler) http.Handler
+	if *basicAuthFile != "" {
+		htpasswd, err := auth.LoadHtpasswd(*basicAuthFile)
+		if err != nil {
+			log.Fatalf("load htpasswd: %v", err)
+		}
+		authMiddleware = htpasswd.Middleware(*basicAuthRealm)
+		log.Printf("basic authentication enabled")
+	}
+
+	serverOrigin, err := csrf.ResolveServerOrigin(*publicURL, *addr, *port)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Initialize database, once everything which can fail before it is done
+	database, err := db.InitDB(databaseFile)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	database.DedupeTitles = *dedupeTitles
+	database.LikeSearch = *likeSearch
+	database.SearchLimit = *searchLimit
+	defer func() {
+		// Closing the database checkpoints and removes its WAL file
+		if err := database.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+			exitCode = 1
+		}
+	}()
+
+	// Initialize handlers
+	handlers := web.NewHandlers(executableDir, database, filepath.Join(*dataDir, screenshotsDir))
+	handlers.FetchAccept = *fetchAccept
+	handlers.FetchTimeout = *fetchTimeout
+	handlers.UserAgent = *userAgent
+	handlers.ContentTypes = strings.Split(*fetchContentTypes, ",")
+	handlers.SaveOnFetchError = *saveOnFetchError
+	handlers.MaxIndexBodyBytes = *maxIndexBodyBytes
+	handlers.ServerOrigin = serverOrigin
+	handlers.OptimizeScreenshots = *optimizeScreenshots
+	handlers.RetryTrailingSlash = *retryTrailingSlash
+	if *stripQueryHosts != "" {
+		handlers.StripQueryHosts = strings.Split(*stripQueryHosts, ",")
+	}
+	handlers.RejectIDNHosts = *rejectIDNHosts
+	handlers.StripFragments = *stripFragments
+	handlers.BatchAddConcurrency = *batchConcurrency
+	handlers.StoreHeaders = *storeHeaders
+	handlers.StoreOriginalURL = *storeOriginalURL
+	handlers.StoreFetchDuration = *storeFetchDuration
+	handlers.FetchFavicons = *fetchFavicons
+	handlers.OOBUpdates = *oobUpdates
+	handlers.ExtractBodyText = *extractBodyText
+	handlers.FetchHostInterval = *fetchHostInterval
+	handlers.FetchURLCooldown = *fetchURLCooldown
+	handlers.SearchLead = *searchLead
+	handlers.Version = version
+	handlers.DisplayTitleLength = *displayTitleLength
+	handlers.DatabaseFile = databaseFile
+	handlers.MaxDrainBytes = *maxDrainBytes
+	handlers.MaxTagsPerLink = *maxTags
+	handlers.MaxTagLength = *maxTagLength
+	handlers.FollowMetaRefresh = *followMetaRefresh
+	handlers.BlockSchemeDowngrade = *blockSchemeDowngrade
+	if *screenshotSelectors != "" {
+		handlers.ScreenshotSelectors = strings.Split(*screenshotSelectors, ",")
+	}
+	handlers.ScreenshotMaxAge = *screenshotMaxAge
+	handlers.CheckInterval = *checkInterval
+	handlers.ScreenshotsByID = *screenshotsByID
+	handlers.RequestTimeout = *requestTimeout
+	handlers.AddRatePerMinute = *addRate
+	handlers.StaticCacheMaxAge = *staticCacheMaxAge
+	handlers.HSTSMaxAge = *hstsMaxAge
+	handlers.HSTSIncludeSubdomains = *hstsIncludeSubdomains
+	handlers.ReconcileScreenshots(context.Background())
+	handlers.StartScreenshotRefresher(context.Background())
+	handlers.StartScreenshotRetrier(context.Background())
+	// Cancelled when run returns, so a link check sweep in progress stops
+	// at shutdown instead of going on requesting pages.
+	checkerCtx, stopChecker := context.WithCancel(context.Background())
+	defer stopChecker()
+	handlers.StartLinkChecker(checkerCtx)
+	mux := handlers.Routes()
+	var root = csrf.Middleware(serverOrigin)(mux)
+
+	if authMiddleware != nil {
+		// The share view is deliberately public: it shows a single link
+		// through an unguessable token. Everything else stays behind auth.
+		authed := authMiddleware(root)
+		if *unauthorizedRedirect != "" {
+			authed = redirectUnauthorized(*unauthorizedRedirect, authed)
+		}
+		authed = bearerAuth(database, root, authed)
+		public := root
+		root = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/share/") {
+				public.ServeHTTP(w, r)
+				return
+			}
+			authed.ServeHTTP(w, r)
+		})
+	}
+
+	// Start server
+	serverAddr := fmt.Sprintf("%s:%d", *addr, *port)
+	log.Printf("Starting server on %s", serverAddr)
+	server := http.Server{
+		Addr:         serverAddr,
+		Handler:      root,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 20 * time.Second,
+		IdleTimeout:  time.Minute,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// A signal shuts the server down gracefully: in-flight requests get
+	// shutdownTimeout to finish, and the deferred database close still runs,
+	// instead of the process dying mid-request with the WAL file in place.
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		log.Printf("Server error: %v", err)
+		exitCode = 1
+	case sig := <-shutdown:
+		log.Printf("Received %v, shutting down", sig)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Graceful shutdown did not finish in %v, closing anyway: %v", shutdownTimeout, err)
+			_ = server.Close()
+			exitCode = 1
+		} else {
+			log.Printf("Server stopped")
+		}
+	}
+
+	return exitCode
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/web"
+)
+
+// TestAddSubcommand verifies that "add" fetches a URL and saves it directly
+// to the database, without a running server.
+func TestAddSubcommand(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "<html><head><title>Added from the command line</title></head><body>Some body</body></html>")
+	}))
+	defer mockServer.Close()
+
+	// The mock server is local, which external URL validation would reject
+	newWebHandlers = web.NewHandlersForTesting
+	t.Cleanup(func() {
+		newWebHandlers = web.NewHandlers
+	})
+
+	dataDir := t.TempDir()
+	exitCode := runAdd([]string{"-data", dataDir, mockServer.URL})
+	require.Zero(t, exitCode, "add subcommand failed")
+
+	database, err := db.InitDB(filepath.Join(dataDir, databaseName))
+	require.NoError(t, err, "Failed to open database after add")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	require.Len(t, links, 1, "Got %d links, expected 1", len(links))
+	assert.Equal(t, mockServer.URL, links[0].URL)
+	assert.Equal(t, "Added from the command line", links[0].Title)
+	assert.Equal(t, "cli", links[0].Source, "Wrong source for the subcommand add")
+}
+
+// TestAddSubcommandUsage verifies that "add" without a URL fails with usage
+// rather than starting anything.
+func TestAddSubcommandUsage(t *testing.T) {
+	exitCode := runAdd([]string{"-data", t.TempDir()})
+	assert.Equal(t, 2, exitCode, "Expected usage error without a URL")
+}
+
+// TestRedirectUnauthorized verifies that unauthenticated browsers are
+// redirected to the configured URL while API clients asking for JSON, and
+// requests carrying credentials, reach the auth middleware for the usual 401.
+func TestRedirectUnauthorized(t *testing.T) {
+	authed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stands in for the basic auth middleware
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+	handler := redirectUnauthorized("/login", authed)
+
+	t.Run("browser is redirected", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusFound, rec.Code, "Expected a redirect for a browser without credentials")
+		assert.Equal(t, "/login", rec.Header().Get("Location"), "Wrong redirect target")
+	})
+
+	t.Run("JSON client gets the 401", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "Expected the bare 401 for a JSON client")
+	})
+
+	t.Run("credentials go through to the middleware", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("admin", "wrong")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "Expected bad credentials to be judged by the middleware")
+	})
+}
+
+// TestListAndSearchSubcommands verifies that "list" and "search" print links
+// from the database as JSON.
+func TestListAndSearchSubcommands(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// Seed the database directly
+	database, err := db.InitDB(filepath.Join(dataDir, databaseName))
+	require.NoError(t, err, "Failed to initialize database")
+	_, err = database.AddLink(t.Context(), "https://example.com", "Example Website", "An example", "", []byte("remarkable body text"))
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://other.com", "Fun page", "Something else", "", nil)
+	require.NoError(t, err, "Failed to add link 2")
+	require.NoError(t, database.Close(), "Failed to close database")
+
+	var out bytes.Buffer
+	exitCode := runList([]string{"-data", dataDir}, false, &out)
+	require.Zero(t, exitCode, "list subcommand failed")
+
+	var links []db.Link
+	require.NoError(t, json.Unmarshal(out.Bytes(), &links), "list did not print valid JSON")
+	assert.Len(t, links, 2, "Got %d links from list, expected 2", len(links))
+
+	out.Reset()
+	exitCode = runList([]string{"-data", dataDir, "-limit", "1"}, false, &out)
+	require.Zero(t, exitCode, "list subcommand with limit failed")
+	links = nil
+	require.NoError(t, json.Unmarshal(out.Bytes(), &links), "list with limit did not print valid JSON")
+	assert.Len(t, links, 1, "Got %d links from list with limit, expected 1", len(links))
+
+	out.Reset()
+	exitCode = runList([]string{"-data", dataDir, "remarkable"}, true, &out)
+	require.Zero(t, exitCode, "search subcommand failed")
+	links = nil
+	require.NoError(t, json.Unmarshal(out.Bytes(), &links), "search did not print valid JSON")
+	require.Len(t, links, 1, "Got %d links from search, expected 1", len(links))
+	assert.Equal(t, "https://example.com", links[0].URL)
+
+	out.Reset()
+	exitCode = runList([]string{"-data", dataDir, "nothingmatches"}, true, &out)
+	require.Zero(t, exitCode, "search subcommand without matches failed")
+	assert.Equal(t, "[]\n", out.String(), "Expected an empty JSON array when nothing matches")
+
+	// A search without a query is a usage error
+	exitCode = runList([]string{"-data", dataDir}, true, &out)
+	assert.Equal(t, 2, exitCode, "Expected usage error for search without a query")
+}
+
+// TestTokenSubcommand verifies that "token" mints an API token which the
+// database then accepts, and that -revoke invalidates it.
+func TestTokenSubcommand(t *testing.T) {
+	dataDir := t.TempDir()
+
+	var out bytes.Buffer
+	exitCode := runToken([]string{"-data", dataDir, "ci"}, &out)
+	require.Zero(t, exitCode, "token subcommand failed")
+	token := strings.TrimSpace(out.String())
+	require.NotEmpty(t, token, "Expected the minted token to be printed")
+
+	database, err := db.InitDB(filepath.Join(dataDir, databaseName))
+	require.NoError(t, err, "Failed to open database after mint")
+	valid, err := database.CheckAPIToken(t.Context(), token)
+	require.NoError(t, err, "Failed to check token")
+	assert.True(t, valid, "Expected the minted token to be valid")
+	require.NoError(t, database.Close(), "Failed to close database")
+
+	exitCode = runToken([]string{"-data", dataDir, "-revoke", "ci"}, &out)
+	require.Zero(t, exitCode, "token -revoke failed")
+
+	database, err = db.InitDB(filepath.Join(dataDir, databaseName))
+	require.NoError(t, err, "Failed to open database after revoke")
+	valid, err = database.CheckAPIToken(t.Context(), token)
+	require.NoError(t, err, "Failed to check token")
+	assert.False(t, valid, "Expected a revoked token to be invalid")
+	require.NoError(t, database.Close(), "Failed to close database")
+
+	// A missing name is a usage error
+	exitCode = runToken([]string{"-data", dataDir}, &out)
+	assert.Equal(t, 2, exitCode, "Expected usage error without a name")
+}
+
+// TestBearerAuth verifies that a valid bearer token reaches the protected
+// handler without basic auth, a revoked one gets the 401, and a request
+// without a bearer token falls through to the basic auth chain.
+func TestBearerAuth(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test_bearer.database"))
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	token, err := database.CreateAPIToken(t.Context(), "ci")
+	require.NoError(t, err, "Failed to mint token")
+
+	protected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "protected")
+	})
+	basic := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stands in for the basic auth middleware
+		http.Error(w, "basic", http.StatusUnauthorized)
+	})
+	handler := bearerAuth(database, protected, basic)
+
+	t.Run("valid token reaches the handler", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, "Expected a valid token to be let through")
+		assert.Equal(t, "protected", rec.Body.String(), "Expected the protected handler to answer")
+	})
+
+	t.Run("revoked token gets the 401", func(t *testing.T) {
+		require.NoError(t, database.RevokeAPIToken(t.Context(), "ci"), "Failed to revoke token")
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "Expected a revoked token to be rejected")
+		assert.NotContains(t, rec.Body.String(), "basic", "Expected a bearer request not to fall through to basic auth")
+	})
+
+	t.Run("basic credentials fall through to the middleware", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "Expected basic credentials to be judged by the middleware")
+		assert.Contains(t, rec.Body.String(), "basic", "Expected the basic auth middleware to answer")
+	})
+}
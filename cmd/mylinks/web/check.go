@@ -0,0 +1,95 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+)
+
+// checkHostDelay spaces out checks against the same host, so one sweep does
+// not hammer a site with many saved pages.
+const checkHostDelay = time.Second
+
+// StartLinkChecker launches a background goroutine which periodically sends
+// a HEAD request to every saved URL and records the status it answered with,
+// so that ?status=dead can surface links whose page is gone. It does nothing
+// unless CheckInterval is set. The goroutine stops when ctx is cancelled.
+func (h *Handlers) StartLinkChecker(ctx context.Context) {
+	if h.CheckInterval <= 0 {
+		return
+	}
+	log.Printf("Checking links every %v", h.CheckInterval)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(h.CheckInterval):
+			}
+			h.checkLinks(ctx)
+		}
+	}()
+}
+
+// checkLinks sweeps over all links once, sequentially: HEAD each URL and
+// record the status, waiting checkHostDelay between requests to the same
+// host. Notes have no page to check and are skipped, as are links whose URL
+// no longer parses.
+func (h *Handlers) checkLinks(ctx context.Context) {
+	links, err := h.database.GetAllLinks(ctx)
+	if err != nil {
+		log.Printf("Link check: failed to get links: %v", err)
+		return
+	}
+
+	lastByHost := make(map[string]time.Time)
+	for _, link := range links {
+		if ctx.Err() != nil {
+			return
+		}
+		if isNote(link.URL) {
+			continue
+		}
+		parsedURL, err := url.Parse(link.URL)
+		if err != nil {
+			continue
+		}
+		if wait := checkHostDelay - time.Since(lastByHost[parsedURL.Host]); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		lastByHost[parsedURL.Host] = time.Now()
+
+		status := h.checkLink(ctx, link.URL)
+		writeCtx, cancel := writeContext(ctx)
+		// A link trashed mid-sweep reports ErrNotFound, which is fine.
+		if err := h.database.SetCheckResult(writeCtx, link.ID, status); err != nil && !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Link check: failed to record %s: %v", link.URL, err)
+		}
+		cancel()
+	}
+}
+
+// checkLink sends one HEAD request to a URL and returns the status it was
+// answered with, or 0 when the connection itself failed.
+func (h *Handlers) checkLink(ctx context.Context, linkURL string) int64 {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, linkURL, nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("User-Agent", h.userAgent())
+	resp, err := h.fetchClient().Do(req)
+	if err != nil {
+		return 0
+	}
+	_ = resp.Body.Close()
+	return int64(resp.StatusCode)
+}
@@ -0,0 +1,4255 @@
+package web
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"expvar"
+	"fmt"
+	"html/template"
+	"image/png"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/ledongthuc/pdf"
+	"github.com/mikaelstaldal/go-server-common/httputil"
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+	"github.com/mikaelstaldal/mylinks/ui"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/net/idna"
+)
+
+const maxTitleLength = 250
+const maxDescriptionLength = 1020
+const maxBodyLength = 1000000
+
+// databaseWriteTimeout bounds how long a database write may take, including
+// waiting for a free connection from the pool.
+const databaseWriteTimeout = 10 * time.Second
+
+// writeContext returns the context to use for a database write. Reads are
+// abandoned when the client goes away, but writes are not: the user asked for
+// the change, and for a link that also means throwing away an already
+// completed fetch of the page. The timeout still bounds the wait.
+func writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), databaseWriteTimeout)
+}
+
+// DefaultFetchAccept is the Accept header sent when fetching a page, unless
+// overridden through FetchAccept.
+const DefaultFetchAccept = "text/html,application/xhtml+xml,application/pdf;q=0.9,text/plain;q=0.8"
+
+// DefaultContentTypes are the content types whose responses are processed for
+// metadata, unless overridden through ContentTypes. Anything else is saved
+// with a title derived from the URL.
+var DefaultContentTypes = []string{"text/html", "application/xhtml+xml", "application/pdf", "text/plain"}
+
+// Handlers holds dependencies for the handlers.
+type Handlers struct {
+	// FetchAccept is the Accept header sent when fetching a page.
+	FetchAccept string
+	// ContentTypes are the content types processed for metadata.
+	ContentTypes []string
+	// SaveOnFetchError makes a non-200 fetch save the link anyway, with a
+	// title derived from the URL and the status kept as a marker, instead of
+	// failing the add. For paywalled or login-required pages (401/403) and
+	// bot-detection challenges, which are still worth keeping a link to.
+	SaveOnFetchError bool
+	// ServerOrigin is the public-facing base URL, used where an absolute URL
+	// is required such as the OpenSearch document. When empty, it is derived
+	// from the request.
+	ServerOrigin string
+	// OptimizeScreenshots re-encodes captured screenshots with maximum PNG
+	// compression before they are stored. Chromedp hands over loosely
+	// compressed PNGs, so this trades a little CPU per add for disk space.
+	OptimizeScreenshots bool
+	// RetryTrailingSlash makes a fetch answered with 404 retry once with a
+	// trailing slash appended to the path, or removed if there is one. Some
+	// servers 404 on one form without redirecting to the other.
+	RetryTrailingSlash bool
+	// FollowMetaRefresh makes the fetcher follow a <meta http-equiv="refresh">
+	// redirect once, so that the real page is saved instead of the useless
+	// intermediate one. The target is validated against the SSRF rules like
+	// an HTTP redirect.
+	FollowMetaRefresh bool
+	// BlockSchemeDowngrade refuses to follow a redirect from an https page
+	// to plain http, including a meta refresh. Upgrades from http to https
+	// are always followed.
+	BlockSchemeDowngrade bool
+	// StripQueryHosts are host patterns (exact, "*.example.com" or "*")
+	// whose URLs have the query string removed before fetching and saving,
+	// for sites where the query is just session noise. Empty, the default,
+	// keeps every query string.
+	StripQueryHosts []string
+	// ScreenshotSelectors is a list of "pattern=selector" entries mapping
+	// host patterns (as in StripQueryHosts) to a CSS selector to screenshot
+	// instead of the whole viewport, for sites where only part of the page
+	// is interesting. The first matching pattern wins; when the selector
+	// matches nothing on the page, the viewport is captured as usual.
+	ScreenshotSelectors []string
+	// StaticCacheMaxAge is the Cache-Control max-age for the files under
+	// /static/. The assets are not fingerprinted, so a deployment changing
+	// them relies on this age to expire. Zero, the default, leaves caching
+	// to the browser's heuristics.
+	StaticCacheMaxAge time.Duration
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header,
+	// sent on responses to HTTPS requests. The proxy in front announcing
+	// HTTPS with X-Forwarded-Proto counts; plain HTTP responses never carry
+	// the header. Zero, the default, disables HSTS.
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains extends the HSTS policy to subdomains.
+	HSTSIncludeSubdomains bool
+	// RequestTimeout is a per-request deadline, independent of the server's
+	// WriteTimeout: a handler still running when it expires gets its context
+	// cancelled and the client a 503. Zero, the default, applies no
+	// per-request deadline.
+	RequestTimeout time.Duration
+	// MaxIndexBodyBytes caps how many bytes of a fetched page body are
+	// stored and indexed for search, so that very large pages don't bloat
+	// the database; the title and description are always indexed in full.
+	// Zero, the default, stores up to the fetch limit.
+	MaxIndexBodyBytes int
+	// ScreenshotMaxAge makes the background refresher re-capture screenshots
+	// whose file is older than this, so that long-saved links don't show a
+	// stale page. Zero, the default, disables refreshing. Only effective
+	// when screenshots are enabled.
+	ScreenshotMaxAge time.Duration
+	// CheckInterval is how often the background dead-link checker sweeps
+	// over all links with a HEAD request, recording the status each URL
+	// answers with. Zero, the default, disables checking.
+	CheckInterval time.Duration
+	// ScreenshotsByID names screenshot files by link id, {id}.png, instead
+	// of a hash of the URL. The id survives a URL edit and correlates files
+	// with links at a glance. Files from the hash scheme are renamed at the
+	// startup reconciliation.
+	ScreenshotsByID bool
+	// RejectIDNHosts refuses URLs with an internationalized host, in either
+	// Unicode or punycode spelling, for collections that never expect them
+	// and want homograph lookalikes kept out. Off by default; hosts are
+	// then canonicalized to punycode so both spellings dedupe to one link.
+	RejectIDNHosts bool
+	// StripFragments removes the URL fragment before fetching and saving,
+	// so that "page" and "page#top" dedupe to the same link. Off by
+	// default, since fragments can be meaningful deep links.
+	StripFragments bool
+	// BatchAddConcurrency overrides how many URLs of a batch add are fetched
+	// at once, batchAddConcurrency unless set. Browser captures always run
+	// one at a time regardless.
+	BatchAddConcurrency int
+	// StoreHeaders keeps select response headers of the fetch, storedHeaders,
+	// as link metadata under "header:" keys. Off by default to save space;
+	// the browser capture path has no plain response and never stores any.
+	StoreHeaders bool
+	// StoreOriginalURL keeps the URL exactly as it was submitted as link
+	// metadata under "original_url" when canonicalization changed it, so
+	// that what was pasted is not lost to stripped parameters. Off by
+	// default.
+	StoreOriginalURL bool
+	// StoreFetchDuration records how long the metadata fetch of an add
+	// took in the fetch_ms column, shown on the single-link view. Off by
+	// default.
+	StoreFetchDuration bool
+	// FetchFavicons fetches the site's favicon when a link is added — the
+	// icon its page declares, or /favicon.ico — and shows it in the link
+	// list. Off by default: it is one more request per add.
+	FetchFavicons bool
+	// FetchTimeout bounds a whole page fetch, including reading the body,
+	// for slow sites worth waiting longer for. Zero, the default, keeps
+	// the usual 10 seconds.
+	FetchTimeout time.Duration
+	// UserAgent overrides the User-Agent header sent when fetching pages,
+	// for sites which block the default one. Empty, the default, sends
+	// "Mylinks/1.0".
+	UserAgent string
+	// ExtractBodyText indexes only the readable text of an HTML page —
+	// boilerplate like scripts, styles, navigation and footers stripped —
+	// instead of the raw HTML, for cleaner search matches. Off by default,
+	// keeping the raw HTML as indexed before.
+	ExtractBodyText bool
+	// FetchHostInterval spaces fetches to the same host at least this far
+	// apart, so a batch add full of one site's URLs doesn't hammer it.
+	// Zero, the default, applies no spacing.
+	FetchHostInterval time.Duration
+	// FetchURLCooldown is the minimum interval between fetch attempts of
+	// the same URL when adding. A repeated add within the window, typically
+	// a script retrying a failed add, is answered with 429 instead of
+	// hitting the site again. Zero, the default, applies no cooldown.
+	FetchURLCooldown time.Duration
+	// SearchLead chooses which field search result cards lead with:
+	// "description" puts the stored description in the heading, with the
+	// title in the line below, falling back to the title for links
+	// without one. Empty or "title", the default, leads with the title
+	// like the plain list. The FTS index is contentless, so a body
+	// snippet is not an option here.
+	SearchLead string
+	// DisplayTitleLength shortens titles shown in the link list to this
+	// many characters, with the full title kept in storage, in the hover
+	// tooltip and on the single-link view. Zero, the default, shows full
+	// titles in the list too.
+	DisplayTitleLength int
+	// DatabaseFile is the path of the live SQLite database, used by Restore
+	// to stage an uploaded backup next to it. Empty, the default, disables
+	// restoring.
+	DatabaseFile string
+	// MaxTagsPerLink caps how many tags the "tags" metadata key may hold,
+	// maxTagsPerLink unless set. MaxTagLength likewise caps the length of
+	// one tag, maxTagLength unless set.
+	MaxTagsPerLink int
+	MaxTagLength   int
+	// Version is the release version shown by /version and the -version
+	// flag, "dev" unless overridden at build time.
+	Version string
+	// OOBUpdates makes the HTMX add and delete responses update only the
+	// changed row and the link count, through hx-swap-oob fragments, so the
+	// list keeps its scroll position and selection across updates. Off by
+	// default: the full re-render is simpler and always consistent.
+	OOBUpdates bool
+	// MaxDrainBytes caps how much of a fetched response beyond the read
+	// limit is drained before closing it. Draining keeps the connection
+	// reusable for the next fetch to the same host; a response far over
+	// the limit is cheaper to abandon, closing the connection. Zero, the
+	// default, drains up to 256 KiB; negative never drains.
+	MaxDrainBytes int64
+	// AddRatePerMinute limits how many links one client IP can add per
+	// minute, answered with a 429 and a Retry-After once exceeded. An add
+	// triggers an outbound fetch and possibly a browser capture, so an
+	// unthrottled client could use the server as a request amplifier even
+	// behind auth. Zero, the default, applies no limit.
+	AddRatePerMinute int
+
+	executableDir  string
+	database       *db.DB
+	screenshotsDir string
+	templates      *template.Template
+	client         *http.Client
+	browserContext context.Context
+	forTesting     bool
+	// captureScreenshot re-captures the screenshot of a URL for the
+	// background refresher. Replaced in tests.
+	captureScreenshot func(u *url.URL) ([]byte, error)
+	// lookupIP resolves a hostname for the resolved-IP check in validateURL.
+	// Nil outside tests: the real validation resolves by itself through
+	// httputil.ValidateExternalURL. Set in tests to exercise the check
+	// without real DNS.
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+	// checkMu guards lastCheck, which rate limits CheckURL.
+	checkMu   sync.Mutex
+	lastCheck time.Time
+	// batchMu guards the background batch adds polled through BatchStatus.
+	batchMu      sync.Mutex
+	batches      map[string]*batchProgress
+	batchCounter int64
+	// throttleMu guards nextFetch, which spaces fetches to the same host
+	// when FetchHostInterval is set.
+	throttleMu sync.Mutex
+	nextFetch  map[string]time.Time
+	// cooldownMu guards lastURLFetch, which spaces add attempts of the same
+	// URL when FetchURLCooldown is set.
+	cooldownMu   sync.Mutex
+	lastURLFetch map[string]time.Time
+	// addRateMu guards addBuckets, the per-IP token buckets behind
+	// AddRatePerMinute, and addRateSweep, when idle buckets were last
+	// dropped.
+	addRateMu    sync.Mutex
+	addBuckets   map[string]*addBucket
+	addRateSweep time.Time
+	// screenshotsUnavailable is set when the screenshots directory turns
+	// out not to be writable, at startup or on a failed write. Links are
+	// then saved without screenshots instead of every add failing.
+	screenshotsUnavailable atomic.Bool
+}
+
+// NewHandlers creates a new Handlers.
+func NewHandlers(executableDir string, database *db.DB, screenshotsDir string) *Handlers {
+	return newHandlers(executableDir, database, screenshotsDir, false)
+}
+
+// NewHandlersForTesting creates a Handlers which skips external URL
+// validation, so that tests outside this package can fetch from local mock
+// servers.
+func NewHandlersForTesting(executableDir string, database *db.DB, screenshotsDir string) *Handlers {
+	return newHandlers(executableDir, database, screenshotsDir, true)
+}
+
+func newHandlers(executableDir string, database *db.DB, screenshotsDir string, forTesting bool) *Handlers {
+	// h is assigned below; displayTitle closes over it so the template
+	// function sees the display length configured after construction.
+	var h *Handlers
+	templates := template.New("").Funcs(template.FuncMap{
+		"screenshotName": func(link db.Link) string { return h.screenshotFile(link.ID, link.URL) },
+		"isNote":         isNote,
+		"displayTitle":   func(title string) string { return h.displayTitle(title) },
+		"showFavicons":   func() bool { return h.FetchFavicons },
+	})
+
+	templatesDir := filepath.Join(executableDir, "ui/templates")
+	templateFiles, err := filepath.Glob(filepath.Join(templatesDir, "*.html"))
+	if err != nil {
+		log.Fatalf("Unable to glob templates: %v", err)
+	}
+
+	if len(templateFiles) > 0 {
+		templates, err = templates.ParseFiles(templateFiles...)
+	} else {
+		templates, err = templates.ParseFS(ui.Files, "templates/*.html")
+	}
+	if err != nil {
+		log.Fatalf("Unable to read templates: %v", err)
+	}
+	if templates == nil {
+		log.Fatalf("No templates found")
+	}
+
+	// Create an HTTP client with improved configuration to handle various websites,
+	// hardened against SSRF: the dialer validates the resolved IP at connect time
+	// (closing the TOCTOU / DNS-rebinding window between validation and fetch) and
+	// CheckRedirect re-validates every redirect target. In tests, loopback addresses
+	// (the mock httptest servers) must remain reachable, so the safe dialer and
+	// redirect validation are skipped when forTesting is set.
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	dialContext := dialer.DialContext
+	if !forTesting {
+		dialContext = httputil.SafeDialContext(dialer)
+	}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: dialContext,
+			// Force HTTP/1.1 to avoid HTTP/2 issues with some websites
+			ForceAttemptHTTP2: false,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: false,
+			},
+			// Set reasonable timeouts
+			IdleConnTimeout:       30 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if h.BlockSchemeDowngrade && schemeDowngraded(via[len(via)-1].URL, req.URL) {
+				return fmt.Errorf("refusing redirect from %s to plain http", via[len(via)-1].URL)
+			}
+			if forTesting {
+				return nil
+			}
+			return httputil.ValidateExternalURL(req.URL.String())
+		},
+	}
+
+	var browserContext context.Context
+	dockerURL := os.Getenv("CHROMEDP")
+	if dockerURL != "" {
+		if err := os.MkdirAll(screenshotsDir, 0700); err != nil {
+			log.Fatalf("failed to create screenshots directory: %v", err)
+		}
+
+		allocatorContext, _ := chromedp.NewRemoteAllocator(context.Background(), dockerURL)
+		browserContext, _ = chromedp.NewContext(allocatorContext)
+	}
+
+	h = &Handlers{
+		FetchAccept:    DefaultFetchAccept,
+		ContentTypes:   DefaultContentTypes,
+		executableDir:  executableDir,
+		database:       database,
+		screenshotsDir: screenshotsDir,
+		templates:      templates,
+		client:         client,
+		browserContext: browserContext,
+		forTesting:     forTesting,
+	}
+	h.captureScreenshot = func(u *url.URL) ([]byte, error) {
+		_, _, _, screenshot, err := h.extractTitleAndDescriptionAndBodyAndScreenshotFromURL(u)
+		return screenshot, err
+	}
+	if browserContext != nil {
+		if err := checkWritable(screenshotsDir); err != nil {
+			log.Printf("Screenshots directory %s is not writable, saving links without screenshots: %v", screenshotsDir, err)
+			h.screenshotsUnavailable.Store(true)
+		}
+	}
+	return h
+}
+
+// defaultFetchTimeout bounds a whole page fetch, including reading the
+// body, unless FetchTimeout overrides it.
+const defaultFetchTimeout = 10 * time.Second
+
+// defaultUserAgent is sent when fetching pages, unless UserAgent
+// overrides it.
+const defaultUserAgent = "Mylinks/1.0"
+
+// fetchClient is the HTTP client with the configured fetch timeout applied.
+// A shallow copy per call, so the transport and its connection pools stay
+// shared while the timeout can differ from what the client was built with.
+func (h *Handlers) fetchClient() *http.Client {
+	client := *h.client
+	client.Timeout = defaultFetchTimeout
+	if h.FetchTimeout > 0 {
+		client.Timeout = h.FetchTimeout
+	}
+	return &client
+}
+
+// userAgent is the User-Agent header to fetch pages with.
+func (h *Handlers) userAgent() string {
+	if h.UserAgent != "" {
+		return h.UserAgent
+	}
+	return defaultUserAgent
+}
+
+// checkWritable probes whether files can be created in dir, by writing and
+// removing a probe file.
+func checkWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".writable-*")
+	if err != nil {
+		return err
+	}
+	_ = probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// Routes sets up and returns the HTTP routing handler for the application.
+// It configures routes for static files, screenshots (if enabled), and API endpoints.
+// Static files are served either from the local filesystem or embedded files.
+// If authentication is configured (via usernameBcryptHash and passwordBcryptHash),
+// all routes will be protected with HTTP Basic Authentication.
+// Returns an http.Handler that includes all configured routes with common headers.
+func (h *Handlers) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	staticDir := filepath.Join(h.executableDir, "ui/static")
+	staticFiles, err := filepath.Glob(filepath.Join(staticDir, "*"))
+	if err != nil {
+		log.Fatalf("Unable to glob static files: %v", err)
+	}
+	if len(staticFiles) > 0 {
+		mux.Handle("GET /static/", h.staticCache(http.StripPrefix("/static", http.FileServer(http.Dir(staticDir)))))
+	} else {
+		mux.Handle("GET /static/", h.staticCache(http.FileServerFS(ui.Files)))
+	}
+
+	if h.browserContext != nil {
+		mux.Handle("GET /screenshots/", http.StripPrefix("/screenshots", h.screenshotCache(http.FileServer(http.Dir(h.screenshotsDir)))))
+	}
+
+	mux.HandleFunc("GET /bookmarklet", h.BookmarkletSave)
+
+	mux.HandleFunc("GET /manifest.webmanifest", h.Manifest)
+	mux.HandleFunc("GET /sw.js", h.ServiceWorker)
+
+	mux.HandleFunc("GET /admin/errors", h.FetchErrors)
+	mux.HandleFunc("GET /admin/backup", h.Backup)
+	mux.HandleFunc("POST /admin/restore", h.Restore)
+	mux.HandleFunc("POST /admin/reprocess", h.Reprocess)
+	mux.HandleFunc("POST /{id}/share", h.CreateShare)
+	mux.HandleFunc("DELETE /{id}/share", h.RevokeShare)
+	mux.HandleFunc("GET /share/{token}", h.SharedLink)
+	mux.HandleFunc("GET /share/{token}/screenshot.png", h.SharedScreenshot)
+	mux.HandleFunc("GET /opensearch.xml", h.OpenSearch)
+	mux.HandleFunc("GET /feed.atom", h.Feed)
+	mux.HandleFunc("GET /oembed", h.OEmbed)
+	mux.HandleFunc("GET /check", h.CheckURL)
+	mux.HandleFunc("GET /openapi.json", h.OpenAPI)
+	mux.HandleFunc("GET /version", h.ShowVersion)
+	mux.HandleFunc("GET /stats", h.Stats)
+	mux.HandleFunc("GET /export", h.ExportBookmarks)
+	mux.HandleFunc("POST /import", h.ImportBookmarks)
+	mux.HandleFunc("GET /export.csv", h.ExportCSV)
+	mux.HandleFunc("POST /import.csv", h.ImportCSV)
+
+	mux.HandleFunc("GET /{$}", h.ListLinks)
+	mux.Handle("POST /{$}", h.addRateLimit(http.HandlerFunc(h.AddItem)))
+	mux.HandleFunc("POST /batch-add", h.BatchAdd)
+	mux.HandleFunc("POST /batch-delete", h.BatchDelete)
+	mux.HandleFunc("GET /batch-add/{batch}", h.BatchStatus)
+	mux.HandleFunc("GET /{id}", h.GetLink)
+	mux.HandleFunc("PATCH /{id}", h.EditLink)
+	mux.HandleFunc("POST /{id}/position", h.SetPosition)
+	mux.HandleFunc("POST /{id}/read", h.SetRead)
+	mux.HandleFunc("POST /{id}/reindex", h.ReindexBody)
+	mux.HandleFunc("POST /{id}/screenshot", h.RecaptureScreenshot)
+	// Not /{id}/favicon and /{id}/content: a GET under /{id}/ is ambiguous
+	// against the /static/ subtree for the mux, while POSTs there are not.
+	mux.HandleFunc("GET /favicon/{id}", h.Favicon)
+	mux.HandleFunc("GET /content/{id}", h.Content)
+	mux.HandleFunc("POST /reindex", h.ReindexBodies)
+	mux.HandleFunc("DELETE /{id}", h.DeleteLink)
+	mux.HandleFunc("GET /trash", h.Trash)
+	mux.HandleFunc("DELETE /trash", h.PurgeTrash)
+	mux.HandleFunc("POST /{id}/restore", h.RestoreLink)
+
+	// recoverPanic sits inside logRequest, so a recovered panic is logged
+	// with its 500 status, and outside requestTimeout, which re-raises a
+	// panic from the handler it wraps. gzipResponse sits inside logRequest
+	// as well, so the logged response size is what went over the wire.
+	return h.commonHeaders(h.logRequest(h.gzipResponse(h.recoverPanic(h.requestTimeout(mux)))))
+}
+
+type Link struct {
+	ID          int64
+	URL         string
+	Title       string
+	Description string
+	AddedAt     time.Time
+	Screenshot  string
+}
+
+type LinkView struct {
+	db.Link
+	Edit bool
+	// Full shows the complete title regardless of DisplayTitleLength, for
+	// the single-link view.
+	Full bool
+	// Lead, when set, replaces the title as the card heading; search
+	// results show the description there when SearchLead asks for it.
+	Lead string
+}
+
+// displayTitle shortens a title for the link list according to
+// DisplayTitleLength; the templates keep the full title in the title
+// attribute. Zero length returns the title as-is.
+func (h *Handlers) displayTitle(title string) string {
+	if h.DisplayTitleLength <= 0 || len(title) <= h.DisplayTitleLength {
+		return title
+	}
+	return title[:h.DisplayTitleLength] + "..."
+}
+
+// ListLinks handles the request to list all links.
+func (h *Handlers) ListLinks(w http.ResponseWriter, r *http.Request) {
+	h.listLinks(w, r, http.StatusOK)
+}
+
+// AddItem handles the request to add a new item.
+func (h *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	urlString := r.PostForm.Get("url")
+	if urlString != "" {
+		// Parse and validate URL
+		parsedURL, err := url.Parse(urlString)
+		if err != nil || h.validateURL(parsedURL) != nil {
+			sendError(w, "Invalid URL. Must be a valid HTTP/HTTPS URL", http.StatusBadRequest)
+			return
+		}
+		h.addLink(w, r, parsedURL)
+	} else {
+		if err := r.ParseForm(); err != nil {
+			sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		title := strings.TrimSpace(r.PostForm.Get("note-title"))
+		if title == "" {
+			sendError(w, "Note title is required", http.StatusBadRequest)
+			return
+		}
+		if len(title) > maxTitleLength {
+			sendError(w, fmt.Sprintf("Note title is too long, max %d characters allowed", maxTitleLength), http.StatusBadRequest)
+			return
+		}
+
+		note := strings.TrimSpace(r.PostForm.Get("note-text"))
+		if note == "" {
+			sendError(w, "Note text is required", http.StatusBadRequest)
+			return
+		}
+		if len(note) > maxDescriptionLength {
+			sendError(w, fmt.Sprintf("Note text is too long, max %d characters allowed", maxDescriptionLength), http.StatusBadRequest)
+			return
+		}
+		h.addNote(w, r, title, note)
+	}
+}
+
+// saveLink fetches the URL, extracts metadata, and saves it to the database.
+// The source records which add path the link came in through.
+// Returns the link ID, an error message, and an HTTP status code.
+func (h *Handlers) saveLink(ctx context.Context, urlToSave *url.URL, source string) (int64, string, int) {
+	originalURL := urlToSave.String()
+	urlToSave = h.canonicalizeURL(urlToSave)
+
+	if h.urlOnCooldown(urlToSave) {
+		return 0, fmt.Sprintf("%s was just fetched, try again later", urlToSave), http.StatusTooManyRequests
+	}
+
+	fetchStart := time.Now()
+	title, description, body, meta, screenshot, err := h.extract(ctx, urlToSave)
+	var statusErr *httpStatusError
+	if err != nil && h.RetryTrailingSlash && errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound {
+		if alternate := toggleTrailingSlash(urlToSave); alternate != nil {
+			if t, d, b, m, s, retryErr := h.extract(ctx, alternate); retryErr == nil {
+				log.Printf("Fetching %s failed but %s succeeded, saving the latter", urlToSave, alternate)
+				urlToSave, title, description, body, meta, screenshot, err = alternate, t, d, b, m, s, nil
+			}
+		}
+	}
+	fetchDuration := time.Since(fetchStart)
+	if err != nil {
+		h.recordFetchError(ctx, urlToSave.String(), err)
+		return 0, fmt.Sprintf("Failed to load URL: %v", err), http.StatusBadRequest
+	}
+
+	body = h.limitIndexBody(urlToSave.String(), body)
+
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	id, err := h.database.AddLink(writeCtx, urlToSave.String(), title, description, source, body)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicate) {
+			// Pointing at the existing link lets the caller send the user
+			// there instead of to a dead-end error.
+			if existingID, lookupErr := h.database.GetLinkIDByURL(writeCtx, urlToSave.String()); lookupErr == nil {
+				return existingID, fmt.Sprintf("URL already exists as link %d", existingID), http.StatusConflict
+			}
+			return 0, "URL already exists", http.StatusConflict
+		}
+		if errors.Is(err, db.ErrDuplicateTitle) {
+			return 0, "A link with the same title already exists", http.StatusConflict
+		}
+		return 0, fmt.Sprintf("Failed to add link: %v", err), http.StatusInternalServerError
+	}
+
+	// The fetch duration is diagnostic, failing to store it must not fail
+	// the add either.
+	if h.StoreFetchDuration {
+		if err := h.database.SetFetchMs(writeCtx, id, fetchDuration.Milliseconds()); err != nil {
+			log.Printf("Failed to store the fetch duration for link %d: %v", id, err)
+		}
+	}
+
+	// The published time, when the page declares one in a known format, goes
+	// into its own column so that lists can sort by it.
+	if value, ok := meta[publishedMetaKey]; ok {
+		delete(meta, publishedMetaKey)
+		if publishedAt := parsePublished(value); publishedAt != nil {
+			if err := h.database.SetPublishedAt(writeCtx, id, publishedAt); err != nil {
+				log.Printf("Failed to store published time for link %d: %v", id, err)
+			}
+		}
+	}
+
+	// The favicon href is not metadata to store either, it points at the
+	// icon to fetch.
+	faviconHref := meta[faviconMetaKey]
+	delete(meta, faviconMetaKey)
+	if h.FetchFavicons {
+		h.fetchFavicon(ctx, id, urlToSave, faviconHref)
+	}
+
+	// Auto-extracted metadata is a bonus, failing to store it must not fail
+	// the add.
+	for key, value := range meta {
+		if err := h.database.SetMeta(writeCtx, id, key, value); err != nil {
+			log.Printf("Failed to store %s metadata for link %d: %v", key, id, err)
+		}
+	}
+
+	if h.StoreOriginalURL && originalURL != urlToSave.String() {
+		if err := h.database.SetMeta(writeCtx, id, originalURLMetaKey, originalURL); err != nil {
+			log.Printf("Failed to store the original URL for link %d: %v", id, err)
+		}
+	}
+
+	if screenshot != nil && !h.screenshotsUnavailable.Load() {
+		if h.OptimizeScreenshots {
+			screenshot = optimizeScreenshot(screenshot)
+		}
+		if err = h.saveScreenshot(id, urlToSave.String(), screenshot); err != nil {
+			// A read-only or full disk must not fail the add: the link works
+			// without its screenshot, and there is no point retrying on the
+			// next one.
+			log.Printf("Disabling screenshots, failed to save one for %s: %v", urlToSave, err)
+			h.screenshotsUnavailable.Store(true)
+		}
+	} else if screenshot == nil && h.browserContext != nil && !h.screenshotsUnavailable.Load() {
+		// The capture failed; note it so the background retrier picks the
+		// link up later.
+		if err := h.database.RecordScreenshotFailure(writeCtx, id); err != nil {
+			log.Printf("Failed to record the screenshot failure for link %d: %v", id, err)
+		}
+	}
+
+	return id, "", http.StatusCreated
+}
+
+// faviconMaxBytes caps a stored favicon; anything larger is not an icon.
+const faviconMaxBytes = 256 << 10
+
+// fetchFavicon fetches the favicon of a freshly added link and stores it:
+// the href its page declared, or /favicon.ico when it declared none.
+// Failures are logged only, a link without an icon is still a good link.
+func (h *Handlers) fetchFavicon(ctx context.Context, id int64, pageURL *url.URL, href string) {
+	faviconURL := &url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host, Path: "/favicon.ico"}
+	if href != "" {
+		if resolved, err := url.Parse(href); err == nil {
+			faviconURL = pageURL.ResolveReference(resolved)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, faviconURL.String(), nil)
+	if err != nil {
+		log.Printf("Failed to fetch favicon %s: %v", faviconURL, err)
+		return
+	}
+	req.Header.Set("User-Agent", h.userAgent())
+	resp, err := h.fetchClient().Do(req)
+	if err != nil {
+		log.Printf("Failed to fetch favicon %s: %v", faviconURL, err)
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	favicon, err := io.ReadAll(io.LimitReader(resp.Body, faviconMaxBytes+1))
+	if err != nil || len(favicon) == 0 || len(favicon) > faviconMaxBytes {
+		return
+	}
+
+	// Some servers answer /favicon.ico with an HTML error page and status
+	// 200; requiring an image type keeps those out.
+	contentType := mediaTypeOf(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = mediaTypeOf(http.DetectContentType(favicon))
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return
+	}
+
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	if err := h.database.SetFavicon(writeCtx, id, contentType, favicon); err != nil {
+		log.Printf("Failed to store favicon for link %d: %v", id, err)
+	}
+}
+
+// BatchAddResult is the outcome for one URL of a batch add.
+type BatchAddResult struct {
+	URL    string `json:"url"`
+	Status string `json:"status"` // "created", "duplicate" or "failed"
+	ID     int64  `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchAddConcurrency bounds how many URLs of a batch are fetched at once, so
+// that a large batch neither overwhelms this server nor hammers the sites
+// being saved.
+const batchAddConcurrency = 4
+
+// BatchAdd handles the request to add several links at once, for browser
+// extensions saving all open tabs. The body is a JSON array of URLs, the
+// response a JSON array with the outcome for each, in the same order. One URL
+// failing does not fail the others. With async=true the batch runs in the
+// background instead, and the response is the id of a batchProgress to poll
+// through BatchStatus.
+func (h *Handlers) BatchAdd(w http.ResponseWriter, r *http.Request) {
+	var urls []string
+	if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("dryrun") == "true" {
+		h.renderJson(w, h.batchAddDryRun(r.Context(), urls), http.StatusOK)
+		return
+	}
+
+	if r.URL.Query().Get("async") == "true" {
+		// The batch outlives the request, so it must not die with it
+		id := h.startBatch(context.WithoutCancel(r.Context()), urls)
+		w.Header().Set("Location", "/batch-add/"+id)
+		h.renderJson(w, map[string]string{"id": id}, http.StatusAccepted)
+		return
+	}
+
+	results := h.runBatch(r.Context(), urls, nil)
+	h.renderJson(w, results, http.StatusOK)
+}
+
+// batchConcurrency is how many URLs of a batch are fetched at once,
+// BatchAddConcurrency when set and batchAddConcurrency otherwise. The
+// browser context is shared, navigating it concurrently would mix up the
+// pages being captured, so browser captures always run one at a time.
+func (h *Handlers) batchConcurrency() int {
+	if h.browserContext != nil {
+		return 1
+	}
+	if h.BatchAddConcurrency > 0 {
+		return h.BatchAddConcurrency
+	}
+	return batchAddConcurrency
+}
+
+// runBatch adds the URLs of a batch with bounded concurrency, calling done
+// after each URL when it is not nil.
+func (h *Handlers) runBatch(ctx context.Context, urls []string, done func()) []BatchAddResult {
+	results := make([]BatchAddResult, len(urls))
+	semaphore := make(chan struct{}, h.batchConcurrency())
+	var wg sync.WaitGroup
+	for i, urlString := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[i] = h.batchAddOne(ctx, urlString)
+			if done != nil {
+				done()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// batchProgress is the state of a background batch add, polled through
+// BatchStatus. Results is set once the whole batch has finished. Finished
+// batches are kept until the server restarts; batches are small and rare
+// enough that pruning them is not worth the bookkeeping.
+type batchProgress struct {
+	Total   int              `json:"total"`
+	Done    int              `json:"done"`
+	Results []BatchAddResult `json:"results,omitempty"`
+}
+
+// startBatch launches a batch add in the background and returns the id to
+// poll its progress with.
+func (h *Handlers) startBatch(ctx context.Context, urls []string) string {
+	h.batchMu.Lock()
+	h.batchCounter++
+	id := strconv.FormatInt(h.batchCounter, 10)
+	if h.batches == nil {
+		h.batches = make(map[string]*batchProgress)
+	}
+	progress := &batchProgress{Total: len(urls)}
+	h.batches[id] = progress
+	h.batchMu.Unlock()
+
+	go func() {
+		results := h.runBatch(ctx, urls, func() {
+			h.batchMu.Lock()
+			progress.Done++
+			h.batchMu.Unlock()
+		})
+		h.batchMu.Lock()
+		progress.Results = results
+		h.batchMu.Unlock()
+	}()
+
+	return id
+}
+
+// BatchStatus handles the request to poll the progress of a background batch
+// add, by the id BatchAdd returned for it.
+func (h *Handlers) BatchStatus(w http.ResponseWriter, r *http.Request) {
+	h.batchMu.Lock()
+	progress, ok := h.batches[r.PathValue("batch")]
+	var snapshot batchProgress
+	if ok {
+		// A copy, so rendering happens outside the lock; Results is only
+		// ever assigned whole
+		snapshot = *progress
+	}
+	h.batchMu.Unlock()
+	if !ok {
+		sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	h.renderJson(w, snapshot, http.StatusOK)
+}
+
+// batchAddDryRun previews a batch add without fetching or writing anything:
+// which URLs are invalid, which are already saved and which would be added.
+// The results use the same statuses as a real batch, where "created" means
+// the URL would be created.
+func (h *Handlers) batchAddDryRun(ctx context.Context, urls []string) []BatchAddResult {
+	results := make([]BatchAddResult, len(urls))
+	for i, urlString := range urls {
+		result := BatchAddResult{URL: urlString}
+		parsedURL, err := url.Parse(urlString)
+		// A URL without an absolute http(s) form can never be fetched, so
+		// it is invalid even before the SSRF rules.
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || h.validateURL(parsedURL) != nil {
+			result.Status = "failed"
+			result.Error = "Invalid URL. Must be a valid HTTP/HTTPS URL"
+		} else if id, err := h.database.GetLinkIDByURL(ctx, h.canonicalizeURL(parsedURL).String()); err == nil {
+			result.Status = "duplicate"
+			result.ID = id
+		} else {
+			result.Status = "created"
+		}
+		results[i] = result
+	}
+	return results
+}
+
+// batchAddOne adds a single URL of a batch, reusing the single-add logic.
+func (h *Handlers) batchAddOne(ctx context.Context, urlString string) BatchAddResult {
+	result := BatchAddResult{URL: urlString}
+
+	parsedURL, err := url.Parse(urlString)
+	if err != nil || h.validateURL(parsedURL) != nil {
+		result.Status = "failed"
+		result.Error = "Invalid URL. Must be a valid HTTP/HTTPS URL"
+		return result
+	}
+
+	id, errMsg, status := h.saveLink(ctx, parsedURL, "batch")
+	switch {
+	case errMsg == "":
+		result.Status = "created"
+		result.ID = id
+	case status == http.StatusConflict:
+		result.Status = "duplicate"
+		// The id of the existing link, when the duplicate check found it
+		result.ID = id
+	default:
+		result.Status = "failed"
+		result.Error = errMsg
+	}
+	return result
+}
+
+// openSearchDescription is the document served at /opensearch.xml. Marshalled
+// rather than written as a literal, so that it cannot be malformed.
+type openSearchDescription struct {
+	XMLName     xml.Name      `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName   string        `xml:"ShortName"`
+	Description string        `xml:"Description"`
+	URL         openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// origin returns the public-facing base URL for building absolute URLs in
+// responses: ServerOrigin when configured, otherwise derived from the
+// request.
+func (h *Handlers) origin(r *http.Request) string {
+	if h.ServerOrigin != "" {
+		return h.ServerOrigin
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// OpenSearch serves an OpenSearch description document, which lets browsers
+// register the link search as an address bar search engine.
+func (h *Handlers) OpenSearch(w http.ResponseWriter, r *http.Request) {
+	document, err := xml.Marshal(openSearchDescription{
+		ShortName:   "MyLinks",
+		Description: "Search saved links",
+		URL: openSearchURL{
+			Type:     "text/html",
+			Template: h.origin(r) + "/?s={searchTerms}",
+		},
+	})
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to marshal OpenSearch document: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	_, _ = fmt.Fprintln(w, xml.Header+string(document))
+}
+
+// atomFeedLimit is how many of the most recently added links the feed holds.
+// Feed readers poll, so older entries they have already seen need not be
+// repeated.
+const atomFeedLimit = 50
+
+// atomFeed is the document served at /feed.atom. Marshalled rather than
+// written as a literal, like the OpenSearch document, so that a title or
+// description holding markup cannot malform it.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+// Feed serves the most recently added links as an Atom feed, for following
+// the collection from a feed reader. It sits behind the same authentication
+// as the rest of the app, so a reader needs the credentials in its feed URL.
+func (h *Handlers) Feed(w http.ResponseWriter, r *http.Request) {
+	links, err := h.database.GetLinksPage(r.Context(), atomFeedLimit, 0)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	origin := h.origin(r)
+	feed := atomFeed{
+		Title: "MyLinks",
+		ID:    origin + "/",
+		// An empty feed still needs an updated time, the newest link
+		// overrides this below.
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: origin + "/feed.atom", Rel: "self"},
+			{Href: origin + "/"},
+		},
+	}
+	if len(links) > 0 {
+		feed.Updated = links[0].AddedAt.UTC().Format(time.RFC3339)
+	}
+	for _, link := range links {
+		// A note has no page of its own, its entry points at the
+		// single-link view instead.
+		href := link.URL
+		if isNote(link.URL) {
+			href = fmt.Sprintf("%s/%d", origin, link.ID)
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   link.Title,
+			ID:      fmt.Sprintf("%s/%d", origin, link.ID),
+			Link:    atomLink{Href: href},
+			Updated: link.AddedAt.UTC().Format(time.RFC3339),
+			Summary: link.Description,
+		})
+	}
+
+	document, err := xml.Marshal(feed)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to marshal feed: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml")
+	_, _ = fmt.Fprintln(w, xml.Header+string(document))
+}
+
+// Manifest serves the web app manifest, which makes the app installable to a
+// home screen. It is served from the root so that its scope can cover the
+// whole app, and with the manifest content type which the extension alone
+// would not get.
+func (h *Handlers) Manifest(w http.ResponseWriter, r *http.Request) {
+	h.serveStaticFile(w, "manifest.webmanifest", "application/manifest+json")
+}
+
+// ServiceWorker serves the service worker script. A service worker cannot
+// control pages above its own path, so it must be served from the root
+// rather than under /static/.
+func (h *Handlers) ServiceWorker(w http.ResponseWriter, r *http.Request) {
+	h.serveStaticFile(w, "sw.js", "text/javascript; charset=utf-8")
+}
+
+// serveStaticFile serves one static asset with an explicit content type,
+// from the local filesystem when present and the embedded files otherwise,
+// mirroring how the /static/ routes pick their source.
+func (h *Handlers) serveStaticFile(w http.ResponseWriter, name string, contentType string) {
+	content, err := os.ReadFile(filepath.Join(h.executableDir, "ui/static", name))
+	if err != nil {
+		content, err = ui.Files.ReadFile("static/" + name)
+	}
+	if err != nil {
+		sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(content)
+}
+
+// canonicalizeURL normalizes a URL before it is fetched and saved. The scheme
+// and host are always lowercased, since they are case-insensitive and differing
+// case would otherwise store trivial duplicates; an internationalized host is
+// converted to its punycode form, so both spellings of it dedupe to one link
+// and the URL validation sees one canonical host. A default port, common
+// tracking parameters and a trailing slash are dropped as well, through
+// normalizeURL. For hosts matching StripQueryHosts the query string is
+// dropped entirely, and with StripFragments the fragment is dropped as well.
+func (h *Handlers) canonicalizeURL(u *url.URL) *url.URL {
+	if lowered := strings.ToLower(u.Scheme); lowered != u.Scheme || strings.ToLower(u.Host) != u.Host {
+		canonical := *u
+		canonical.Scheme = lowered
+		canonical.Host = strings.ToLower(u.Host)
+		u = &canonical
+	}
+	if ascii, err := idna.Lookup.ToASCII(u.Hostname()); err == nil && ascii != u.Hostname() {
+		canonical := *u
+		canonical.Host = ascii
+		if port := u.Port(); port != "" {
+			canonical.Host += ":" + port
+		}
+		u = &canonical
+	}
+	u = normalizeURL(u)
+	if h.StripFragments && u.Fragment != "" {
+		stripped := *u
+		stripped.Fragment = ""
+		stripped.RawFragment = ""
+		u = &stripped
+	}
+	if u.RawQuery == "" {
+		return u
+	}
+	for _, pattern := range h.StripQueryHosts {
+		if hostMatches(u.Hostname(), pattern) {
+			stripped := *u
+			stripped.RawQuery = ""
+			return &stripped
+		}
+	}
+	return u
+}
+
+// NormalizeURL normalizes a raw URL string the way the add paths store one:
+// the scheme and host are lowercased, a default port is dropped, common
+// tracking parameters are stripped and a trailing slash is collapsed.
+// Fragment and whole-query stripping stay behind the StripFragments and
+// StripQueryHosts options, since anchors and queries can be meaningful.
+func NormalizeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	lowered := *u
+	lowered.Scheme = strings.ToLower(u.Scheme)
+	lowered.Host = strings.ToLower(u.Host)
+	return normalizeURL(&lowered).String(), nil
+}
+
+// normalizeURL applies the always-on normalization shared by canonicalizeURL
+// and NormalizeURL: a default port is dropped, tracking parameters (utm_*,
+// fbclid, gclid) are stripped, since they only identify a campaign and would
+// otherwise store the same page several times, and trailing slashes are
+// collapsed, except on the root path. Returns u itself when nothing applies.
+func normalizeURL(u *url.URL) *url.URL {
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) ||
+		(u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		normalized := *u
+		normalized.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+		u = &normalized
+	}
+	if u.RawQuery != "" {
+		values := u.Query()
+		stripped := false
+		for name := range values {
+			if isTrackingParam(name) {
+				values.Del(name)
+				stripped = true
+			}
+		}
+		if stripped {
+			normalized := *u
+			normalized.RawQuery = values.Encode()
+			u = &normalized
+		}
+	}
+	if trimmed := strings.TrimRight(u.Path, "/"); trimmed != u.Path && trimmed != "" {
+		normalized := *u
+		normalized.Path = trimmed
+		if normalized.RawPath != "" {
+			normalized.RawPath = strings.TrimRight(normalized.RawPath, "/")
+		}
+		u = &normalized
+	}
+	return u
+}
+
+// isTrackingParam reports whether a query parameter only identifies the
+// campaign a visit came from, rather than selecting content.
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || name == "fbclid" || name == "gclid"
+}
+
+// screenshotSelectorFor returns the CSS selector configured for a host in
+// ScreenshotSelectors, or the empty string for a viewport capture.
+func (h *Handlers) screenshotSelectorFor(host string) string {
+	for _, entry := range h.ScreenshotSelectors {
+		pattern, selector, found := strings.Cut(entry, "=")
+		if !found || selector == "" {
+			continue
+		}
+		if hostMatches(host, pattern) {
+			return selector
+		}
+	}
+	return ""
+}
+
+// hostMatches reports whether a host matches a pattern: exactly, or, for a
+// pattern starting with "*.", the rest of the pattern and any subdomain of
+// it. "*" alone matches every host.
+func hostMatches(host, pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if rest, found := strings.CutPrefix(pattern, "*."); found {
+		return host == rest || strings.HasSuffix(host, "."+rest)
+	}
+	return host == pattern
+}
+
+// extract fetches a URL and extracts its metadata, through the browser when
+// screenshots are enabled. The screenshot is nil otherwise; the meta map
+// holds auto-extracted key/value metadata, nil in the browser path.
+func (h *Handlers) extract(ctx context.Context, u *url.URL) (string, string, []byte, map[string]string, []byte, error) {
+	if h.browserContext != nil {
+		title, description, body, screenshot, err := h.extractTitleAndDescriptionAndBodyAndScreenshotFromURL(u)
+		return title, description, body, nil, screenshot, err
+	}
+	title, description, body, meta, err := h.extractTitleAndDescriptionAndBodyFromURL(ctx, u)
+	return title, description, body, meta, nil, err
+}
+
+// toggleTrailingSlash returns a copy of the URL with a trailing slash removed
+// from its path, or appended if there is none. Returns nil for the root path,
+// which cannot lose its slash.
+func toggleTrailingSlash(u *url.URL) *url.URL {
+	alternate := *u
+	if strings.HasSuffix(alternate.Path, "/") {
+		alternate.Path = strings.TrimSuffix(alternate.Path, "/")
+		if alternate.Path == "" {
+			return nil
+		}
+	} else {
+		alternate.Path += "/"
+	}
+	return &alternate
+}
+
+// oEmbedResponse is a minimal oEmbed "link" type response, see
+// https://oembed.com/.
+type oEmbedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// OEmbed serves an oEmbed response for a saved link, so that other tools can
+// embed it. The url parameter is the path of the link, "/{id}", optionally
+// with the server origin in front.
+func (h *Handlers) OEmbed(w http.ResponseWriter, r *http.Request) {
+	urlParam := r.URL.Query().Get("url")
+	if urlParam == "" {
+		sendError(w, "url parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	origin := h.origin(r)
+	idStr := strings.TrimPrefix(strings.TrimPrefix(urlParam, origin), "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid url parameter: %s", urlParam), http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.database.GetLink(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	response := oEmbedResponse{
+		Version:      "1.0",
+		Type:         "link",
+		Title:        link.Title,
+		ProviderName: "MyLinks",
+		ProviderURL:  origin,
+		HTML: fmt.Sprintf(`<a href="%s">%s</a>`,
+			template.HTMLEscapeString(link.URL), template.HTMLEscapeString(link.Title)),
+	}
+	if h.browserContext != nil {
+		response.ThumbnailURL = origin + "/screenshots/" + h.screenshotFile(link.ID, link.URL)
+	}
+
+	h.renderJson(w, response, http.StatusOK)
+}
+
+// ExportBookmarks serves every link as a Netscape bookmark file, the format
+// browsers and bookmark managers exchange. One DT/A element per link with
+// the saved time in ADD_DATE, and the description as a DD element when there
+// is one.
+func (h *Handlers) ExportBookmarks(w http.ResponseWriter, r *http.Request) {
+	links, err := h.database.GetAllLinks(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="linksaver-bookmarks.html"`)
+	_, _ = fmt.Fprint(w, "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n")
+	_, _ = fmt.Fprint(w, "<META HTTP-EQUIV=\"Content-Type\" CONTENT=\"text/html; charset=UTF-8\">\n")
+	_, _ = fmt.Fprint(w, "<TITLE>Bookmarks</TITLE>\n")
+	_, _ = fmt.Fprint(w, "<H1>Bookmarks</H1>\n")
+	_, _ = fmt.Fprint(w, "<DL><p>\n")
+	for _, link := range links {
+		_, _ = fmt.Fprintf(w, "    <DT><A HREF=\"%s\" ADD_DATE=\"%d\">%s</A>\n",
+			template.HTMLEscapeString(link.URL), link.AddedAt.Unix(), template.HTMLEscapeString(link.Title))
+		if link.Description != "" {
+			_, _ = fmt.Fprintf(w, "    <DD>%s\n", template.HTMLEscapeString(link.Description))
+		}
+	}
+	_, _ = fmt.Fprint(w, "</DL><p>\n")
+}
+
+// ImportSummary is the outcome of a bookmark import: how many entries became
+// links, how many were already saved and how many could not be added.
+type ImportSummary struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+// ImportBookmarks handles the upload of a Netscape bookmark file, the format
+// ExportBookmarks writes and browsers export. Every anchor in the file
+// becomes a link with the anchor text as its title, without fetching the
+// pages; an ADD_DATE attribute carries over as the link's added time.
+// Entries already saved are skipped rather than aborting the import. The
+// response is a JSON summary of the imported, skipped and failed counts.
+func (h *Handlers) ImportBookmarks(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("bookmarks")
+	if err != nil {
+		sendError(w, fmt.Sprintf("Missing bookmarks file: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// The Netscape format predates HTML as parsers know it, but its anchors
+	// survive lenient parsing, which is all the import looks at
+	doc, err := html.Parse(file)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse bookmarks: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var summary ImportSummary
+	walkNodes(doc, func(n *html.Node) walkAction {
+		if n.Type != html.ElementNode || n.Data != "a" {
+			return walkContinue
+		}
+		err := h.importBookmark(r.Context(), n)
+		switch {
+		case err == nil:
+			summary.Imported++
+		case errors.Is(err, db.ErrDuplicate):
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+		return walkSkipChildren
+	})
+
+	h.renderJson(w, summary, http.StatusOK)
+}
+
+// importBookmark saves one anchor of a bookmark file as a link. Returns
+// db.ErrDuplicate untouched, so that ImportBookmarks can tell an entry which
+// is already saved from one which failed.
+func (h *Handlers) importBookmark(ctx context.Context, n *html.Node) error {
+	href := extractAttribute(n, "href")
+	parsedURL, err := url.Parse(href)
+	// Nothing is fetched here, so like the batch dry run the import checks
+	// the scheme itself: bookmark files also hold javascript: and place:
+	// entries, which can never become links.
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || h.validateURL(parsedURL) != nil {
+		return fmt.Errorf("invalid URL, must be a valid HTTP/HTTPS URL: %s", href)
+	}
+
+	title := strings.TrimSpace(extractTextContent(n))
+	if title == "" {
+		// The title column is required, and a bare URL beats an empty row
+		title = href
+	}
+
+	id, err := h.database.AddLink(ctx, href, title, "", "import", nil)
+	if err != nil {
+		if !errors.Is(err, db.ErrDuplicate) {
+			log.Printf("Failed to import %s: %v", href, err)
+		}
+		return err
+	}
+
+	if addDate, err := strconv.ParseInt(extractAttribute(n, "add_date"), 10, 64); err == nil && addDate > 0 {
+		// Losing the original date is not worth failing an imported link over
+		if err := h.database.SetAddedAt(ctx, id, time.Unix(addDate, 0)); err != nil {
+			log.Printf("Failed to set added time of imported link %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// csvHeader is the column layout of the CSV export, and the header row the
+// import recognizes and skips.
+var csvHeader = []string{"url", "title", "description", "added_at", "tags"}
+
+// ExportCSV serves every link as a CSV file for spreadsheets: one row per
+// link with the added time in RFC3339 and the tags joined with commas inside
+// their field. The csv package handles the quoting of fields holding commas
+// or newlines, and its buffering flushes to the client as rows accumulate,
+// so a large collection streams instead of building up in memory.
+func (h *Handlers) ExportCSV(w http.ResponseWriter, r *http.Request) {
+	links, err := h.database.GetAllLinks(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="linksaver.csv"`)
+	writer := csv.NewWriter(w)
+	_ = writer.Write(csvHeader)
+	for _, link := range links {
+		// Write errors surface once below; the header already went out, so
+		// there is no status left to change anyway.
+		_ = writer.Write([]string{
+			link.URL,
+			link.Title,
+			link.Description,
+			link.AddedAt.Format(time.RFC3339),
+			strings.Join(link.Tags, ","),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Failed to write CSV export: %v", err)
+	}
+}
+
+// ImportCSV handles the upload of a CSV file in the format ExportCSV writes.
+// The header row is skipped when present, and rows may drop trailing columns
+// down to the URL or carry extra ones, which are ignored. Like the bookmark
+// import nothing is fetched, rows already saved are skipped, and the
+// response is the same JSON summary.
+func (h *Handlers) ImportCSV(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("csv")
+	if err != nil {
+		sendError(w, fmt.Sprintf("Missing csv file: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := csv.NewReader(file)
+	// Rows with missing trailing columns or stray extra ones import anyway,
+	// only the URL is indispensable.
+	reader.FieldsPerRecord = -1
+
+	var summary ImportSummary
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			sendError(w, fmt.Sprintf("Failed to parse CSV: %v", err), http.StatusBadRequest)
+			return
+		}
+		if first && len(record) > 0 && record[0] == "url" {
+			// The header row the export writes
+			continue
+		}
+		err = h.importCSVRow(r.Context(), record)
+		switch {
+		case err == nil:
+			summary.Imported++
+		case errors.Is(err, db.ErrDuplicate):
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+
+	h.renderJson(w, summary, http.StatusOK)
+}
+
+// importCSVRow saves one CSV row as a link: url, title, description,
+// added_at and tags, of which only the URL has to be there. Returns
+// db.ErrDuplicate untouched, so that ImportCSV can tell an entry which is
+// already saved from one which failed.
+func (h *Handlers) importCSVRow(ctx context.Context, record []string) error {
+	field := func(i int) string {
+		if i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rawURL := field(0)
+	parsedURL, err := url.Parse(rawURL)
+	// Nothing is fetched here, so like the bookmark import the scheme is
+	// checked directly.
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || h.validateURL(parsedURL) != nil {
+		return fmt.Errorf("invalid URL, must be a valid HTTP/HTTPS URL: %s", rawURL)
+	}
+
+	title := field(1)
+	if title == "" {
+		// The title column is required, and a bare URL beats an empty row
+		title = rawURL
+	}
+
+	id, err := h.database.AddLink(ctx, rawURL, title, field(2), "import", nil)
+	if err != nil {
+		if !errors.Is(err, db.ErrDuplicate) {
+			log.Printf("Failed to import %s: %v", rawURL, err)
+		}
+		return err
+	}
+
+	if addedAt, err := time.Parse(time.RFC3339, field(3)); err == nil {
+		// Losing the original date is not worth failing an imported link over
+		if err := h.database.SetAddedAt(ctx, id, addedAt); err != nil {
+			log.Printf("Failed to set added time of imported link %d: %v", id, err)
+		}
+	}
+	for _, tag := range strings.Split(field(4), ",") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		if err := h.database.AddTag(ctx, id, tag); err != nil {
+			log.Printf("Failed to tag imported link %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// checkMinInterval is the minimum time between two URL checks. A crude rate
+// limit: the endpoint fetches arbitrary URLs, so it must not be usable to
+// probe hosts in bulk.
+const checkMinInterval = time.Second
+
+// checkResult is the response of CheckURL.
+type checkResult struct {
+	Reachable   bool   `json:"reachable"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	IsHTML      bool   `json:"isHTML"`
+}
+
+// CheckURL handles the request to check whether a URL is reachable and HTML,
+// without saving anything. Lighter than an add: a HEAD request, retried as
+// GET for servers that reject HEAD, and no metadata extraction or screenshot.
+func (h *Handlers) CheckURL(w http.ResponseWriter, r *http.Request) {
+	parsedURL, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || h.validateURL(parsedURL) != nil {
+		sendError(w, "Invalid URL. Must be a valid HTTP/HTTPS URL", http.StatusBadRequest)
+		return
+	}
+
+	h.checkMu.Lock()
+	tooSoon := time.Since(h.lastCheck) < checkMinInterval
+	if !tooSoon {
+		h.lastCheck = time.Now()
+	}
+	h.checkMu.Unlock()
+	if tooSoon {
+		sendError(w, "Too many checks, slow down", http.StatusTooManyRequests)
+		return
+	}
+
+	h.renderJson(w, h.checkURL(r.Context(), parsedURL), http.StatusOK)
+}
+
+// checkURL performs the request for CheckURL. An unreachable URL is not an
+// error, just a result with Reachable false.
+func (h *Handlers) checkURL(ctx context.Context, u *url.URL) checkResult {
+	resp, err := h.checkRequest(ctx, "HEAD", u)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		// Some servers reject HEAD, give GET a chance
+		_ = resp.Body.Close()
+		resp, err = h.checkRequest(ctx, "GET", u)
+	}
+	if err != nil {
+		return checkResult{}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType := mediaTypeOf(contentType)
+	return checkResult{
+		Reachable:   resp.StatusCode < 400,
+		Status:      resp.StatusCode,
+		ContentType: contentType,
+		IsHTML:      mediaType == "text/html" || mediaType == "application/xhtml+xml",
+	}
+}
+
+// checkRequest sends one request for checkURL; the body is left to the
+// caller to close.
+func (h *Handlers) checkRequest(ctx context.Context, method string, u *url.URL) (*http.Response, error) {
+	if err := h.throttleHost(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", h.userAgent())
+	req.Header.Set("Accept", h.FetchAccept)
+	return h.fetchClient().Do(req)
+}
+
+// recordFetchError saves a failed fetch for later review on the admin errors
+// page. Best effort: the add has already failed, recording why must not turn
+// into a second error for the user.
+func (h *Handlers) recordFetchError(ctx context.Context, url string, fetchErr error) {
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	if err := h.database.AddFetchError(writeCtx, url, fetchErr.Error()); err != nil {
+		log.Printf("Failed to record fetch error for %s: %v", url, err)
+	}
+}
+
+// FetchErrors lists the recently recorded fetch failures, to diagnose which
+// sites block the fetcher.
+func (h *Handlers) FetchErrors(w http.ResponseWriter, r *http.Request) {
+	fetchErrors, err := h.database.GetFetchErrors(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get fetch errors: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	if wantJson(r) {
+		if fetchErrors == nil {
+			fetchErrors = []db.FetchError{}
+		}
+		h.renderJson(w, fetchErrors, http.StatusOK)
+	} else {
+		h.render(w, "admin-errors.html", struct {
+			FetchErrors []db.FetchError
+		}{FetchErrors: fetchErrors}, http.StatusOK)
+	}
+}
+
+// Backup streams a consistent snapshot of the database as a download. The
+// snapshot is taken with VACUUM INTO, so the server keeps serving while it
+// runs, and written to a temporary file which is removed after streaming.
+func (h *Handlers) Backup(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "mylinks-backup")
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to create temporary directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	path := filepath.Join(dir, "mylinks.sqlite")
+	if err := h.database.BackupTo(r.Context(), path); err != nil {
+		sendError(w, fmt.Sprintf("Failed to back up database: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to open backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to stat backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="mylinks.sqlite"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	_, _ = io.Copy(w, file)
+}
+
+// restoreConfirmation is what the confirm field of a restore request must
+// say, so the endpoint cannot be hit by accident.
+const restoreConfirmation = "replace all data"
+
+// Restore stages an uploaded backup to replace the database at the next
+// restart. The live file cannot be swapped while the connection pool holds
+// it open, so the upload is validated and written next to the database;
+// InitDB applies it when the server starts again.
+func (h *Handlers) Restore(w http.ResponseWriter, r *http.Request) {
+	if h.DatabaseFile == "" {
+		sendError(w, "Restoring is not available", http.StatusNotFound)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("confirm") != restoreConfirmation {
+		sendError(w, fmt.Sprintf("Restoring replaces all data; confirm with confirm=%q", restoreConfirmation), http.StatusBadRequest)
+		return
+	}
+	backup, _, err := r.FormFile("backup")
+	if err != nil {
+		sendError(w, fmt.Sprintf("Missing backup file: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = backup.Close()
+	}()
+
+	if err := db.StageRestore(h.DatabaseFile, backup); err != nil {
+		sendError(w, fmt.Sprintf("Not a usable backup: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Restore staged, it replaces the database at the next restart")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = fmt.Fprintln(w, "Restore staged, restart the server to apply it")
+}
+
+// AddURL validates, fetches and saves a single URL, for callers outside the
+// HTTP handlers such as the add subcommand. The source records which add path
+// the link came in through. Returns the id of the new link.
+func (h *Handlers) AddURL(ctx context.Context, urlString string, source string) (int64, error) {
+	parsedURL, err := url.Parse(urlString)
+	if err != nil || h.validateURL(parsedURL) != nil {
+		return 0, fmt.Errorf("invalid URL, must be a valid HTTP/HTTPS URL: %s", urlString)
+	}
+
+	id, errMsg, _ := h.saveLink(ctx, parsedURL, source)
+	if errMsg != "" {
+		return 0, errors.New(errMsg)
+	}
+	return id, nil
+}
+
+// refetchBody returns a body to store for a link which has none, or nil to keep
+// what is stored. Links added before bodies were stored separately from the
+// contentless FTS index have no body to carry through an edit, so the page is
+// fetched again to keep it searchable.
+//
+// This is best effort: the user asked to edit the link, not to fetch it, so a
+// failure here is logged and the edit proceeds without a body.
+func (h *Handlers) refetchBody(ctx context.Context, id int64, description *string) []byte {
+	link, err := h.database.GetLink(ctx, id)
+	if err != nil {
+		// A missing link is reported by the update itself.
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Unable to look up link %d to refetch its body: %v", id, err)
+		}
+		return nil
+	}
+
+	if isNote(link.URL) {
+		// A note has no page to fetch, its text is its body. It is rewritten on
+		// every edit, unlike a page body: an edited note must not keep matching
+		// the text it no longer holds. make gives a non-nil slice for an empty
+		// note, which stores it as an empty body rather than keeping the old
+		// one. An edit not touching the description keeps the stored text.
+		text := link.Description
+		if description != nil {
+			text = *description
+		}
+		body := make([]byte, len(text))
+		copy(body, text)
+		return body
+	}
+
+	hasBody, err := h.database.HasBody(ctx, id)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Unable to check whether link %d has a body: %v", id, err)
+		}
+		return nil
+	}
+	if hasBody {
+		return nil
+	}
+
+	parsedURL, err := url.Parse(link.URL)
+	if err != nil || h.validateURL(parsedURL) != nil {
+		log.Printf("Not refetching the body of link %d, %s is no longer a valid URL", id, link.URL)
+		return nil
+	}
+
+	var body []byte
+	if h.browserContext != nil {
+		// The screenshot is a by-product here, the one taken when the link was
+		// added is left alone.
+		_, _, body, _, err = h.extractTitleAndDescriptionAndBodyAndScreenshotFromURL(parsedURL)
+	} else {
+		_, _, body, _, err = h.extractTitleAndDescriptionAndBodyFromURL(ctx, parsedURL)
+	}
+	if err != nil {
+		log.Printf("Unable to refetch %s to index its body: %v", link.URL, err)
+		return nil
+	}
+
+	return h.limitIndexBody(link.URL, body)
+}
+
+// ReindexBody handles the request to re-fetch one link's page and re-index
+// just its body, leaving the title and description alone. For links saved
+// before bodies were indexed.
+func (h *Handlers) ReindexBody(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.reindexBody(r.Context(), id); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to reindex body: %v", err), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReindexBodyResult is the response of a batch body reindex.
+type ReindexBodyResult struct {
+	Reindexed int `json:"reindexed"`
+	Failed    int `json:"failed"`
+}
+
+// ReindexBodies handles the request to re-fetch and re-index the body of
+// every link which has none stored. One link failing does not fail the
+// others; the response reports how many succeeded and failed.
+func (h *Handlers) ReindexBodies(w http.ResponseWriter, r *http.Request) {
+	links, err := h.database.GetAllLinks(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	var result ReindexBodyResult
+	for _, link := range links {
+		if isNote(link.URL) {
+			continue
+		}
+		hasBody, err := h.database.HasBody(r.Context(), link.ID)
+		if err != nil || hasBody {
+			continue
+		}
+		if err := h.reindexBody(r.Context(), link.ID); err != nil {
+			log.Printf("Failed to reindex the body of link %d: %v", link.ID, err)
+			result.Failed++
+			continue
+		}
+		result.Reindexed++
+	}
+
+	h.renderJson(w, result, http.StatusOK)
+}
+
+// reindexBody re-fetches a link's page and stores just the body, through
+// DB.UpdateLinkBody. A note's body is its own text, no fetch needed.
+func (h *Handlers) reindexBody(ctx context.Context, id int64) error {
+	link, err := h.database.GetLink(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if isNote(link.URL) {
+		body = []byte(link.Description)
+	} else {
+		parsedURL, err := url.Parse(link.URL)
+		if err != nil || h.validateURL(parsedURL) != nil {
+			return fmt.Errorf("%s is no longer a valid URL", link.URL)
+		}
+		if h.browserContext != nil {
+			// The screenshot is a by-product here, the stored one is left alone
+			_, _, body, _, err = h.extractTitleAndDescriptionAndBodyAndScreenshotFromURL(parsedURL)
+		} else {
+			_, _, body, _, err = h.extractTitleAndDescriptionAndBodyFromURL(ctx, parsedURL)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", link.URL, err)
+		}
+		body = h.limitIndexBody(link.URL, body)
+	}
+
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	return h.database.UpdateLinkBody(writeCtx, id, body)
+}
+
+// recaptureResult is the response to re-capturing a screenshot.
+type recaptureResult struct {
+	Filename string `json:"filename"`
+}
+
+// RecaptureScreenshot handles the request to re-capture the screenshot of an
+// existing link, when the page has changed or the original capture failed.
+// The new image overwrites the stored one under the same file name, so
+// nothing referencing it needs to change. Returns 503 when screenshots are
+// disabled or have been turned off after a storage failure.
+func (h *Handlers) RecaptureScreenshot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if h.browserContext == nil || h.screenshotsUnavailable.Load() {
+		sendError(w, "Screenshots are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	link, err := h.database.GetLink(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if isNote(link.URL) {
+		sendError(w, "A note has no screenshot", http.StatusBadRequest)
+		return
+	}
+
+	parsedURL, err := url.Parse(link.URL)
+	if err != nil || h.validateURL(parsedURL) != nil {
+		sendError(w, fmt.Sprintf("%s is no longer a valid URL", link.URL), http.StatusBadRequest)
+		return
+	}
+
+	screenshot, err := h.captureScreenshot(parsedURL)
+	if err == nil && screenshot == nil {
+		err = fmt.Errorf("the page yielded no image")
+	}
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to capture %s: %v", link.URL, err), http.StatusBadGateway)
+		return
+	}
+	if h.OptimizeScreenshots {
+		screenshot = optimizeScreenshot(screenshot)
+	}
+	if err := h.saveScreenshot(id, link.URL, screenshot); err != nil {
+		log.Printf("Disabling screenshots, failed to save %s: %v", link.URL, err)
+		h.screenshotsUnavailable.Store(true)
+		sendError(w, fmt.Sprintf("Failed to save screenshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// A fresh capture also settles any pending retry for the link
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	if err := h.database.ClearScreenshotFailure(writeCtx, id); err != nil {
+		log.Printf("Failed to clear the screenshot retry of link %d: %v", id, err)
+	}
+
+	h.renderJson(w, recaptureResult{Filename: h.screenshotFile(id, link.URL)}, http.StatusOK)
+}
+
+// Favicon serves the stored favicon of a link. Returns 404 when the link
+// does not exist or no favicon could be fetched for it; the list template
+// hides the icon in that case.
+func (h *Handlers) Favicon(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	favicon, contentType, err := h.database.GetFavicon(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get favicon: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(favicon)
+}
+
+// Content renders the archived text of a link's page: the body stored when
+// the link was added or last re-fetched, so the page stays readable even
+// after the original site goes away. Bodies stored with ExtractBodyText off
+// are raw HTML, so every body goes through the same readable-text extraction
+// the indexing uses; script and style content never reach the page, and an
+// already extracted body passes through unchanged. Returns 404 when the link
+// does not exist or no body was stored for it.
+func (h *Handlers) Content(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.database.GetLink(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	body, capturedAt, err := h.database.GetBody(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get content: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if len(body) > maxBodyLength {
+		body = body[:maxBodyLength]
+	}
+	if doc, err := html.Parse(bytes.NewReader(body)); err == nil {
+		body = extractReadableText(doc)
+	}
+
+	data := struct {
+		db.Link
+		CapturedAt time.Time
+		Text       string
+	}{
+		Link:       link,
+		CapturedAt: capturedAt,
+		Text:       string(body),
+	}
+	h.render(w, "content.html", data, http.StatusOK)
+}
+
+// reprocessFields are the link fields /admin/reprocess can fill in, each with
+// how to detect that a link is missing it.
+var reprocessFields = map[string]func(link db.Link) bool{
+	"published_at": func(link db.Link) bool { return link.PublishedAt == nil },
+	"description":  func(link db.Link) bool { return link.Description == "" },
+}
+
+// ReprocessResult is the response of a reprocess run.
+type ReprocessResult struct {
+	Reprocessed int `json:"reprocessed"`
+	Failed      int `json:"failed"`
+}
+
+// Reprocess handles the request to re-fetch links and fill in fields which
+// were not extracted when they were saved, for rows predating a column. Only
+// the fields named in the fields query parameter are touched, and only on
+// links missing them; a page which does not declare a field leaves it empty.
+// Fetches run with the batch add's concurrency limit, and one link failing
+// does not fail the others.
+func (h *Handlers) Reprocess(w http.ResponseWriter, r *http.Request) {
+	fieldsParam := r.URL.Query().Get("fields")
+	if fieldsParam == "" {
+		sendError(w, "No fields given to reprocess", http.StatusBadRequest)
+		return
+	}
+	fields := strings.Split(fieldsParam, ",")
+	for _, field := range fields {
+		if _, ok := reprocessFields[field]; !ok {
+			sendError(w, fmt.Sprintf("Unknown field: %s", field), http.StatusBadRequest)
+			return
+		}
+	}
+
+	links, err := h.database.GetAllLinks(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	var candidates []db.Link
+	for _, link := range links {
+		if isNote(link.URL) {
+			continue
+		}
+		for _, field := range fields {
+			if reprocessFields[field](link) {
+				candidates = append(candidates, link)
+				break
+			}
+		}
+	}
+
+	var result ReprocessResult
+	var mu sync.Mutex
+	semaphore := make(chan struct{}, h.batchConcurrency())
+	var wg sync.WaitGroup
+	for _, link := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			err := h.reprocessLink(r.Context(), link, fields)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Failed to reprocess link %d: %v", link.ID, err)
+				result.Failed++
+			} else {
+				result.Reprocessed++
+			}
+		}()
+	}
+	wg.Wait()
+
+	h.renderJson(w, result, http.StatusOK)
+}
+
+// reprocessLink re-fetches one link's page and fills in those of the given
+// fields the link is missing.
+func (h *Handlers) reprocessLink(ctx context.Context, link db.Link, fields []string) error {
+	parsedURL, err := url.Parse(link.URL)
+	if err != nil || h.validateURL(parsedURL) != nil {
+		return fmt.Errorf("%s is no longer a valid URL", link.URL)
+	}
+	_, description, _, meta, err := h.extractTitleAndDescriptionAndBodyFromURL(ctx, parsedURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", link.URL, err)
+	}
+
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	for _, field := range fields {
+		if !reprocessFields[field](link) {
+			continue
+		}
+		switch field {
+		case "published_at":
+			if publishedAt := parsePublished(meta[publishedMetaKey]); publishedAt != nil {
+				if err := h.database.SetPublishedAt(writeCtx, link.ID, publishedAt); err != nil {
+					return err
+				}
+			}
+		case "description":
+			if description != "" {
+				if err := h.database.SetDescription(writeCtx, link.ID, description); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// limitIndexBody truncates a page body to MaxIndexBodyBytes before it is
+// stored and indexed, logging when it does.
+func (h *Handlers) limitIndexBody(urlString string, body []byte) []byte {
+	if h.MaxIndexBodyBytes <= 0 || len(body) <= h.MaxIndexBodyBytes {
+		return body
+	}
+	log.Printf("Truncating the body of %s from %d to %d bytes for indexing", urlString, len(body), h.MaxIndexBodyBytes)
+	return body[:h.MaxIndexBodyBytes]
+}
+
+// expiryFormats are the timestamp formats accepted in the expires_at form
+// field, the format of a datetime-local input first.
+var expiryFormats = []string{"2006-01-02T15:04", "2006-01-02", time.RFC3339}
+
+// parseExpiry parses an expires_at form field, empty meaning no expiry.
+func parseExpiry(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, format := range expiryFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid expiry time: %s", value)
+}
+
+// parseMetaField parses the optional meta form field, a JSON object of string
+// keys and values to store as link metadata. A key with an empty value
+// removes that key. The "tags" key is normalized and validated through
+// normalizeTags.
+func (h *Handlers) parseMetaField(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var meta map[string]string
+	if err := json.Unmarshal([]byte(value), &meta); err != nil {
+		return nil, fmt.Errorf("invalid meta, must be a JSON object of strings: %v", err)
+	}
+	if tags, ok := meta[tagsMetaKey]; ok && tags != "" {
+		normalized, err := h.normalizeTags(tags)
+		if err != nil {
+			return nil, err
+		}
+		meta[tagsMetaKey] = normalized
+	}
+	return meta, nil
+}
+
+// parseTagsField parses the optional tags form field, a comma-separated tag
+// list, into the individual tags to store. Normalization and validation
+// match the tags metadata key.
+func (h *Handlers) parseTagsField(value string) ([]string, error) {
+	normalized, err := h.normalizeTags(value)
+	if err != nil {
+		return nil, err
+	}
+	if normalized == "" {
+		return nil, nil
+	}
+	return strings.Split(normalized, ", "), nil
+}
+
+// tagsMetaKey is the metadata key holding a link's tags, as a
+// comma-separated list.
+const tagsMetaKey = "tags"
+
+// originalURLMetaKey is the metadata key holding the URL as it was submitted,
+// stored when StoreOriginalURL is set and canonicalization changed it.
+const originalURLMetaKey = "original_url"
+
+// maxTagsPerLink and maxTagLength are the default limits on the tags
+// metadata, see MaxTagsPerLink and MaxTagLength.
+const maxTagsPerLink = 20
+const maxTagLength = 64
+
+// normalizeTags normalizes a comma-separated tag list — each tag trimmed,
+// lowercased and its inner whitespace collapsed, empty tags dropped — and
+// rejects one with too many or too long tags.
+func (h *Handlers) normalizeTags(value string) (string, error) {
+	maxTags := h.MaxTagsPerLink
+	if maxTags <= 0 {
+		maxTags = maxTagsPerLink
+	}
+	maxLength := h.MaxTagLength
+	if maxLength <= 0 {
+		maxLength = maxTagLength
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" {
+			continue
+		}
+		if len(tag) > maxLength {
+			return "", fmt.Errorf("tag longer than %d characters: %s", maxLength, tag)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return "", fmt.Errorf("more than %d tags", maxTags)
+	}
+	return strings.Join(tags, ", "), nil
+}
+
+// addLink handles the request to add a new link.
+func (h *Handlers) addLink(w http.ResponseWriter, r *http.Request, urlToSave *url.URL) {
+	expiresAt, err := parseExpiry(r.PostForm.Get("expires_at"))
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := h.parseMetaField(r.PostForm.Get("meta"))
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tags, err := h.parseTagsField(r.PostForm.Get("tags"))
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, errMsg, status := h.saveLink(r.Context(), urlToSave, "web")
+	if errMsg != "" {
+		if status == http.StatusConflict && id != 0 {
+			// The existing link's location, so the front end can jump to
+			// it rather than show the error.
+			w.Header().Set("Location", fmt.Sprintf("/%v", id))
+		}
+		sendError(w, errMsg, status)
+		return
+	}
+
+	if expiresAt != nil || len(meta) > 0 || len(tags) > 0 {
+		writeCtx, cancel := writeContext(r.Context())
+		defer cancel()
+		if expiresAt != nil {
+			if err := h.database.SetExpiry(writeCtx, id, expiresAt); err != nil {
+				sendError(w, fmt.Sprintf("Failed to set expiry: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		// Set after the save, so that explicit metadata wins over what the
+		// extraction filled in.
+		for key, value := range meta {
+			if err := h.database.SetMeta(writeCtx, id, key, value); err != nil {
+				sendError(w, fmt.Sprintf("Failed to set metadata: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		for _, tag := range tags {
+			if err := h.database.AddTag(writeCtx, id, tag); err != nil {
+				sendError(w, fmt.Sprintf("Failed to tag link: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/%v", id))
+	if h.oobRequested(r) {
+		h.renderOOBAdd(w, r, id)
+		return
+	}
+	h.listLinks(w, r, http.StatusCreated)
+}
+
+// oobRequested reports whether a response should be out-of-band fragments
+// instead of the re-rendered list: OOBUpdates is on and the request is a
+// true HTMX partial.
+func (h *Handlers) oobRequested(r *http.Request) bool {
+	return h.OOBUpdates && r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-Boosted") != "true"
+}
+
+// renderOOBAdd responds to an HTMX add with out-of-band fragments which
+// prepend the new row and update the link count, leaving the rest of the
+// list alone.
+func (h *Handlers) renderOOBAdd(w http.ResponseWriter, r *http.Request, id int64) {
+	link, err := h.database.GetLink(r.Context(), id)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get link: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+	total, err := h.database.CountLinks(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to count links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Link            LinkView
+		ShowScreenshots bool
+		Total           int
+	}{LinkView{Link: link}, h.browserContext != nil, total}
+	h.render(w, "oob-add", data, http.StatusCreated)
+}
+
+// addNote handles the request to add a new free-form note.
+func (h *Handlers) addNote(w http.ResponseWriter, r *http.Request, title string, note string) {
+	description := note
+
+	// Generate a pseudo URL to satisfy the NOT NULL UNIQUE constraint and keep entries distinguishable.
+	urlToSave := fmt.Sprintf("note:%d", time.Now().UnixMilli())
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	id, err := h.database.AddLink(writeCtx, urlToSave, title, description, "web", []byte(note))
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to add note: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/%v", id))
+	if h.oobRequested(r) {
+		h.renderOOBAdd(w, r, id)
+		return
+	}
+	h.listLinks(w, r, http.StatusCreated)
+}
+
+// BookmarkletSave handles GET /bookmarklet?url=... to save a link from the bookmarklet popup.
+func (h *Handlers) BookmarkletSave(w http.ResponseWriter, r *http.Request) {
+	urlString := r.URL.Query().Get("url")
+	if urlString == "" {
+		h.render(w, "bookmarklet-result.html", struct {
+			Success bool
+			URL     string
+			Error   string
+		}{Success: false, Error: "No URL provided"}, http.StatusBadRequest)
+		return
+	}
+
+	parsedURL, err := url.Parse(urlString)
+	if err != nil || h.validateURL(parsedURL) != nil {
+		h.render(w, "bookmarklet-result.html", struct {
+			Success bool
+			URL     string
+			Error   string
+		}{Success: false, Error: "Invalid URL format. Must be a valid HTTP/HTTPS URL"}, http.StatusBadRequest)
+		return
+	}
+
+	_, errMsg, status := h.saveLink(r.Context(), parsedURL, "bookmarklet")
+	if errMsg != "" {
+		h.render(w, "bookmarklet-result.html", struct {
+			Success bool
+			URL     string
+			Error   string
+		}{Success: false, Error: errMsg}, status)
+		return
+	}
+
+	h.render(w, "bookmarklet-result.html", struct {
+		Success bool
+		URL     string
+		Error   string
+	}{Success: true, URL: urlString}, http.StatusCreated)
+}
+
+// validateURL rejects URLs which are not safe to fetch. The hostname must
+// not resolve to a loopback, private, link-local or unspecified address — a
+// hostname pointing into the internal network is an SSRF hole however
+// innocently it is spelled — and the plain fetch path re-validates the
+// resolved address at dial time, against DNS rebinding between here and the
+// fetch. The chromedp path has only this check, the browser resolves on its
+// own.
+func (h *Handlers) validateURL(u *url.URL) error {
+	if h.RejectIDNHosts && isIDNHost(u.Hostname()) {
+		return fmt.Errorf("internationalized host %s is not allowed", u.Hostname())
+	}
+
+	if h.lookupIP != nil {
+		// The test seam for the resolved-IP check; outside tests
+		// ValidateExternalURL below resolves by itself.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ips, err := h.lookupIP(ctx, u.Hostname())
+		if err != nil {
+			return fmt.Errorf("could not resolve URL host")
+		}
+		for _, ip := range ips {
+			if httputil.IsBlockedIP(ip) {
+				return fmt.Errorf("URL must not point to a private or local address")
+			}
+		}
+	}
+
+	if h.forTesting {
+		return nil
+	}
+
+	return httputil.ValidateExternalURL(u.String())
+}
+
+// isIDNHost reports whether a host is internationalized: a label in punycode
+// form, or one still containing non-ASCII characters.
+func isIDNHost(host string) bool {
+	for _, label := range strings.Split(host, ".") {
+		if strings.HasPrefix(label, "xn--") {
+			return true
+		}
+	}
+	for _, r := range host {
+		if r >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// extractTitleAndDescriptionAndBodyFromURL fetches the URL and extracts the
+// page title from HTML, along with any auto-extracted metadata.
+func (h *Handlers) extractTitleAndDescriptionAndBodyFromURL(ctx context.Context, url *url.URL) (string, string, []byte, map[string]string, error) {
+	return h.fetchAndExtract(ctx, url, h.FollowMetaRefresh)
+}
+
+// throttleHost waits until a fetch to the host is allowed, spacing requests
+// to the same host by FetchHostInterval. Each caller reserves the next free
+// slot up front, so concurrent fetches queue behind each other instead of
+// racing for the same one. Returns the context's error when it is cancelled
+// while waiting.
+func (h *Handlers) throttleHost(ctx context.Context, host string) error {
+	if h.FetchHostInterval <= 0 {
+		return nil
+	}
+	h.throttleMu.Lock()
+	if h.nextFetch == nil {
+		h.nextFetch = make(map[string]time.Time)
+	}
+	next := h.nextFetch[host]
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+	h.nextFetch[host] = next.Add(h.FetchHostInterval)
+	h.throttleMu.Unlock()
+
+	wait := time.Until(next)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// urlOnCooldown reports whether the URL was already fetched for an add within
+// FetchURLCooldown, recording this attempt when it was not. Entries past the
+// window are pruned in passing, so the map only ever holds recent URLs.
+func (h *Handlers) urlOnCooldown(u *url.URL) bool {
+	if h.FetchURLCooldown <= 0 {
+		return false
+	}
+	h.cooldownMu.Lock()
+	defer h.cooldownMu.Unlock()
+	for fetched, at := range h.lastURLFetch {
+		if time.Since(at) >= h.FetchURLCooldown {
+			delete(h.lastURLFetch, fetched)
+		}
+	}
+	if _, tooSoon := h.lastURLFetch[u.String()]; tooSoon {
+		return true
+	}
+	if h.lastURLFetch == nil {
+		h.lastURLFetch = make(map[string]time.Time)
+	}
+	h.lastURLFetch[u.String()] = time.Now()
+	return false
+}
+
+// fetchAndExtract does the work of extractTitleAndDescriptionAndBodyFromURL.
+// mayFollowRefresh allows following one meta-refresh redirect; the recursive
+// call passes false, so that a chain of refreshes cannot loop.
+func (h *Handlers) fetchAndExtract(ctx context.Context, url *url.URL, mayFollowRefresh bool) (string, string, []byte, map[string]string, error) {
+	if err := h.throttleHost(ctx, url.Hostname()); err != nil {
+		return "", "", nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// AddItem browser-like headers to avoid being blocked by anti-bot measures.
+	// Setting Accept-Encoding ourselves disables the transport's transparent
+	// gzip handling, so readBody decompresses the response instead.
+	req.Header.Set("User-Agent", h.userAgent())
+	req.Header.Set("Accept", h.FetchAccept)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+
+	resp, err := h.fetchClient().Do(req)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	responseBody, err := h.readBody(resp)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType := mediaTypeOf(contentType)
+	if resp.StatusCode != http.StatusOK {
+		if h.SaveOnFetchError {
+			log.Printf("HTTP %d fetching %s, saving with title from the URL", resp.StatusCode, url)
+			return h.extractTitleFromURL(url), fmt.Sprintf("(fetch failed: HTTP %d)", resp.StatusCode), nil, nil, nil
+		}
+		return "", "", nil, nil, &httpStatusError{resp.StatusCode}
+	}
+
+	if !h.contentTypeAllowed(mediaType) {
+		return h.extractTitleFromURL(url), contentType, nil, h.headerMeta(nil, resp), nil
+	}
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		responseBody = decodeToUTF8(responseBody, contentType)
+		if mayFollowRefresh {
+			if target := metaRefreshURL(responseBody, url); target != nil && h.validateURL(target) == nil {
+				if h.BlockSchemeDowngrade && schemeDowngraded(url, target) {
+					log.Printf("Not following meta refresh from %s to plain http %s", url, target)
+				} else {
+					// The refreshed-to page's headers win over this response's
+					log.Printf("Following meta refresh from %s to %s", url, target)
+					return h.fetchAndExtract(ctx, target, false)
+				}
+			}
+		}
+		title, description, body, meta, err := h.extractTitleAndDescriptionAndBodyFromHtml(responseBody)
+		return title, description, body, h.headerMeta(meta, resp), err
+	case "application/pdf":
+		title, description, body, err := h.extractTitleAndDescriptionAndBodyFromPdf(url, responseBody)
+		return title, description, body, h.headerMeta(nil, resp), err
+	case "text/plain":
+		title, description, body, err := h.extractTitleAndDescriptionAndBodyFromText(url, responseBody)
+		return title, description, body, h.headerMeta(nil, resp), err
+	default:
+		return h.extractTitleFromURL(url), contentType, nil, h.headerMeta(nil, resp), nil
+	}
+}
+
+// headerMetaPrefix prefixes the metadata keys holding stored response
+// headers, so they cannot collide with page-extracted keys.
+const headerMetaPrefix = "header:"
+
+// storedHeaders are the response headers kept as link metadata when
+// StoreHeaders is set.
+var storedHeaders = []string{"Content-Type", "Server", "Last-Modified"}
+
+// headerMeta adds the stored response headers to a meta map when StoreHeaders
+// is set, creating the map when needed. A no-op otherwise.
+func (h *Handlers) headerMeta(meta map[string]string, resp *http.Response) map[string]string {
+	if !h.StoreHeaders {
+		return meta
+	}
+	for _, name := range storedHeaders {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[headerMetaPrefix+strings.ToLower(name)] = value
+	}
+	return meta
+}
+
+// maxDrainBytes is the default cap on how much of a response beyond
+// maxBodyLength is read and discarded, see MaxDrainBytes.
+const maxDrainBytes = 256 * 1024
+
+// drainBytes is how much of the remaining response body readBody discards:
+// MaxDrainBytes, with maxDrainBytes as the default and negative meaning
+// none at all.
+func (h *Handlers) drainBytes() int64 {
+	switch {
+	case h.MaxDrainBytes < 0:
+		return 0
+	case h.MaxDrainBytes == 0:
+		return maxDrainBytes
+	default:
+		return h.MaxDrainBytes
+	}
+}
+
+// readBody reads a response body of at most maxBodyLength bytes, undoing any
+// content encoding the server applied. The limit is applied to the compressed
+// and the decompressed form alike, so a compressed response cannot expand
+// without bound.
+func (h *Handlers) readBody(resp *http.Response) ([]byte, error) {
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyLength))
+	// Draining the remainder lets the transport reuse the connection for the
+	// next request to the same host, but every drained byte is downloaded
+	// just to be thrown away. Past the cap the rest is abandoned: closing a
+	// partially read body gives up the connection, which is the cheaper
+	// trade for a response far over the limit.
+	if drain := h.drainBytes(); drain > 0 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, drain))
+	}
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return raw, nil
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip response: %w", err)
+		}
+	case "deflate":
+		// Servers disagree on whether deflate means a zlib stream, as the
+		// HTTP specification has it, or a raw deflate stream; accept both.
+		if zlibReader, zlibErr := zlib.NewReader(bytes.NewReader(raw)); zlibErr == nil {
+			reader = zlibReader
+		} else {
+			reader = flate.NewReader(bytes.NewReader(raw))
+		}
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(raw))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxBodyLength))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s response: %w", resp.Header.Get("Content-Encoding"), err)
+	}
+	return body, nil
+}
+
+// httpStatusError reports a non-200 response to a fetch, keeping the status
+// so that callers can react to it.
+type httpStatusError struct {
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP error: %d", e.status)
+}
+
+// decodeToUTF8 converts an HTML page to UTF-8, from the charset its
+// Content-Type header declares or, failing that, a meta tag or byte
+// sniffing — html.Parse assumes UTF-8, so a Latin-1 page would otherwise
+// come out mojibake. The page is returned unchanged when its charset cannot
+// be determined, as before.
+func decodeToUTF8(body []byte, contentType string) []byte {
+	reader, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return body
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return body
+	}
+	return decoded
+}
+
+// mediaTypeOf strips any parameters, such as a charset, from a Content-Type
+// header value.
+func mediaTypeOf(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// contentTypeAllowed reports whether a response of the given media type is
+// processed for metadata.
+func (h *Handlers) contentTypeAllowed(mediaType string) bool {
+	for _, allowed := range h.ContentTypes {
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// schemeDowngraded reports whether following a redirect from from to target
+// would downgrade an https page to plain http.
+func schemeDowngraded(from, target *url.URL) bool {
+	return from.Scheme == "https" && target.Scheme == "http"
+}
+
+// metaRefreshURL returns the target of a <meta http-equiv="refresh"> element
+// in the page, resolved against the page's own URL, or nil if there is none.
+func metaRefreshURL(responseBody []byte, base *url.URL) *url.URL {
+	doc, err := html.Parse(bytes.NewReader(responseBody))
+	if err != nil {
+		return nil
+	}
+	content := findMetaRefresh(doc)
+	if content == "" {
+		return nil
+	}
+
+	// The content has the form "seconds; url=target"
+	_, after, found := strings.Cut(content, ";")
+	if !found {
+		return nil
+	}
+	after = strings.TrimSpace(after)
+	if len(after) < len("url=") || !strings.EqualFold(after[:len("url=")], "url=") {
+		return nil
+	}
+	target := strings.Trim(strings.TrimSpace(after[len("url="):]), `'"`)
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil
+	}
+	return base.ResolveReference(targetURL)
+}
+
+// findMetaRefresh recursively searches for a "meta" refresh element in the
+// HTML tree and returns its content attribute.
+func findMetaRefresh(n *html.Node) string {
+	if n.Type == html.ElementNode && n.Data == "meta" && strings.EqualFold(extractAttribute(n, "http-equiv"), "refresh") {
+		return extractAttribute(n, "content")
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if content := findMetaRefresh(c); content != "" {
+			return content
+		}
+	}
+
+	return ""
+}
+
+func (h *Handlers) extractTitleAndDescriptionAndBodyFromHtml(responseBody []byte) (string, string, []byte, map[string]string, error) {
+	doc, err := html.Parse(bytes.NewReader(responseBody))
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	title := strings.TrimSpace(extractTitleFromHtml(doc))
+	description := strings.TrimSpace(extractDescriptionFromHtml(doc))
+	// Sites which keep their real title and summary in OpenGraph tags often
+	// leave the plain elements empty, so fall back to those.
+	if title == "" {
+		title = strings.TrimSpace(extractMetaProperty(doc, "og:title"))
+	}
+	if description == "" {
+		description = strings.TrimSpace(extractMetaProperty(doc, "og:description"))
+	}
+	if (title == "" || description == "") && bytes.Contains(responseBody, []byte(xhtmlNamespace)) {
+		// Prefix-namespaced XHTML hides its elements from the HTML parser,
+		// which only matches unqualified names; an XML parse finds them.
+		xhtmlTitle, xhtmlDescription := extractTitleAndDescriptionFromXhtml(responseBody)
+		if title == "" {
+			title = xhtmlTitle
+		}
+		if description == "" {
+			description = xhtmlDescription
+		}
+	}
+	if title == "" {
+		return "", "", nil, nil, fmt.Errorf("no title found in HTML")
+	}
+
+	if len(title) > maxTitleLength {
+		title = title[:maxTitleLength] + "..."
+	}
+
+	if len(description) > maxDescriptionLength {
+		description = description[:maxDescriptionLength] + "..."
+	}
+
+	if h.ExtractBodyText {
+		responseBody = extractReadableText(doc)
+	} else {
+		bodyIndex := bytes.Index(responseBody, []byte("<body>"))
+		if bodyIndex > 0 {
+			responseBody = responseBody[bodyIndex:]
+		}
+	}
+
+	meta := extractAutoMetaFromHtml(doc)
+	if published := extractPublishedFromHtml(doc); published != "" {
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[publishedMetaKey] = published
+	}
+	if favicon := extractFaviconFromHtml(doc); favicon != "" {
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[faviconMetaKey] = favicon
+	}
+
+	return title, description, responseBody, meta, nil
+}
+
+// xhtmlNamespace identifies an XHTML document.
+const xhtmlNamespace = "http://www.w3.org/1999/xhtml"
+
+// extractTitleAndDescriptionFromXhtml parses an XHTML document with an XML
+// parser, matching elements by local name so that namespace prefixes don't
+// matter. Returns empty strings for whatever it cannot find.
+func extractTitleAndDescriptionFromXhtml(responseBody []byte) (string, string) {
+	decoder := xml.NewDecoder(bytes.NewReader(responseBody))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var title, description string
+	var inTitle bool
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				var name, content string
+				for _, attr := range t.Attr {
+					switch strings.ToLower(attr.Name.Local) {
+					case "name":
+						name = attr.Value
+					case "content":
+						content = attr.Value
+					}
+				}
+				if description == "" && strings.EqualFold(name, "description") {
+					description = content
+				}
+			}
+		case xml.EndElement:
+			if strings.ToLower(t.Name.Local) == "title" {
+				inTitle = false
+			}
+		case xml.CharData:
+			if inTitle {
+				title += string(t)
+			}
+		}
+	}
+	return strings.TrimSpace(title), strings.TrimSpace(description)
+}
+
+// boilerplateElements are elements whose text is chrome rather than content
+// and is skipped by extractReadableText.
+var boilerplateElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"nav":      true,
+	"header":   true,
+	"footer":   true,
+	"aside":    true,
+	"form":     true,
+	"iframe":   true,
+}
+
+// extractReadableText returns the readable text of a parsed HTML page: the
+// text of the article or main element when the page has one, otherwise the
+// whole document, with boilerplateElements skipped and whitespace collapsed.
+func extractReadableText(doc *html.Node) []byte {
+	root := doc
+	for _, name := range []string{"article", "main"} {
+		if n := findElement(doc, name); n != nil {
+			root = n
+			break
+		}
+	}
+	var text strings.Builder
+	collectReadableText(root, &text)
+	return []byte(strings.Join(strings.Fields(text.String()), " "))
+}
+
+// maxExtractDepth and maxExtractNodes bound the HTML extractors, so that an
+// adversarial page with absurd nesting or millions of elements cannot grow
+// the stack or burn pathological time during an add. Real pages stay far
+// below both; anything beyond the limits is silently ignored.
+const (
+	maxExtractDepth = 512
+	maxExtractNodes = 200_000
+)
+
+// walkAction tells walkNodes how to proceed after visiting a node.
+type walkAction int
+
+const (
+	// walkContinue descends into the node's children.
+	walkContinue walkAction = iota
+	// walkSkipChildren moves on without descending.
+	walkSkipChildren
+	// walkStop ends the walk.
+	walkStop
+)
+
+// walkNodes visits the tree under root in document order, calling visit for
+// each node, going at most maxExtractDepth levels deep and visiting at most
+// maxExtractNodes nodes. Iterative on an explicit stack, so document depth
+// never translates into goroutine stack growth.
+func walkNodes(root *html.Node, visit func(n *html.Node) walkAction) {
+	type frame struct {
+		node  *html.Node
+		depth int
+	}
+	stack := []frame{{root, 0}}
+	visited := 0
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		visited++
+		if visited > maxExtractNodes {
+			return
+		}
+		switch visit(f.node) {
+		case walkStop:
+			return
+		case walkSkipChildren:
+			continue
+		case walkContinue:
+		}
+		if f.depth >= maxExtractDepth {
+			continue
+		}
+		// Children pushed in reverse pop in document order.
+		for c := f.node.LastChild; c != nil; c = c.PrevSibling {
+			stack = append(stack, frame{c, f.depth + 1})
+		}
+	}
+}
+
+// findElement searches the tree for the first element with the given name.
+func findElement(n *html.Node, name string) *html.Node {
+	var found *html.Node
+	walkNodes(n, func(n *html.Node) walkAction {
+		if n.Type == html.ElementNode && n.Data == name {
+			found = n
+			return walkStop
+		}
+		return walkContinue
+	})
+	return found
+}
+
+// collectReadableText appends the text content of a node and its children,
+// skipping boilerplateElements, with a space between nodes so that adjacent
+// elements don't run their words together.
+func collectReadableText(root *html.Node, text *strings.Builder) {
+	walkNodes(root, func(n *html.Node) walkAction {
+		switch n.Type {
+		case html.TextNode:
+			text.WriteString(n.Data)
+			text.WriteString(" ")
+		case html.ElementNode:
+			if n != root && boilerplateElements[n.Data] {
+				return walkSkipChildren
+			}
+		}
+		return walkContinue
+	})
+}
+
+// extractTitleFromHtml searches the HTML tree for the first "title" element
+// with text content.
+func extractTitleFromHtml(n *html.Node) string {
+	var title string
+	walkNodes(n, func(n *html.Node) walkAction {
+		if n.Type == html.ElementNode && n.Data == "title" {
+			if title = extractTextContent(n); title != "" {
+				return walkStop
+			}
+			return walkSkipChildren
+		}
+		return walkContinue
+	})
+	return title
+}
+
+// extractTextContent extracts all text content from a node and its children.
+func extractTextContent(n *html.Node) string {
+	var text strings.Builder
+	walkNodes(n, func(n *html.Node) walkAction {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		return walkContinue
+	})
+	return text.String()
+}
+
+// extractDescriptionFromHtml recursively searches for the "meta" element in the HTML tree.
+func extractDescriptionFromHtml(n *html.Node) string {
+	return extractMetaContent(n, "description")
+}
+
+// autoMetaNames are the meta tags copied into a link's metadata automatically
+// during HTML extraction, so that fields like the author are kept without the
+// user typing them in.
+var autoMetaNames = []string{"author", "keywords"}
+
+// extractAutoMetaFromHtml collects the meta tags named in autoMetaNames into
+// a metadata map, nil when the page declares none of them.
+func extractAutoMetaFromHtml(n *html.Node) map[string]string {
+	var meta map[string]string
+	for _, name := range autoMetaNames {
+		value := strings.TrimSpace(extractMetaContent(n, name))
+		if value == "" {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[name] = value
+	}
+	return meta
+}
+
+// extractMetaContent searches the HTML tree for a "meta" element with the
+// given name and returns its content attribute.
+func extractMetaContent(n *html.Node, name string) string {
+	var content string
+	walkNodes(n, func(n *html.Node) walkAction {
+		if n.Type == html.ElementNode && n.Data == "meta" && extractAttribute(n, "name") == name {
+			if content = extractAttribute(n, "content"); content != "" {
+				return walkStop
+			}
+		}
+		return walkContinue
+	})
+	return content
+}
+
+// publishedMetaKey carries the page's published time through the extracted
+// metadata map, from where saveLink moves it into its own column.
+const publishedMetaKey = "published"
+
+// extractPublishedFromHtml returns the page's published time as it declares
+// it: the OpenGraph article:published_time property, a meta date tag, or the
+// datetime of the first time element. Empty when the page declares none.
+func extractPublishedFromHtml(n *html.Node) string {
+	if value := strings.TrimSpace(extractMetaProperty(n, "article:published_time")); value != "" {
+		return value
+	}
+	if value := strings.TrimSpace(extractMetaContent(n, "date")); value != "" {
+		return value
+	}
+	return strings.TrimSpace(extractTimeDatetime(n))
+}
+
+// faviconMetaKey carries the href of the page's declared icon through the
+// extracted metadata map, from where saveLink fetches and stores the icon.
+const faviconMetaKey = "favicon"
+
+// extractFaviconFromHtml returns the href of the page's declared icon: the
+// first link element whose rel names an icon. Empty when the page declares
+// none, in which case /favicon.ico is the place to look.
+func extractFaviconFromHtml(n *html.Node) string {
+	var href string
+	walkNodes(n, func(n *html.Node) walkAction {
+		if n.Type == html.ElementNode && n.Data == "link" {
+			// rel is a space-separated list, "shortcut icon" being common
+			for _, rel := range strings.Fields(strings.ToLower(extractAttribute(n, "rel"))) {
+				if rel == "icon" && extractAttribute(n, "href") != "" {
+					href = extractAttribute(n, "href")
+					return walkStop
+				}
+			}
+		}
+		return walkContinue
+	})
+	return href
+}
+
+// extractMetaProperty searches the HTML tree for a "meta" element with the
+// given property, the OpenGraph form of a meta tag, and returns its content
+// attribute.
+func extractMetaProperty(n *html.Node, property string) string {
+	var content string
+	walkNodes(n, func(n *html.Node) walkAction {
+		if n.Type == html.ElementNode && n.Data == "meta" && extractAttribute(n, "property") == property {
+			if content = extractAttribute(n, "content"); content != "" {
+				return walkStop
+			}
+		}
+		return walkContinue
+	})
+	return content
+}
+
+// extractTimeDatetime searches the HTML tree for a "time" element with a
+// datetime attribute and returns that attribute.
+func extractTimeDatetime(n *html.Node) string {
+	var datetime string
+	walkNodes(n, func(n *html.Node) walkAction {
+		if n.Type == html.ElementNode && n.Data == "time" {
+			if datetime = extractAttribute(n, "datetime"); datetime != "" {
+				return walkStop
+			}
+		}
+		return walkContinue
+	})
+	return datetime
+}
+
+// publishedFormats are the timestamp formats accepted in a page's published
+// time metadata.
+var publishedFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// parsePublished parses a published time as pages declare it, nil for a
+// value in no known format.
+func parsePublished(value string) *time.Time {
+	for _, format := range publishedFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func extractAttribute(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func (h *Handlers) extractTitleAndDescriptionAndBodyFromPdf(url *url.URL, responseBody []byte) (string, string, []byte, error) {
+	r, err := pdf.NewReader(bytes.NewReader(responseBody), int64(len(responseBody)))
+	if err != nil {
+		return h.extractTitleFromURL(url), "PDF", nil, nil
+	}
+
+	b, err := r.GetPlainText()
+	if err != nil {
+		return h.extractTitleFromURL(url), "PDF", nil, nil
+	}
+	pdfText, err := io.ReadAll(io.LimitReader(b, maxBodyLength))
+	_, _ = io.Copy(io.Discard, b)
+	if err != nil {
+		return h.extractTitleFromURL(url), "PDF", nil, nil
+	}
+
+	var title string
+	var description string
+	totalPage := r.NumPage()
+pages:
+	for pageIndex := 1; pageIndex <= totalPage; pageIndex++ {
+		p := r.Page(pageIndex)
+		if p.V.IsNull() || p.V.Key("Contents").Kind() == pdf.Null {
+			continue
+		}
+
+		rows, _ := p.GetTextByRow()
+		for _, row := range rows {
+			var sb strings.Builder
+			for _, word := range row.Content {
+				sb.WriteString(word.S)
+			}
+			rowText := strings.TrimSpace(sb.String())
+			if rowText == "" {
+				continue
+			}
+			if title == "" {
+				title = rowText
+			} else if description == "" {
+				description = rowText
+			} else {
+				break pages
+			}
+		}
+	}
+
+	return title, description, pdfText, nil
+}
+
+// extractTitleAndDescriptionAndBodyFromText treats a plain text response like
+// a page: the first non-blank line is the title, the next one the description,
+// and the whole text is indexed as the body.
+func (h *Handlers) extractTitleAndDescriptionAndBodyFromText(url *url.URL, responseBody []byte) (string, string, []byte, error) {
+	var title, description string
+	for _, line := range strings.Split(string(responseBody), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if title == "" {
+			title = line
+		} else if description == "" {
+			description = line
+		} else {
+			break
+		}
+	}
+
+	if title == "" {
+		title = h.extractTitleFromURL(url)
+	}
+	if len(title) > maxTitleLength {
+		title = title[:maxTitleLength] + "..."
+	}
+	if len(description) > maxDescriptionLength {
+		description = description[:maxDescriptionLength] + "..."
+	}
+
+	return title, description, responseBody, nil
+}
+
+func (h *Handlers) extractTitleFromURL(url *url.URL) string {
+	path := strings.TrimRight(url.Path, "/")
+	lastSegment := filepath.Base(path)
+	var title string
+	if lastSegment != "" && lastSegment != "." && lastSegment != "/" {
+		title = lastSegment
+	} else {
+		title = url.Host
+	}
+	return title
+}
+
+// bodyExtractTimeout bounds pulling the page body out of the browser, so a
+// massive DOM cannot stall the add; the body is then simply missing, like
+// any other extraction failure in the browser path.
+const bodyExtractTimeout = 10 * time.Second
+
+// bodyExtractScript is the expression evaluated in the browser for the page
+// body, sliced to maxBodyLength in the browser so an enormous DOM is capped
+// before the transfer instead of after.
+func bodyExtractScript() string {
+	return fmt.Sprintf("document.body.outerHTML.slice(0, %d)", maxBodyLength)
+}
+
+// extractTitleAndDescriptionAndBodyAndScreenshotFromURL does not take a
+// context: the browser context is shared between requests, so aborting a
+// navigation halfway would leave it in an undefined state for other requests.
+func (h *Handlers) extractTitleAndDescriptionAndBodyAndScreenshotFromURL(url *url.URL) (string, string, []byte, []byte, error) {
+	response, err := chromedp.RunResponse(h.browserContext,
+		chromedp.Navigate(url.String()),
+	)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	if response.Status >= 400 {
+		if h.SaveOnFetchError {
+			log.Printf("HTTP %d fetching %s, saving with title from the URL", response.Status, url)
+			return h.extractTitleFromURL(url), fmt.Sprintf("(fetch failed: HTTP %d)", response.Status), nil, nil, nil
+		}
+		return "", "", nil, nil, fmt.Errorf("failed to fetch URL: %v %v", response.Status, response.StatusText)
+	}
+
+	var title string
+	err = chromedp.Run(h.browserContext,
+		chromedp.Title(&title),
+	)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to extract title: %w", err)
+	}
+	title = strings.TrimSpace(title)
+
+	var description string
+	err = chromedp.Run(h.browserContext,
+		chromedp.Evaluate(`document.querySelector("head meta[name='description']").content`, &description),
+	)
+	if err != nil {
+		description = ""
+	}
+	description = strings.TrimSpace(description)
+
+	var bodyString string
+	// The timeout only covers the evaluation; unlike an aborted navigation,
+	// an abandoned evaluation leaves the shared browser context usable.
+	bodyContext, cancelBody := context.WithTimeout(h.browserContext, bodyExtractTimeout)
+	err = chromedp.Run(bodyContext,
+		chromedp.Evaluate(bodyExtractScript(), &bodyString),
+	)
+	cancelBody()
+	if err != nil {
+		log.Printf("failed to extract body: %v", err)
+	}
+	body := []byte(bodyString)
+	if h.ExtractBodyText && len(body) > 0 {
+		if doc, parseErr := html.Parse(bytes.NewReader(body)); parseErr == nil {
+			body = extractReadableText(doc)
+		}
+	}
+
+	var screenshot []byte
+	// A failed capture does not fail the add: the link is saved without its
+	// screenshot, and saveLink records the failure for the background
+	// retrier to re-capture later.
+	err = chromedp.Run(h.browserContext,
+		chromedp.EmulateViewport(800, 600),
+	)
+	if err != nil {
+		log.Printf("Failed to take screenshot of %s, saving without one: %v", url, err)
+	}
+	if selector := h.screenshotSelectorFor(url.Hostname()); err == nil && selector != "" {
+		// Element capture is best effort: checking presence first avoids
+		// chromedp.Screenshot waiting out the context on a page without
+		// the element.
+		var present bool
+		evalErr := chromedp.Run(h.browserContext,
+			chromedp.Evaluate(fmt.Sprintf(`document.querySelector(%q) !== null`, selector), &present),
+		)
+		if evalErr == nil && present {
+			captureErr := chromedp.Run(h.browserContext,
+				chromedp.Screenshot(selector, &screenshot, chromedp.ByQuery),
+			)
+			if captureErr != nil {
+				log.Printf("Failed to capture %q on %s, falling back to the viewport: %v", selector, url, captureErr)
+				screenshot = nil
+			}
+		} else {
+			log.Printf("No element matching %q on %s, capturing the viewport", selector, url)
+		}
+	}
+	if err == nil && screenshot == nil {
+		err = chromedp.Run(h.browserContext,
+			chromedp.ActionFunc(func(ctx context.Context) error {
+				var err error
+				screenshot, err = page.CaptureScreenshot().
+					WithFromSurface(true).
+					WithFormat(page.CaptureScreenshotFormatPng).
+					WithQuality(100).
+					Do(ctx)
+				if err != nil {
+					return err
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			log.Printf("Failed to take screenshot of %s, saving without one: %v", url, err)
+			screenshot = nil
+		}
+	}
+
+	if title == "" {
+		return "", "", nil, nil, fmt.Errorf("no title found in HTML")
+	}
+
+	if len(title) > maxTitleLength {
+		title = title[:maxTitleLength] + "..." + "..."
+	}
+
+	if len(description) > maxDescriptionLength {
+		description = description[:maxDescriptionLength] + "..."
+	}
+
+	if len(body) > maxBodyLength {
+		body = body[:maxBodyLength]
+	}
+
+	return title, description, body, screenshot, nil
+}
+
+// optimizeScreenshot re-encodes a PNG screenshot with maximum compression.
+// Best effort: if decoding fails or the re-encoded image is not smaller, the
+// original is kept.
+func optimizeScreenshot(screenshot []byte) []byte {
+	img, err := png.Decode(bytes.NewReader(screenshot))
+	if err != nil {
+		log.Printf("Not optimizing screenshot, failed to decode it: %v", err)
+		return screenshot
+	}
+
+	buf := new(bytes.Buffer)
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(buf, img); err != nil {
+		log.Printf("Not optimizing screenshot, failed to re-encode it: %v", err)
+		return screenshot
+	}
+
+	if buf.Len() >= len(screenshot) {
+		return screenshot
+	}
+	log.Printf("Optimized screenshot from %d to %d bytes", len(screenshot), buf.Len())
+	return buf.Bytes()
+}
+
+func (h *Handlers) saveScreenshot(id int64, urlString string, screenshot []byte) error {
+	filename := h.screenshotFile(id, urlString)
+	path := filepath.Join(h.screenshotsDir, filename)
+
+	if err := os.WriteFile(path, screenshot, 0644); err != nil {
+		return fmt.Errorf("failed to write screenshot file: %w", err)
+	}
+
+	return nil
+}
+
+// GetLink gets a single link.
+func (h *Handlers) GetLink(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	h.getLink(w, r, id)
+}
+
+// EditLink handles the request to edit a link.
+func (h *Handlers) EditLink(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Only the fields present in the form are changed, so a client can
+	// PATCH just the one it wants to fix.
+	var update db.LinkUpdate
+	if _, present := r.PostForm["title"]; present {
+		title := r.PostForm.Get("title")
+		if title == "" {
+			sendError(w, "title is required", http.StatusBadRequest)
+			return
+		}
+		if len(title) > maxTitleLength {
+			sendError(w, fmt.Sprintf("title is too long, max %d characters allowed", maxTitleLength), http.StatusBadRequest)
+			return
+		}
+		update.Title = &title
+	}
+
+	if _, present := r.PostForm["description"]; present {
+		description := r.PostForm.Get("description")
+		if len(description) > maxDescriptionLength {
+			sendError(w, fmt.Sprintf("description is too long, max %d characters allowed", maxDescriptionLength), http.StatusBadRequest)
+			return
+		}
+		update.Description = &description
+	}
+
+	if _, present := r.PostForm["url"]; present {
+		parsedURL, err := url.Parse(r.PostForm.Get("url"))
+		if err != nil || !parsedURL.IsAbs() {
+			sendError(w, "Invalid URL", http.StatusBadRequest)
+			return
+		}
+		parsedURL = h.canonicalizeURL(parsedURL)
+		if err := h.validateURL(parsedURL); err != nil {
+			sendError(w, fmt.Sprintf("Invalid URL: %v", err), http.StatusBadRequest)
+			return
+		}
+		urlString := parsedURL.String()
+		update.URL = &urlString
+	}
+
+	body := h.refetchBody(r.Context(), id, update.Description)
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	err = h.database.UpdateLink(writeCtx, id, update, body)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else if errors.Is(err, db.ErrDuplicate) {
+			sendError(w, "A link with that URL already exists", http.StatusConflict)
+		} else {
+			sendError(w, fmt.Sprintf("Failed edit link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The expiry is only touched when the field is present, an empty value
+	// clears it.
+	if _, present := r.PostForm["expires_at"]; present {
+		expiresAt, err := parseExpiry(r.PostForm.Get("expires_at"))
+		if err != nil {
+			sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.database.SetExpiry(writeCtx, id, expiresAt); err != nil {
+			sendError(w, fmt.Sprintf("Failed to set expiry: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Metadata keys not named in the meta field are kept, a key with an
+	// empty value is removed.
+	meta, err := h.parseMetaField(r.PostForm.Get("meta"))
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for key, value := range meta {
+		if err := h.database.SetMeta(writeCtx, id, key, value); err != nil {
+			sendError(w, fmt.Sprintf("Failed to set metadata: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.getLink(w, r, id)
+}
+
+func (h *Handlers) getLink(w http.ResponseWriter, r *http.Request, id int64) {
+	dbLink, err := h.database.GetLink(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if wantJson(r) {
+		h.renderJson(w, dbLink, http.StatusOK)
+	} else {
+		view := LinkView{
+			Link: dbLink,
+			Edit: r.URL.Query().Get("edit") == "1",
+			Full: true,
+		}
+		if h.browserContext != nil {
+			h.render(w, "link-with-screenshot", view, http.StatusOK)
+		} else {
+			h.render(w, "link-without-screenshot", view, http.StatusOK)
+		}
+	}
+}
+
+// shareResult is the response to creating a share token.
+type shareResult struct {
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+// CreateShare makes a link publicly viewable at /share/{token}. Creating a
+// share for an already shared link returns its existing token.
+func (h *Handlers) CreateShare(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	token, err := h.database.CreateShareToken(writeCtx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to create share token: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.renderJson(w, shareResult{Token: token, Path: "/share/" + token}, http.StatusCreated)
+}
+
+// RevokeShare removes the share token of a link, making it private again.
+func (h *Handlers) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	err = h.database.RevokeShareToken(writeCtx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to revoke share token: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SharedLink renders the public read-only view of a shared link. It is the
+// one route exempted from basic auth, so it shows nothing but the link
+// itself and offers no way to change it.
+func (h *Handlers) SharedLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.database.GetLinkByShareToken(r.Context(), r.PathValue("token"))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get shared link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	data := struct {
+		db.Link
+		Token          string
+		ShowScreenshot bool
+	}{
+		Link:           link,
+		Token:          r.PathValue("token"),
+		ShowScreenshot: h.browserContext != nil && !isNote(link.URL),
+	}
+	h.render(w, "share.html", data, http.StatusOK)
+}
+
+// SharedScreenshot serves the screenshot of a shared link through its token,
+// so that sharing one link does not open the screenshots of all the others.
+func (h *Handlers) SharedScreenshot(w http.ResponseWriter, r *http.Request) {
+	link, err := h.database.GetLinkByShareToken(r.Context(), r.PathValue("token"))
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to get shared link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.ServeFile(w, r, filepath.Join(h.screenshotsDir, h.screenshotFile(link.ID, link.URL)))
+}
+
+// SetPosition handles the request to set the manual position of a link, for
+// drag reordering. An empty position returns the link to date ordering.
+func (h *Handlers) SetPosition(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var position *int64
+	if value := r.PostForm.Get("position"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			sendError(w, fmt.Sprintf("Invalid position: %v", err), http.StatusBadRequest)
+			return
+		}
+		position = &parsed
+	}
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	err = h.database.SetLinkPosition(writeCtx, id, position)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to set position: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetRead handles the request to mark a link as read or unread, for
+// triaging reading. The read form field carries the new state.
+func (h *Handlers) SetRead(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	read, err := strconv.ParseBool(r.PostForm.Get("read"))
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid read value: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	err = h.database.SetRead(writeCtx, id, read)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to set read state: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		// The toggle button swaps its card for the response, so the
+		// refreshed fragment carries the new state back to the list.
+		h.getLink(w, r, id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteLink handles the request to delete a link. The link moves to the
+// trash rather than being removed; its screenshot stays on disk until the
+// trash is purged, so a restore brings the link back whole.
+func (h *Handlers) DeleteLink(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	err = h.database.DeleteLink(writeCtx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed delete link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if h.oobRequested(r) {
+		// Out-of-band fragments remove the row and refresh the count; the
+		// link is gone either way, so a failed count is not an error.
+		total, err := h.database.CountLinks(r.Context())
+		if err != nil {
+			log.Printf("Failed to count links for the delete response: %v", err)
+			return
+		}
+		h.render(w, "oob-delete", struct {
+			ID    int64
+			Total int
+		}{id, total}, http.StatusOK)
+	}
+}
+
+// batchDeleteResult is the response of BatchDelete: how many links were
+// actually deleted.
+type batchDeleteResult struct {
+	Deleted int `json:"deleted"`
+}
+
+// BatchDelete handles the request to delete several links at once. The body
+// is a JSON array of link ids; ids without a link are skipped rather than
+// failing the batch, and the response says how many links were deleted. Like
+// a single delete, the links move to the trash and their screenshots stay on
+// disk until it is purged.
+func (h *Handlers) BatchDelete(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		sendError(w, fmt.Sprintf("Failed to parse JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	deleted, err := h.database.DeleteLinks(writeCtx, ids)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to delete links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.renderJson(w, batchDeleteResult{Deleted: deleted}, http.StatusOK)
+}
+
+// Trash lists the links in the trash, most recently deleted first.
+func (h *Handlers) Trash(w http.ResponseWriter, r *http.Request) {
+	links, err := h.database.GetDeletedLinks(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	if links == nil {
+		// An empty trash is an empty array, not null
+		links = []db.Link{}
+	}
+	h.renderJson(w, links, http.StatusOK)
+}
+
+// RestoreLink handles the request to bring a link back from the trash.
+func (h *Handlers) RestoreLink(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Invalid ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	err = h.database.RestoreLink(writeCtx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		} else {
+			sendError(w, fmt.Sprintf("Failed to restore link: %v\n", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeResult is the response of PurgeTrash: how many links were removed.
+type purgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// PurgeTrash permanently removes every link in the trash, along with their
+// screenshot files.
+func (h *Handlers) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	writeCtx, cancel := writeContext(r.Context())
+	defer cancel()
+	refs, err := h.database.PurgeDeleted(writeCtx)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to purge trash: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	for _, ref := range refs {
+		screenshotPath := filepath.Join(h.screenshotsDir, h.screenshotFile(ref.ID, ref.URL))
+		if err := os.Remove(screenshotPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete screenshot: %v\n", err)
+		}
+	}
+
+	h.renderJson(w, purgeResult{Purged: len(refs)}, http.StatusOK)
+}
+
+// Stats serves the collection summary as JSON, for dashboard widgets: link
+// counts, recent activity, distinct hosts and the top tags. Aggregate
+// queries only, so it is cheap to poll.
+func (h *Handlers) Stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.database.Stats(r.Context())
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to get stats: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+	h.renderJson(w, stats, http.StatusOK)
+}
+
+func (h *Handlers) listLinks(w http.ResponseWriter, r *http.Request, status int) {
+	search := r.URL.Query().Get("s")
+	unread := r.URL.Query().Get("unread") == "1"
+	page, perPage, err := parsePageParams(r)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset := (page - 1) * perPage
+	var dbLinks []db.Link
+	var truncated bool
+	var hasNext bool
+	if search != "" {
+		limit := h.database.SearchLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil {
+				sendError(w, fmt.Sprintf("Invalid limit: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		dbLinks, truncated, err = h.database.SearchWithLimit(r.Context(), search, limit)
+		if err != nil {
+			sendError(w, fmt.Sprintf("Failed to search: %v\n", err), http.StatusInternalServerError)
+			return
+		}
+		if unread {
+			dbLinks = unreadOnly(dbLinks)
+		}
+		dbLinks, hasNext = paginate(dbLinks, offset, perPage)
+	} else if tag := r.URL.Query().Get("tag"); tag != "" {
+		dbLinks, err = h.database.GetLinksByTag(r.Context(), tag)
+		if err != nil {
+			sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+			return
+		}
+		if unread {
+			dbLinks = unreadOnly(dbLinks)
+		}
+		dbLinks, hasNext = paginate(dbLinks, offset, perPage)
+	} else {
+		// The filters combine: ?source=...&expired=true lists expired
+		// links from one add path.
+		query := db.LinkQuery{
+			Source:  r.URL.Query().Get("source"),
+			Expired: r.URL.Query().Get("expired") == "true",
+			Unread:  unread,
+			Dead:    r.URL.Query().Get("status") == "dead",
+		}
+		switch r.URL.Query().Get("sort") {
+		case "published":
+			query.Sort = db.SortPublished
+		case "date_desc":
+			query.Sort = db.SortAdded
+		case "date_asc":
+			query.Sort = db.SortAddedAsc
+		case "title_asc":
+			query.Sort = db.SortTitle
+		case "title_desc":
+			query.Sort = db.SortTitleDesc
+		default:
+			if query.Expired || query.Source != "" || query.Unread || query.Dead {
+				// Filtered lists are plain newest-first, manual positions
+				// only order the main listing.
+				query.Sort = db.SortAdded
+			}
+		}
+		// One row past the page tells whether there is a next page, without
+		// a separate count query.
+		query.Limit = perPage + 1
+		query.Offset = offset
+		dbLinks, err = h.database.QueryLinks(r.Context(), query)
+		if err != nil {
+			sendError(w, fmt.Sprintf("Failed to get links: %v\n", err), http.StatusInternalServerError)
+			return
+		}
+		if hasNext = len(dbLinks) > perPage; hasNext {
+			dbLinks = dbLinks[:perPage]
+		}
+	}
+
+	if wantJson(r) {
+		if truncated {
+			w.Header().Set("X-Results-Truncated", "true")
+		}
+		if dbLinks == nil {
+			// An empty result is an empty array, not null
+			dbLinks = []db.Link{}
+		}
+		h.renderJson(w, dbLinks, status)
+	} else {
+		links := make([]LinkView, 0, len(dbLinks))
+		for _, link := range dbLinks {
+			view := LinkView{Link: link}
+			if search != "" && h.SearchLead == "description" {
+				// An empty Lead falls back to the title in the template.
+				view.Lead = link.Description
+			}
+			links = append(links, view)
+		}
+		total, err := h.database.CountLinks(r.Context())
+		if err != nil {
+			sendError(w, fmt.Sprintf("Failed to count links: %v\n", err), http.StatusInternalServerError)
+			return
+		}
+		data := struct {
+			Search          string
+			Unread          bool
+			Sort            string
+			Links           []LinkView
+			Count           int
+			Total           int
+			ShowScreenshots bool
+			Truncated       bool
+			PrevPage        string
+			NextPage        string
+			OOB             bool
+		}{
+			Search:          search,
+			Unread:          unread,
+			Sort:            r.URL.Query().Get("sort"),
+			Links:           links,
+			Count:           len(links),
+			Total:           total,
+			ShowScreenshots: h.browserContext != nil,
+			Truncated:       truncated,
+			OOB:             h.OOBUpdates,
+		}
+		if page > 1 {
+			data.PrevPage = pageURL(r, page-1)
+		}
+		if hasNext {
+			data.NextPage = pageURL(r, page+1)
+		}
+		// A boosted navigation sends HX-Request too, but expects a full
+		// document, only a true partial request gets the fragment.
+		var templateName string
+		if r.Header.Get("HX-Request") == "true" && r.Header.Get("HX-Boosted") != "true" {
+			templateName = "links"
+		} else {
+			templateName = "index.html"
+		}
+		h.render(w, templateName, data, status)
+	}
+}
+
+// defaultPerPage is how many links one page of the listing holds unless
+// the per_page parameter says otherwise.
+const defaultPerPage = 50
+
+// parsePageParams reads the page and per_page query parameters, defaulting
+// to the first page of defaultPerPage links.
+func parsePageParams(r *http.Request) (int, int, error) {
+	page := 1
+	if value := r.URL.Query().Get("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("invalid page: %s", value)
+		}
+		page = parsed
+	}
+	perPage := defaultPerPage
+	if value := r.URL.Query().Get("per_page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("invalid per_page: %s", value)
+		}
+		perPage = parsed
+	}
+	return page, perPage, nil
+}
+
+// paginate cuts one page out of an already loaded result, for the search and
+// tag listings which are capped rather than paged in SQL. An offset past the
+// end is an empty page, not an error. Also reports whether more links follow
+// the page.
+func paginate(links []db.Link, offset, perPage int) ([]db.Link, bool) {
+	if offset >= len(links) {
+		return nil, false
+	}
+	links = links[offset:]
+	if len(links) > perPage {
+		return links[:perPage], true
+	}
+	return links, false
+}
+
+// unreadOnly filters a loaded result down to unread links, for the search
+// and tag listings whose SQL filters only on their own criteria.
+func unreadOnly(links []db.Link) []db.Link {
+	var unread []db.Link
+	for _, link := range links {
+		if !link.Read {
+			unread = append(unread, link)
+		}
+	}
+	return unread
+}
+
+// pageURL is the listing URL for one page, preserving the other query
+// parameters such as an active search.
+func pageURL(r *http.Request, page int) string {
+	query := r.URL.Query()
+	if page <= 1 {
+		query.Del("page")
+	} else {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if encoded := query.Encode(); encoded != "" {
+		return "?" + encoded
+	}
+	return "."
+}
+
+func wantJson(r *http.Request) bool {
+	wantJson := false
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.ToLower(accept) == "application/json" {
+			wantJson = true
+		}
+	}
+	return wantJson
+}
+
+// renderFailures counts template rendering failures, published as an expvar
+// so that monitoring can watch for them without scraping logs.
+var renderFailures = expvar.NewInt("render_failures")
+
+func (h *Handlers) render(w http.ResponseWriter, name string, data any, status int) {
+	buf := new(bytes.Buffer)
+	err := h.templates.ExecuteTemplate(buf, name, data)
+	if err != nil {
+		// The data type narrows down which call site built the bad data,
+		// several templates render more than one shape. sendError logs the
+		// details and sends the client only the generic 500 text.
+		renderFailures.Add(1)
+		sendError(w, fmt.Sprintf("Failed to render template %s with data %T: %v\n", name, data, err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+func (h *Handlers) renderJson(w http.ResponseWriter, data any, status int) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to marshal JSON: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(jsonData)
+}
+
+func sendError(w http.ResponseWriter, errorMessage string, status int) {
+	var message string
+	if status >= 500 {
+		log.Println(errorMessage + "\n" + string(debug.Stack()))
+		message = http.StatusText(status)
+	} else {
+		message = errorMessage
+	}
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintln(w, message)
+}
+
+func screenshotFilename(urlString string) string {
+	hash := sha256.Sum256([]byte(urlString))
+	return hex.EncodeToString(hash[:]) + ".png"
+}
+
+// screenshotFile is the file name of a link's screenshot under the configured
+// naming scheme: {id}.png with ScreenshotsByID, a hash of the URL otherwise.
+func (h *Handlers) screenshotFile(id int64, urlString string) string {
+	if h.ScreenshotsByID {
+		return fmt.Sprintf("%d.png", id)
+	}
+	return screenshotFilename(urlString)
+}
+
+func isNote(urlString string) bool {
+	return strings.HasPrefix(urlString, "note:")
+}
@@ -0,0 +1,4500 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+)
+
+func TestHandlers(t *testing.T) {
+	// Use a temporary database file for testing, in a directory which is
+	// removed afterwards along with the WAL and shared memory files.
+	dbFile := filepath.Join(t.TempDir(), "test_handlers.database")
+
+	testTitle := "Test Title"
+	testDescription := "Test Description"
+
+	// Initialize the database
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	// Create a mock HTTP server to simulate a valid URL
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, "<html><head><title>%s</title><meta name='description' content='%s'></head><body>Some body</body></html>", testTitle, testDescription)
+	}))
+	defer mockServer.Close()
+
+	var linkId int64
+
+	t.Run("add link success", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+mockServer.URL))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusCreated, response.StatusCode, "Handlers returned wrong status code")
+
+		locationHeader := response.Header.Get("Location")
+		linkIdString, found := strings.CutPrefix(locationHeader, "/")
+		require.True(t, found, "Response Location header doesn't has correct format: '%s'", locationHeader)
+		linkId, err = strconv.ParseInt(linkIdString, 10, 64)
+		require.NoError(t, err, "Failed to convert link ID")
+
+		assert.Contains(t, string(body), mockServer.URL, "Response doesn't contain the expected link URL")
+		assert.Contains(t, string(body), testTitle, "Response doesn't contain the expected link title")
+		assert.Contains(t, string(body), testDescription, "Response doesn't contain the expected link description")
+	})
+
+	t.Run("add link missing url", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("add link invalid url", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url=invalid-url"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("get all links success", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/html"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		assert.Contains(t, string(body), mockServer.URL, "Response doesn't contain the expected link URL")
+		assert.Contains(t, string(body), testTitle, "Response doesn't contain the expected link title")
+		assert.Contains(t, string(body), testDescription, "Response doesn't contain the expected link description")
+		assert.Contains(t, string(body), time.Now().Format("2006-01-02 "), "Response doesn't contain the expected date")
+	})
+
+	t.Run("get all links as JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/json")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "application/json"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		var data []db.Link
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err, "Response doesn't contain the expected JSON")
+		assert.Len(t, data, 1, "Wrong length of JSON response")
+		if len(data) == 1 {
+			assert.Equal(t, mockServer.URL, data[0].URL)
+			assert.Equal(t, testTitle, data[0].Title)
+			assert.Equal(t, testDescription, data[0].Description)
+		}
+	})
+
+	t.Run("get all links as htmx partial", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("HX-Request", "true")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.NotContains(t, string(body), "<!DOCTYPE html>", "Partial response contains a full document")
+		assert.Contains(t, string(body), testTitle, "Response doesn't contain the expected link title")
+	})
+
+	t.Run("get all links as boosted navigation", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("HX-Request", "true")
+		req.Header.Set("HX-Boosted", "true")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "<!DOCTYPE html>", "Boosted response doesn't contain a full document")
+		assert.Contains(t, string(body), testTitle, "Response doesn't contain the expected link title")
+	})
+
+	t.Run("search success", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/?s=test", nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/html"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		assert.Contains(t, string(body), mockServer.URL, "Response doesn't contain the expected link URL")
+		assert.Contains(t, string(body), testTitle, "Response doesn't contain the expected link title")
+		assert.Contains(t, string(body), testDescription, "Response doesn't contain the expected link description")
+		assert.Contains(t, string(body), time.Now().Format("2006-01-02 "), "Response doesn't contain the expected date")
+	})
+
+	t.Run("search without matches", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/?s=unmatchable", nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "No links match", "Response doesn't contain the empty-state message")
+		assert.Contains(t, string(body), "unmatchable", "Empty-state message doesn't name the search")
+		assert.Contains(t, string(body), "Clear search", "Response doesn't contain the clear-search link")
+	})
+
+	t.Run("search without matches as JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/?s=unmatchable", nil)
+		req.Header.Set("Accept", "application/json")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.Equal(t, "[]", string(body), "Expected an empty JSON array")
+	})
+
+	t.Run("get single link success", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%d", linkId), nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/html"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		assert.Contains(t, string(body), mockServer.URL, "Response doesn't contain the expected link URL")
+		assert.Contains(t, string(body), testTitle, "Response doesn't contain expected title")
+		assert.Contains(t, string(body), testDescription, "Response doesn't contain the expected link description")
+		assert.NotContains(t, string(body), "class=\"link-edit\"", "Response contain the unexpected edit form")
+	})
+
+	t.Run("get single link for edit success", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%d?edit=1", linkId), nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/html"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		assert.Contains(t, string(body), mockServer.URL, "Response doesn't contain the expected link URL")
+		assert.Contains(t, string(body), testTitle, "Response doesn't contain expected title")
+		assert.Contains(t, string(body), testDescription, "Response doesn't contain the expected link description")
+		assert.Contains(t, string(body), "class=\"link-edit\"", "Response doesn't contain the expected edit form")
+	})
+
+	t.Run("get single link as JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%d", linkId), nil)
+		req.Header.Set("Accept", "application/json")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "application/json"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		var data db.Link
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err, "Response doesn't contain the expected JSON")
+		assert.Equal(t, mockServer.URL, data.URL)
+		assert.Equal(t, testTitle, data.Title)
+		assert.Equal(t, testDescription, data.Description)
+	})
+
+	t.Run("get single link invalid id", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/invalid", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("get single link not found", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/999", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("patch link success", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", linkId), strings.NewReader("title=Updated Title&description=Updated Description"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/html"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		assert.Contains(t, string(body), "Updated Title", "Response doesn't contain the updated title")
+
+		assert.Contains(t, string(body), "Updated Description", "Response doesn't contain the updated description")
+
+		// Verify the link was actually updated in the database
+		updatedLink, err := database.GetLink(t.Context(), linkId)
+		require.NoError(t, err, "Failed to get updated link")
+		assert.Equal(t, "Updated Title", updatedLink.Title, "Link title was not updated in database")
+		assert.Equal(t, "Updated Description", updatedLink.Description, "Link description was not updated in database")
+	})
+
+	t.Run("patch link success JSON", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", linkId), strings.NewReader("title=Updated Title&description=Updated Description"))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "application/json"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		var data db.Link
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err, "Response doesn't contain the expected JSON")
+		assert.Equal(t, mockServer.URL, data.URL)
+		assert.Equal(t, "Updated Title", data.Title)
+		assert.Equal(t, "Updated Description", data.Description)
+
+		// Verify the link was actually updated in the database
+		updatedLink, err := database.GetLink(t.Context(), linkId)
+		require.NoError(t, err, "Failed to get updated link")
+		assert.Equal(t, "Updated Title", updatedLink.Title, "Link title was not updated in database")
+		assert.Equal(t, "Updated Description", updatedLink.Description, "Link description was not updated in database")
+	})
+
+	t.Run("patch link invalid id", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", "/invalid", strings.NewReader("title=Updated Title&description=Updated Description"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("patch link not found", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", "/999", strings.NewReader("title=Updated Title&description=Updated Description"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("patch link without title keeps it", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", linkId), strings.NewReader("description=Updated Description"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		// The title set by the earlier patch stays in place.
+		assert.Contains(t, string(body), "Updated Title", "The title should be untouched")
+	})
+
+	t.Run("patch link empty title", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", linkId), strings.NewReader("title="))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("patch link too long title", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", linkId), strings.NewReader("title="+strings.Repeat("a", maxTitleLength+1)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("patch link too long description", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", linkId), strings.NewReader("title=whatever&description="+strings.Repeat("b", maxDescriptionLength+1)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("delete link success", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/%d", linkId), nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		// Verify link was deleted
+		_, err = database.GetLink(t.Context(), 1)
+		assert.Error(t, err, "Link should have been deleted")
+	})
+
+	t.Run("delete link invalid id", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/invalid", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("delete link not found", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/999", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("add note success", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("note-title=NoteTitle&note-text=NoteText"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusCreated, response.StatusCode, "Handlers returned wrong status code")
+
+		locationHeader := response.Header.Get("Location")
+		linkIdString, found := strings.CutPrefix(locationHeader, "/")
+		require.True(t, found, "Response Location header doesn't has correct format: '%s'", locationHeader)
+		linkId, err = strconv.ParseInt(linkIdString, 10, 64)
+		require.NoError(t, err, "Failed to convert note ID")
+
+		assert.Contains(t, string(body), "NoteTitle", "Response doesn't contain the expected note title")
+		assert.Contains(t, string(body), "NoteText", "Response doesn't contain the expected note text")
+	})
+
+	t.Run("add note missing text", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("note-title=NoteTitle&note-text="))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("get single note success", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%d", linkId), nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "text/html"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		assert.Contains(t, string(body), "NoteTitle", "Response doesn't contain expected title")
+		assert.Contains(t, string(body), "NoteText", "Response doesn't contain the expected note")
+	})
+
+	t.Run("get single note as JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%d", linkId), nil)
+		req.Header.Set("Accept", "application/json")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "application/json"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		var data db.Link
+		err := json.Unmarshal(body, &data)
+		assert.NoError(t, err, "Response doesn't contain the expected JSON")
+		assert.True(t, strings.HasPrefix(data.URL, "note:"), "Response doesn't contain the expected note URL")
+		assert.Equal(t, "NoteTitle", data.Title)
+		assert.Equal(t, "NoteText", data.Description)
+	})
+
+	t.Run("bookmarklet save success", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/bookmarklet?url="+url.QueryEscape(mockServer.URL+"/bookmarklet-page"), nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusCreated, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.Contains(t, string(body), "Link saved!", "Response doesn't contain success message")
+	})
+
+	t.Run("bookmarklet save missing url", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/bookmarklet", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("bookmarklet save invalid url", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/bookmarklet?url=not-a-valid-url", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("bookmarklet save duplicate url", func(t *testing.T) {
+		// Save the same URL again - should get conflict
+		req := httptest.NewRequest("GET", "/bookmarklet?url="+url.QueryEscape(mockServer.URL+"/bookmarklet-page"), nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusConflict, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("main page contains bookmarklet link", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		assert.Contains(t, string(body), "Save to MyLinks", "Response doesn't contain bookmarklet link")
+	})
+}
+
+func testRequest(t *testing.T, handler http.Handler, req *http.Request) (*http.Response, []byte) {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	result := rr.Result()
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err, "Failed to read response body")
+	_ = result.Body.Close()
+	return result, body
+}
+
+func Test_extractTitleAndDescriptionAndBodyFromURL(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+
+	tests := []struct {
+		name         string
+		contentType  string
+		returnedBody []byte
+		title        string
+		description  string
+		body         []byte
+		wantErr      bool
+	}{
+		{
+			name:         "valid HTML page",
+			contentType:  "text/html",
+			returnedBody: []byte("<html><head><title>Example Domain</title><meta name='description' content='This domain is for use in illustrative examples in documents.'></head><body>\n<div>\n<h1>Some header</h1>\n</div>\n</body></html>"),
+			title:        "Example Domain",
+			description:  "This domain is for use in illustrative examples in documents.",
+			body:         []byte("<body>\n<div>\n<h1>Some header</h1>\n</div>\n</body>"),
+			wantErr:      false,
+		},
+		{
+			name:         "XHTML page",
+			contentType:  "application/xhtml+xml; charset=utf-8",
+			returnedBody: []byte("<html xmlns='http://www.w3.org/1999/xhtml'><head><title>XHTML Example</title></head><body>\n<p>Strict markup</p>\n</body></html>"),
+			title:        "XHTML Example",
+			description:  "",
+			body:         []byte("<body>\n<p>Strict markup</p>\n</body>"),
+			wantErr:      false,
+		},
+		{
+			name:         "plain text page",
+			contentType:  "text/plain; charset=utf-8",
+			returnedBody: []byte("A plain text document\n\nWith a second paragraph\nand more text after it\n"),
+			title:        "A plain text document",
+			description:  "With a second paragraph",
+			body:         []byte("A plain text document"),
+			wantErr:      false,
+		},
+		{
+			name:         "Invalid PDF",
+			contentType:  "application/pdf",
+			returnedBody: []byte("invalid pdf"),
+			title:        "server.URL",
+			description:  "PDF",
+			body:         nil,
+			wantErr:      false,
+		},
+		{
+			name:         "Other content",
+			contentType:  "image/jpeg",
+			returnedBody: []byte("binary data"),
+			title:        "server.URL",
+			description:  "image/jpeg",
+			body:         nil,
+			wantErr:      false,
+		},
+		{
+			name:         "no title found",
+			contentType:  "text/html",
+			returnedBody: []byte("<html><head><meta name='description' content='This domain is for use in illustrative examples in documents.'></head><body>\n<div>\n<h1>Some header</h1>\n</div>\n</body></html"),
+			title:        "",
+			description:  "",
+			body:         nil,
+			wantErr:      true,
+		},
+		{
+			name:         "Latin-1 page",
+			contentType:  "text/html; charset=iso-8859-1",
+			returnedBody: []byte("<html><head><title>Caf\xe9 fran\xe7ais</title></head><body>\n<p>Th\xe9 et caf\xe9</p>\n</body></html>"),
+			title:        "Café français",
+			description:  "",
+			body:         []byte("<body>\n<p>Thé et café</p>\n</body>"),
+			wantErr:      false,
+		},
+		{
+			name:         "Latin-1 declared in a meta tag only",
+			contentType:  "text/html",
+			returnedBody: []byte("<html><head><meta charset='iso-8859-1'><title>Caf\xe9</title></head><body>\n<p>Some text</p>\n</body></html>"),
+			title:        "Café",
+			description:  "",
+			body:         []byte("<body>\n<p>Some text</p>\n</body>"),
+			wantErr:      false,
+		},
+		{
+			name:         "OpenGraph tags only",
+			contentType:  "text/html",
+			returnedBody: []byte("<html><head><meta property='og:title' content='OpenGraph Title'><meta property='og:description' content='An OpenGraph summary.'></head><body>\n<div>\n<h1>Some header</h1>\n</div>\n</body></html>"),
+			title:        "OpenGraph Title",
+			description:  "An OpenGraph summary.",
+			body:         []byte("<body>\n<div>\n<h1>Some header</h1>\n</div>\n</body>"),
+			wantErr:      false,
+		},
+		{
+			name:         "standard tags win over OpenGraph",
+			contentType:  "text/html",
+			returnedBody: []byte("<html><head><title>Plain Title</title><meta name='description' content='The plain description.'><meta property='og:title' content='OpenGraph Title'><meta property='og:description' content='An OpenGraph summary.'></head><body>\n<div>\n<h1>Some header</h1>\n</div>\n</body></html>"),
+			title:        "Plain Title",
+			description:  "The plain description.",
+			body:         []byte("<body>\n<div>\n<h1>Some header</h1>\n</div>\n</body>"),
+			wantErr:      false,
+		},
+		{
+			name:         "very long title",
+			contentType:  "text/html",
+			returnedBody: []byte("<html><head><title>" + strings.Repeat("a", maxTitleLength+100) + "</title><meta name='description' content='This domain is for use in illustrative examples in documents.'></head><body>\n<div>\n<h1>Some header</h1>\n</div>\n</body></html"),
+			title:        strings.Repeat("a", maxTitleLength) + "...",
+			description:  "This domain is for use in illustrative examples in documents.",
+			body:         []byte("<body>\n<div>\n<h1>Some header</h1>\n</div>\n</body>"),
+			wantErr:      false,
+		},
+		{
+			name:         "very long description",
+			contentType:  "text/html",
+			returnedBody: []byte("<html><head><title>Example Domain</title><meta name='description' content='" + strings.Repeat("b", maxDescriptionLength+100) + "'></head><body>\n<div>\n<h1>Some header</h1>\n</div>\n</body></html"),
+			title:        "Example Domain",
+			description:  strings.Repeat("b", maxDescriptionLength) + "...",
+			body:         []byte("<body>\n<div>\n<h1>Some header</h1>\n</div>\n</body>"),
+			wantErr:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write(tt.returnedBody)
+			}))
+			defer server.Close()
+
+			parsedURL, _ := url.Parse(server.URL)
+			title, description, body, _, err := handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), parsedURL)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.title == "server.URL" {
+				assert.True(t, strings.HasSuffix(server.URL, title), "extractTitleAndDescriptionAndBodyFromURL() title = '%v', title '%v'", title, server.URL)
+			} else {
+				assert.Equal(t, tt.title, title)
+			}
+			assert.Equal(t, tt.description, description)
+			assert.True(t, bytes.HasPrefix(body, tt.body), "extractTitleAndDescriptionAndBodyFromURL() body = '%v', body '%v'", string(body), string(tt.body))
+		})
+	}
+}
+
+// TestFetchTimeoutAndUserAgent verifies that the configured user agent is
+// sent when fetching a page, and that a page slower than the configured
+// timeout fails to fetch, including its body read.
+func TestFetchTimeoutAndUserAgent(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+	handlers.UserAgent = "CustomAgent/2.0"
+	handlers.FetchTimeout = 100 * time.Millisecond
+
+	var mu sync.Mutex
+	var userAgents []string
+	var slowBody bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		slow := slowBody
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, "<html><head><title>A page</title></head><body>")
+		if slow {
+			// The headers and part of the body are out, only the body
+			// read can still hit the timeout.
+			w.(http.Flusher).Flush()
+			time.Sleep(400 * time.Millisecond)
+		}
+		_, _ = fmt.Fprint(w, "</body></html>")
+	}))
+	defer server.Close()
+
+	parsedURL, _ := url.Parse(server.URL)
+	title, _, _, _, err := handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), parsedURL)
+	require.NoError(t, err, "Failed to fetch")
+	assert.Equal(t, "A page", title)
+	mu.Lock()
+	assert.Equal(t, []string{"CustomAgent/2.0"}, userAgents, "Expected the configured user agent to be sent")
+	slowBody = true
+	mu.Unlock()
+
+	_, _, _, _, err = handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), parsedURL)
+	assert.Error(t, err, "Expected a body slower than the timeout to fail the fetch")
+}
+
+// Test_contentTypeAllowlist verifies that a response whose content type is
+// not in the configured allowlist is saved with a title derived from the URL
+// rather than processed.
+func Test_contentTypeAllowlist(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+	handlers.ContentTypes = []string{"text/html"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintln(w, "A plain text document")
+	}))
+	defer server.Close()
+
+	parsedURL, _ := url.Parse(server.URL)
+	title, description, body, _, err := handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), parsedURL)
+	require.NoError(t, err)
+	assert.Equal(t, parsedURL.Host, title, "Expected the title to be derived from the URL")
+	assert.Equal(t, "text/plain", description)
+	assert.Nil(t, body, "Expected no body for a content type outside the allowlist")
+}
+
+func Test_extractTitleFromURL(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+
+	tests := []struct {
+		name     string
+		rawURL   string
+		expected string
+		hasError bool
+	}{
+		{
+			name:     "URL with trailing path segment",
+			rawURL:   "http://example.com/some/path",
+			expected: "path",
+			hasError: false,
+		},
+		{
+			name:     "URL with single segment",
+			rawURL:   "http://example.com/single",
+			expected: "single",
+			hasError: false,
+		},
+		{
+			name:     "Root URL with trailing slash",
+			rawURL:   "http://example.com/",
+			expected: "example.com",
+			hasError: false,
+		},
+		{
+			name:     "Root URL without slash",
+			rawURL:   "http://example.com",
+			expected: "example.com",
+			hasError: false,
+		},
+		{
+			name:     "URL with query parameters",
+			rawURL:   "http://example.com/path/to/page?query=1",
+			expected: "page",
+			hasError: false,
+		},
+		{
+			name:     "URL with special characters in path segment",
+			rawURL:   "http://example.com/path/to/chapter_1-2",
+			expected: "chapter_1-2",
+			hasError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urlParsed, err := url.Parse(tt.rawURL)
+			require.NoError(t, err)
+			title := handlers.extractTitleFromURL(urlParsed)
+			assert.Equal(t, tt.expected, title)
+		})
+	}
+}
+
+// TestBatchAdd verifies that a batch of URLs is added with a per-URL result:
+// created for a new link, duplicate for a known one, failed with a reason for
+// a bad one.
+func TestBatchAdd(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_batch_add.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	// The first URL is already saved, so the batch reports it as a duplicate
+	_, err = database.AddLink(t.Context(), mockServer.URL+"/known", "Known", "", "", nil)
+	require.NoError(t, err, "Failed to add existing link")
+
+	urls := []string{mockServer.URL + "/known", mockServer.URL + "/new", "not-a-url"}
+	payload, err := json.Marshal(urls)
+	require.NoError(t, err, "Failed to marshal URLs")
+
+	req := httptest.NewRequest("POST", "/batch-add", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	response, body := testRequest(t, handler, req)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+	var results []BatchAddResult
+	require.NoError(t, json.Unmarshal(body, &results), "Response doesn't contain the expected JSON")
+	require.Len(t, results, len(urls), "Wrong number of results")
+
+	assert.Equal(t, urls[0], results[0].URL, "Wrong URL in first result")
+	assert.Equal(t, "duplicate", results[0].Status, "Expected the known URL to be a duplicate")
+
+	assert.Equal(t, "created", results[1].Status, "Expected the new URL to be created")
+	assert.NotZero(t, results[1].ID, "Expected an id for the created link")
+	link, err := database.GetLink(t.Context(), results[1].ID)
+	require.NoError(t, err, "Failed to get the created link")
+	assert.Equal(t, urls[1], link.URL, "Wrong URL in the created link")
+
+	assert.Equal(t, "failed", results[2].Status, "Expected the bad URL to fail")
+	assert.NotEmpty(t, results[2].Error, "Expected a reason for the failure")
+}
+
+// TestBatchDelete verifies that several links are deleted in one request,
+// with ids without a link skipped rather than failing the batch.
+func TestBatchDelete(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_batch_delete.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	first, err := database.AddLink(t.Context(), "https://example.com/first", "First", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	second, err := database.AddLink(t.Context(), "https://example.com/second", "Second", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	payload, err := json.Marshal([]int64{first, second, 9999})
+	require.NoError(t, err, "Failed to marshal ids")
+	req := httptest.NewRequest("POST", "/batch-delete", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	response, body := testRequest(t, handler, req)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	require.NoError(t, json.Unmarshal(body, &result), "Response doesn't contain the expected JSON")
+	assert.Equal(t, 2, result.Deleted, "Expected the two existing links deleted")
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Empty(t, links, "Expected the listing to be empty")
+	trashed, err := database.GetDeletedLinks(t.Context())
+	require.NoError(t, err, "Failed to get deleted links")
+	assert.Len(t, trashed, 2, "Expected the deleted links in the trash")
+
+	// A body which is not a JSON array of ids is a client error
+	req = httptest.NewRequest("POST", "/batch-delete", strings.NewReader("not json"))
+	req.Header.Set("Content-Type", "application/json")
+	response, _ = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected a client error for a bad body")
+}
+
+// TestFollowMetaRefresh verifies that a meta-refresh page is followed to the
+// real content page when enabled, and stored as-is by default.
+func TestFollowMetaRefresh(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_meta_refresh.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/start":
+			_, _ = fmt.Fprint(w, `<html><head><title>Redirecting...</title><meta http-equiv="refresh" content="0; url=/real"></head><body></body></html>`)
+		case "/real":
+			_, _ = fmt.Fprint(w, "<html><head><title>The real page</title></head><body></body></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+
+	startURL, err := url.Parse(mockServer.URL + "/start")
+	require.NoError(t, err, "Failed to parse URL")
+
+	t.Run("not followed by default", func(t *testing.T) {
+		title, _, _, _, err := handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), startURL)
+		require.NoError(t, err, "Failed to extract")
+		assert.Equal(t, "Redirecting...", title, "Expected the intermediate page's title")
+	})
+
+	t.Run("followed when enabled", func(t *testing.T) {
+		handlers.FollowMetaRefresh = true
+		t.Cleanup(func() {
+			handlers.FollowMetaRefresh = false
+		})
+
+		title, _, _, _, err := handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), startURL)
+		require.NoError(t, err, "Failed to extract")
+		assert.Equal(t, "The real page", title, "Expected the target page's title")
+	})
+}
+
+// TestOEmbed verifies that the oEmbed endpoint returns the required fields
+// for a saved link, and rejects bad requests.
+func TestOEmbed(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_oembed.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	id, err := database.AddLink(t.Context(), "https://example.com/article", "An <interesting> article", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	t.Run("link response", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/oembed?url=/%d", id), nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "application/json"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		var embed oEmbedResponse
+		require.NoError(t, json.Unmarshal(body, &embed), "Response doesn't contain the expected JSON")
+		assert.Equal(t, "1.0", embed.Version, "Wrong version")
+		assert.Equal(t, "link", embed.Type, "Wrong type")
+		assert.Equal(t, "An <interesting> article", embed.Title, "Wrong title")
+		assert.Equal(t, "MyLinks", embed.ProviderName, "Wrong provider name")
+		assert.Contains(t, embed.HTML, `href="https://example.com/article"`, "HTML snippet doesn't link to the URL")
+		assert.Contains(t, embed.HTML, "An &lt;interesting&gt; article", "HTML snippet doesn't escape the title")
+	})
+
+	t.Run("absolute url parameter", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/oembed?url=%s", url.QueryEscape(fmt.Sprintf("http://example.com/%d", id))), nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("unknown link", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/oembed?url=/9999", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("missing url parameter", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/oembed", nil)
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+}
+
+// TestSetPosition verifies the reorder endpoint: setting, clearing and
+// rejecting an invalid position.
+func TestSetPosition(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_set_position.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	firstId, err := database.AddLink(t.Context(), "https://example.com/first", "First", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	secondId, err := database.AddLink(t.Context(), "https://example.com/second", "Second", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	// Added within the same second, so date ordering needs distinct timestamps
+	_, err = database.ExecContext(t.Context(), "UPDATE links SET added_at = datetime('now', '-1 hour') WHERE id = ?", firstId)
+	require.NoError(t, err, "Failed to adjust added_at")
+
+	setPosition := func(t *testing.T, id int64, value string) *http.Response {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/%d/position", id), strings.NewReader("position="+value))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		return response
+	}
+
+	response := setPosition(t, firstId, "1")
+	assert.Equal(t, http.StatusNoContent, response.StatusCode, "Handlers returned wrong status code")
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Equal(t, firstId, links[0].ID, "Expected the positioned link first")
+
+	response = setPosition(t, firstId, "")
+	assert.Equal(t, http.StatusNoContent, response.StatusCode, "Handlers returned wrong status code")
+
+	links, err = database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Equal(t, secondId, links[0].ID, "Expected date ordering after clearing the position")
+
+	response = setPosition(t, firstId, "bogus")
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+
+	response = setPosition(t, 9999, "1")
+	assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+}
+
+// TestEmptyState verifies that a database without links renders a welcome
+// prompt, which disappears once a link is saved, and which is distinct from
+// the no-search-results state.
+func TestEmptyState(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_empty_state.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	t.Run("empty database shows the welcome prompt", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "Welcome!", "Response doesn't contain the welcome prompt")
+	})
+
+	t.Run("empty search does not show the welcome prompt", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/?s=anything", nil)
+		_, body := testRequest(t, handler, req)
+
+		assert.NotContains(t, string(body), "Welcome!", "No-results state shows the welcome prompt")
+		assert.Contains(t, string(body), "No links match", "Response doesn't contain the no-results message")
+	})
+
+	t.Run("saved link hides the welcome prompt", func(t *testing.T) {
+		_, err := database.AddLink(t.Context(), "https://example.com", "Example", "", "", nil)
+		require.NoError(t, err, "Failed to add link")
+
+		req := httptest.NewRequest("GET", "/", nil)
+		_, body := testRequest(t, handler, req)
+
+		assert.NotContains(t, string(body), "Welcome!", "Response still contains the welcome prompt")
+		assert.Contains(t, string(body), "Example", "Response doesn't contain the link")
+	})
+}
+
+// TestStripQueryHosts verifies that the query string is removed for a host
+// configured for it and kept for one that isn't.
+func TestStripQueryHosts(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_strip_query.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	addLink := func(t *testing.T, urlString string) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(urlString)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	}
+
+	t.Run("host not configured keeps the query", func(t *testing.T) {
+		handlers.StripQueryHosts = []string{"other.example.com"}
+		addLink(t, mockServer.URL+"/kept?session=abc123")
+
+		links, err := database.GetAllLinks(t.Context())
+		require.NoError(t, err, "Failed to get links")
+		require.Len(t, links, 1, "Wrong number of links")
+		assert.Equal(t, mockServer.URL+"/kept?session=abc123", links[0].URL, "Expected the query string to be kept")
+	})
+
+	t.Run("configured host loses the query", func(t *testing.T) {
+		mockHost, _, err := net.SplitHostPort(strings.TrimPrefix(mockServer.URL, "http://"))
+		require.NoError(t, err, "Failed to split mock server host")
+		handlers.StripQueryHosts = []string{mockHost}
+		addLink(t, mockServer.URL+"/stripped?session=abc123")
+
+		link, err := database.GetLink(t.Context(), 2)
+		require.NoError(t, err, "Failed to get link")
+		assert.Equal(t, mockServer.URL+"/stripped", link.URL, "Expected the query string to be stripped")
+	})
+}
+
+// TestStripFragments verifies that URL fragments are kept by default and
+// removed, including for deduplication, when StripFragments is set.
+func TestStripFragments(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_strip_fragments.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	addLink := func(t *testing.T, urlString string) *http.Response {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(urlString)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		return response
+	}
+
+	t.Run("fragments kept by default", func(t *testing.T) {
+		response := addLink(t, mockServer.URL+"/page#top")
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+
+		links, err := database.GetAllLinks(t.Context())
+		require.NoError(t, err, "Failed to get links")
+		require.Len(t, links, 1, "Wrong number of links")
+		assert.Equal(t, mockServer.URL+"/page#top", links[0].URL, "Expected the fragment to be kept")
+
+		response = addLink(t, mockServer.URL+"/page")
+		assert.Equal(t, http.StatusCreated, response.StatusCode, "Fragmentless variant should be a distinct link by default")
+	})
+
+	t.Run("fragments stripped when enabled", func(t *testing.T) {
+		handlers.StripFragments = true
+		t.Cleanup(func() {
+			handlers.StripFragments = false
+		})
+
+		response := addLink(t, mockServer.URL+"/other#section")
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+
+		link, err := database.GetLink(t.Context(), 3)
+		require.NoError(t, err, "Failed to get link")
+		assert.Equal(t, mockServer.URL+"/other", link.URL, "Expected the fragment to be stripped")
+
+		response = addLink(t, mockServer.URL+"/other#elsewhere")
+		assert.Equal(t, http.StatusConflict, response.StatusCode, "Fragment variants should dedupe to the same link")
+	})
+}
+
+// Test_canonicalizeURL verifies the always-on canonicalization: the scheme
+// and host are lowercased while the path, query and fragment are preserved
+// exactly as given.
+func Test_canonicalizeURL(t *testing.T) {
+	handlers := &Handlers{}
+
+	u, err := url.Parse("HTTP://Example.COM/Mixed/Case/Path?Q=Value#Frag")
+	require.NoError(t, err, "Failed to parse URL")
+	// url.Parse already lowercases the scheme, so force it back to exercise
+	// the canonicalization itself.
+	u.Scheme = "HTTP"
+
+	canonical := handlers.canonicalizeURL(u)
+	assert.Equal(t, "http://example.com/Mixed/Case/Path?Q=Value#Frag", canonical.String(), "Expected only scheme and host to be lowercased")
+	assert.Equal(t, "HTTP", u.Scheme, "The input URL should not be modified")
+
+	already, err := url.Parse("http://example.com/path")
+	require.NoError(t, err, "Failed to parse URL")
+	assert.Same(t, already, handlers.canonicalizeURL(already), "An already canonical URL should be returned as-is")
+}
+
+// Test_NormalizeURL exercises each normalization rule: host lowercasing,
+// default port dropping, tracking parameter stripping and trailing slash
+// collapsing, and that meaningful parts survive them.
+func Test_NormalizeURL(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"host is lowercased", "https://Example.COM/page", "https://example.com/page"},
+		{"default http port is dropped", "http://example.com:80/page", "http://example.com/page"},
+		{"default https port is dropped", "https://example.com:443/page", "https://example.com/page"},
+		{"a non-default port is kept", "https://example.com:8443/page", "https://example.com:8443/page"},
+		{"the default port of the other scheme is kept", "http://example.com:443/page", "http://example.com:443/page"},
+		{"utm parameters are stripped", "https://example.com/page?utm_source=x&utm_medium=y", "https://example.com/page"},
+		{"fbclid and gclid are stripped", "https://example.com/page?fbclid=abc&gclid=def", "https://example.com/page"},
+		{"other parameters survive the stripping", "https://example.com/page?id=7&utm_source=x", "https://example.com/page?id=7"},
+		{"trailing slashes are collapsed", "https://example.com/page//", "https://example.com/page"},
+		{"the root path is kept", "https://example.com/", "https://example.com/"},
+		{"a fragment is kept, StripFragments removes it", "https://example.com/page#section", "https://example.com/page#section"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NormalizeURL(test.raw)
+			require.NoError(t, err, "Failed to normalize URL")
+			assert.Equal(t, test.want, got, "Wrong normalization")
+		})
+	}
+}
+
+// TestIDNHosts verifies that the Unicode and punycode spellings of an
+// internationalized host canonicalize to the same URL, so they dedupe to one
+// link, and that RejectIDNHosts refuses both spellings.
+func TestIDNHosts(t *testing.T) {
+	handlers := &Handlers{}
+
+	t.Run("both spellings canonicalize alike", func(t *testing.T) {
+		unicode, err := url.Parse("https://例え.jp/page")
+		require.NoError(t, err, "Failed to parse URL")
+		punycode, err := url.Parse("https://xn--r8jz45g.jp/page")
+		require.NoError(t, err, "Failed to parse URL")
+
+		assert.Equal(t, punycode.String(), handlers.canonicalizeURL(unicode).String(), "Expected the Unicode spelling to canonicalize to punycode")
+		assert.Same(t, punycode, handlers.canonicalizeURL(punycode), "The punycode spelling is already canonical")
+	})
+
+	t.Run("ports survive the conversion", func(t *testing.T) {
+		u, err := url.Parse("https://例え.jp:8443/page")
+		require.NoError(t, err, "Failed to parse URL")
+		assert.Equal(t, "https://xn--r8jz45g.jp:8443/page", handlers.canonicalizeURL(u).String(), "Expected the port to be kept")
+	})
+
+	t.Run("rejected when configured", func(t *testing.T) {
+		handlers := &Handlers{RejectIDNHosts: true, forTesting: true}
+		for _, spelling := range []string{"https://例え.jp/page", "https://xn--r8jz45g.jp/page"} {
+			u, err := url.Parse(spelling)
+			require.NoError(t, err, "Failed to parse URL")
+			assert.ErrorContains(t, handlers.validateURL(u), "internationalized host", "Expected %s to be refused", spelling)
+		}
+
+		plain, err := url.Parse("https://example.com/page")
+		require.NoError(t, err, "Failed to parse URL")
+		assert.NoError(t, handlers.validateURL(plain), "A plain ASCII host should pass")
+	})
+}
+
+// Test_validateURL verifies that a hostname is rejected when it resolves to
+// an internal address, not only when it is spelled as one, with the resolver
+// stubbed so no real DNS is involved.
+func Test_validateURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		ips     []net.IP
+		wantErr bool
+	}{
+		{"public address", []net.IP{net.ParseIP("93.184.216.34")}, false},
+		{"loopback", []net.IP{net.ParseIP("127.0.0.1")}, true},
+		{"private IPv4", []net.IP{net.ParseIP("10.1.2.3")}, true},
+		{"private IPv6", []net.IP{net.ParseIP("fd00::1")}, true},
+		{"link-local", []net.IP{net.ParseIP("169.254.0.10")}, true},
+		{"unspecified", []net.IP{net.ParseIP("0.0.0.0")}, true},
+		{"private among public", []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.1.2.3")}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handlers := &Handlers{forTesting: true}
+			handlers.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+				assert.Equal(t, "internal.mycorp.example", host, "Wrong host resolved")
+				return test.ips, nil
+			}
+
+			u, err := url.Parse("http://internal.mycorp.example/page")
+			require.NoError(t, err, "Failed to parse URL")
+			err = handlers.validateURL(u)
+			if test.wantErr {
+				assert.ErrorContains(t, err, "private or local address", "Expected the resolved address to be rejected")
+			} else {
+				assert.NoError(t, err, "Expected a public address to pass")
+			}
+		})
+	}
+
+	t.Run("resolution failure", func(t *testing.T) {
+		handlers := &Handlers{forTesting: true}
+		handlers.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+			return nil, fmt.Errorf("no such host")
+		}
+
+		u, err := url.Parse("http://nosuchhost.example/")
+		require.NoError(t, err, "Failed to parse URL")
+		assert.ErrorContains(t, handlers.validateURL(u), "could not resolve", "Expected an unresolvable host to be rejected")
+	})
+}
+
+// TestEditLinkFields verifies that PATCH only changes the form fields
+// present, that an edited URL is canonicalized and validated, and that a
+// URL taken by another link is answered with 409.
+func TestEditLinkFields(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_edit_fields.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	id, err := database.AddLink(t.Context(), "https://example.com/one", "First Title", "First description", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://example.com/two", "Second Title", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	patch := func(t *testing.T, form string) (*http.Response, []byte) {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", id), strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return testRequest(t, handler, req)
+	}
+
+	t.Run("url only", func(t *testing.T) {
+		response, _ := patch(t, "url="+url.QueryEscape("HTTPS://Example.COM/moved"))
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to edit the URL")
+
+		link, err := database.GetLink(t.Context(), id)
+		require.NoError(t, err, "Failed to get link")
+		assert.Equal(t, "https://example.com/moved", link.URL, "Expected the canonicalized URL")
+		assert.Equal(t, "First Title", link.Title, "The title should be untouched")
+		assert.Equal(t, "First description", link.Description, "The description should be untouched")
+	})
+
+	t.Run("description only", func(t *testing.T) {
+		response, _ := patch(t, "description=Corrected+description")
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to edit the description")
+
+		link, err := database.GetLink(t.Context(), id)
+		require.NoError(t, err, "Failed to get link")
+		assert.Equal(t, "First Title", link.Title, "The title should be untouched")
+		assert.Equal(t, "Corrected description", link.Description, "Wrong description")
+	})
+
+	t.Run("taken URL", func(t *testing.T) {
+		response, _ := patch(t, "url="+url.QueryEscape("https://example.com/two"))
+		assert.Equal(t, http.StatusConflict, response.StatusCode, "Expected a conflict for a taken URL")
+	})
+
+	t.Run("invalid URL", func(t *testing.T) {
+		response, _ := patch(t, "url=not-a-url")
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected a bad request for an invalid URL")
+	})
+}
+
+// TestDuplicateAddPointsAtExisting verifies that a duplicate add reports the
+// id of the existing link, in the message and as a Location header, so the
+// front end can jump to it.
+func TestDuplicateAddPointsAtExisting(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_duplicate_add.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, "<html><head><title>Duplicate page</title></head><body></body></html>")
+	}))
+	defer mockServer.Close()
+
+	addLink := func(t *testing.T) (*http.Response, []byte) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+"/page")))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return testRequest(t, handler, req)
+	}
+
+	response, _ := addLink(t)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	existingID, err := database.GetLinkIDByURL(t.Context(), mockServer.URL+"/page")
+	require.NoError(t, err, "Failed to look up the link by URL")
+
+	response, body := addLink(t)
+	assert.Equal(t, http.StatusConflict, response.StatusCode, "Handlers returned wrong status code")
+	assert.Equal(t, fmt.Sprintf("/%d", existingID), response.Header.Get("Location"), "Expected the Location of the existing link")
+	assert.Contains(t, string(body), fmt.Sprintf("link %d", existingID), "Expected the existing link id in the message")
+}
+
+// TestLinkMeta verifies that author metadata is auto-populated from the
+// page's meta tags, that the meta form field sets and removes metadata on add
+// and PATCH, and that metadata appears in the JSON output.
+func TestLinkMeta(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_link_meta.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, `<html><head><title>Article</title><meta name="author" content="Jane Doe"></head><body></body></html>`)
+	}))
+	defer mockServer.Close()
+
+	getMeta := func(t *testing.T, id int64) map[string]string {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%d", id), nil)
+		req.Header.Set("Accept", "application/json")
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get link")
+		var link db.Link
+		require.NoError(t, json.Unmarshal(body, &link), "Failed to parse link JSON")
+		return link.Meta
+	}
+
+	// The author is picked up from the page automatically
+	req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+"/article")))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ := testRequest(t, handler, req)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	assert.Equal(t, map[string]string{"author": "Jane Doe"}, getMeta(t, 1), "Expected the author to be auto-populated")
+
+	// Explicit metadata on add wins over the auto-populated values
+	form := "url=" + url.QueryEscape(mockServer.URL+"/other") + "&meta=" + url.QueryEscape(`{"author": "John Doe", "rating": "5"}`)
+	req = httptest.NewRequest("POST", "/", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ = testRequest(t, handler, req)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	assert.Equal(t, map[string]string{"author": "John Doe", "rating": "5"}, getMeta(t, 2), "Expected the form metadata to win")
+
+	// PATCH sets the named keys, removes those with an empty value and
+	// keeps the rest
+	form = "title=Article&meta=" + url.QueryEscape(`{"author": "", "published": "2024-05-01"}`)
+	req = httptest.NewRequest("PATCH", "/2", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to edit link")
+	assert.Equal(t, map[string]string{"rating": "5", "published": "2024-05-01"}, getMeta(t, 2), "Wrong metadata after edit")
+
+	// A meta field which is not a JSON object is rejected
+	req = httptest.NewRequest("PATCH", "/2", strings.NewReader("title=Article&meta=not-json"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected invalid meta to be rejected")
+}
+
+// TestTagsMeta verifies that the tags metadata key is normalized — trimmed,
+// lowercased, inner whitespace collapsed — and that too many or too long
+// tags are rejected.
+func TestTagsMeta(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_tags.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.MaxTagsPerLink = 3
+	handlers.MaxTagLength = 10
+	handler := handlers.Routes()
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	patchTags := func(t *testing.T, tags string) (*http.Response, []byte) {
+		form := "title=Example&meta=" + url.QueryEscape(fmt.Sprintf(`{"tags": %q}`, tags))
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", id), strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return testRequest(t, handler, req)
+	}
+
+	t.Run("normalized", func(t *testing.T) {
+		response, _ := patchTags(t, " Go ,  WEB   Dev ,, reading")
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to edit link")
+
+		meta, err := database.GetMeta(t.Context(), id)
+		require.NoError(t, err, "Failed to get metadata")
+		assert.Equal(t, "go, web dev, reading", meta["tags"], "Wrong normalized tags")
+	})
+
+	t.Run("too many tags", func(t *testing.T) {
+		response, body := patchTags(t, "one, two, three, four")
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "more than 3 tags", "Response doesn't report the limit")
+	})
+
+	t.Run("too long tag", func(t *testing.T) {
+		response, body := patchTags(t, "much-too-long-for-a-tag")
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "longer than 10", "Response doesn't report the limit")
+	})
+}
+
+// TestLinkTags verifies that the tags form field tags a link on add, that
+// ?tag= filters the listing, and that the tags appear in the JSON listing.
+func TestLinkTags(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_link_tags.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	pages := map[string]string{
+		"/go":  `<html><head><title>Go Article</title></head><body></body></html>`,
+		"/web": `<html><head><title>Web Article</title></head><body></body></html>`,
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer mockServer.Close()
+
+	addTagged := func(t *testing.T, path, tags string) {
+		form := "url=" + url.QueryEscape(mockServer.URL+path) + "&tags=" + url.QueryEscape(tags)
+		req := httptest.NewRequest("POST", "/", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add %s", path)
+	}
+	addTagged(t, "/go", " Go , programming")
+	addTagged(t, "/web", "programming")
+
+	// The tags are normalized and stored
+	tags, err := database.GetTags(t.Context(), 1)
+	require.NoError(t, err, "Failed to get tags")
+	assert.Equal(t, []string{"go", "programming"}, tags, "Wrong tags")
+
+	// ?tag= filters the listing, and the JSON carries the tags
+	req := httptest.NewRequest("GET", "/?tag=go", nil)
+	req.Header.Set("Accept", "application/json")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list links")
+	var links []db.Link
+	require.NoError(t, json.Unmarshal(body, &links), "Failed to parse links JSON")
+	require.Len(t, links, 1, "Wrong number of links for the tag")
+	assert.Equal(t, mockServer.URL+"/go", links[0].URL, "Wrong link for the tag")
+	assert.Equal(t, []string{"go", "programming"}, links[0].Tags, "Expected the tags in the listing")
+
+	req = httptest.NewRequest("GET", "/?tag=programming", nil)
+	req.Header.Set("Accept", "application/json")
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list links")
+	require.NoError(t, json.Unmarshal(body, &links), "Failed to parse links JSON")
+	assert.Len(t, links, 2, "Wrong number of links for the shared tag")
+
+	// An invalid tag list is rejected before anything is fetched
+	handlers.MaxTagsPerLink = 1
+	form := "url=" + url.QueryEscape(mockServer.URL+"/go") + "&tags=one,two"
+	req = httptest.NewRequest("POST", "/", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, body = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	assert.Contains(t, string(body), "more than 1 tags", "Response doesn't report the limit")
+}
+
+// TestPagination verifies that the listing pages through page and per_page,
+// that an out-of-range page is empty rather than an error, and that the
+// pager links preserve an active search.
+func TestPagination(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_pagination.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	for i := 1; i <= 5; i++ {
+		id, err := database.AddLink(t.Context(), fmt.Sprintf("https://example.com/%d", i), fmt.Sprintf("Example %d", i), "", "", []byte("example body"))
+		require.NoError(t, err, "Failed to add link")
+		// Spread the added times out, so the page boundaries don't depend
+		// on how ties in the one-second timestamps fall.
+		_, err = database.ExecContext(t.Context(), "UPDATE links SET added_at = ? WHERE id = ?", time.Now().UTC().Add(time.Duration(i)*time.Minute), id)
+		require.NoError(t, err, "Failed to spread added times")
+	}
+
+	listPage := func(t *testing.T, query string) []db.Link {
+		req := httptest.NewRequest("GET", "/"+query, nil)
+		req.Header.Set("Accept", "application/json")
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list links")
+		var links []db.Link
+		require.NoError(t, json.Unmarshal(body, &links), "Failed to parse links JSON")
+		return links
+	}
+
+	links := listPage(t, "?per_page=2")
+	require.Len(t, links, 2, "Wrong page size")
+	assert.Equal(t, "Example 5", links[0].Title, "Expected the newest link first")
+	links = listPage(t, "?per_page=2&page=3")
+	require.Len(t, links, 1, "Wrong size for the last page")
+	assert.Equal(t, "Example 1", links[0].Title, "Expected the oldest link last")
+
+	// A page past the end is empty, not an error
+	assert.Empty(t, listPage(t, "?per_page=2&page=9"), "Expected an empty page past the end")
+
+	// Invalid paging parameters are rejected
+	for _, query := range []string{"?page=0", "?page=x", "?per_page=-1"} {
+		req := httptest.NewRequest("GET", "/"+query, nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected %s to be rejected", query)
+	}
+
+	// The HTML pager links to the neighbouring pages
+	req := httptest.NewRequest("GET", "/?per_page=2&page=2", nil)
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list links")
+	assert.Contains(t, string(body), "Next", "Expected a next page link")
+	assert.Contains(t, string(body), "page=3", "Expected a link to the next page")
+	assert.Contains(t, string(body), "Previous", "Expected a previous page link")
+
+	// Search results page too, and the pager keeps the search query
+	searched := listPage(t, "?s=example&per_page=2&page=2")
+	assert.Len(t, searched, 2, "Wrong search page size")
+	req = httptest.NewRequest("GET", "/?s=example&per_page=2", nil)
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to search")
+	assert.Contains(t, string(body), "s=example", "Expected the pager to preserve the search")
+	assert.Contains(t, string(body), "page=2", "Expected a link to the next search page")
+}
+
+// TestOOBUpdates verifies that with OOBUpdates on, an HTMX add returns an
+// out-of-band fragment for the new row instead of the full list, and an
+// HTMX delete returns fragments removing the row and refreshing the count.
+func TestOOBUpdates(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_oob.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.OOBUpdates = true
+	handler := handlers.Routes()
+
+	pages := map[string]string{
+		"/first":  `<html><head><title>First Page</title></head><body></body></html>`,
+		"/second": `<html><head><title>Second Page</title></head><body></body></html>`,
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer mockServer.Close()
+
+	add := func(t *testing.T, path string, htmx bool) (*http.Response, []byte) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		return testRequest(t, handler, req)
+	}
+
+	response, _ := add(t, "/first", false)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+
+	// The HTMX add carries only the new row and the count, not the list
+	response, body := add(t, "/second", true)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	assert.Contains(t, string(body), `hx-swap-oob="afterbegin:#links-grid"`, "Expected an out-of-band row fragment")
+	assert.Contains(t, string(body), "Second Page", "Expected the new row in the fragment")
+	assert.Contains(t, string(body), `id="links-count" hx-swap-oob="true">2<`, "Expected the updated count")
+	assert.NotContains(t, string(body), "First Page", "Expected the rest of the list not to be re-rendered")
+
+	// The HTMX delete removes the row out of band and refreshes the count
+	req := httptest.NewRequest("DELETE", "/2", nil)
+	req.Header.Set("HX-Request", "true")
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to delete link")
+	assert.Contains(t, string(body), `id="link-2" hx-swap-oob="delete"`, "Expected an out-of-band row removal")
+	assert.Contains(t, string(body), `id="links-count" hx-swap-oob="true">1<`, "Expected the updated count")
+
+	// A non-HTMX add still renders the full list
+	response, body = add(t, "/second", false)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	assert.Contains(t, string(body), "First Page", "Expected the full list without HTMX")
+
+	// And so does an HTMX add with the option off
+	handlers.OOBUpdates = false
+	id, err := database.GetLinkIDByURL(t.Context(), mockServer.URL+"/second")
+	require.NoError(t, err, "Failed to look up link")
+	req = httptest.NewRequest("DELETE", fmt.Sprintf("/%d", id), nil)
+	req.Header.Set("HX-Request", "true")
+	testRequest(t, handler, req)
+	response, body = add(t, "/second", true)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	assert.Contains(t, string(body), "First Page", "Expected the full list when the option is off")
+}
+
+// TestStoreHeaders verifies that select response headers are kept as link
+// metadata when StoreHeaders is set, and not by default.
+func TestStoreHeaders(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_store_headers.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Server", "mockserver/1.0")
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		_, _ = fmt.Fprint(w, "<html><head><title>A page</title></head><body></body></html>")
+	}))
+	defer mockServer.Close()
+
+	addLink := func(t *testing.T, path string) int64 {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+		id, err := database.GetLinkIDByURL(t.Context(), mockServer.URL+path)
+		require.NoError(t, err, "Failed to look up the link")
+		return id
+	}
+
+	t.Run("off by default", func(t *testing.T) {
+		id := addLink(t, "/default")
+		link, err := database.GetLink(t.Context(), id)
+		require.NoError(t, err, "Failed to get link")
+		for key := range link.Meta {
+			assert.NotContains(t, key, headerMetaPrefix, "Expected no header metadata by default")
+		}
+	})
+
+	t.Run("headers kept when enabled", func(t *testing.T) {
+		handlers.StoreHeaders = true
+		t.Cleanup(func() {
+			handlers.StoreHeaders = false
+		})
+		id := addLink(t, "/stored")
+		link, err := database.GetLink(t.Context(), id)
+		require.NoError(t, err, "Failed to get link")
+		assert.Equal(t, "text/html; charset=utf-8", link.Meta["header:content-type"], "Wrong stored Content-Type")
+		assert.Equal(t, "mockserver/1.0", link.Meta["header:server"], "Wrong stored Server")
+		assert.Equal(t, "Mon, 02 Jan 2006 15:04:05 GMT", link.Meta["header:last-modified"], "Wrong stored Last-Modified")
+	})
+}
+
+// TestExtractBodyText verifies that with ExtractBodyText enabled only the
+// readable article text of a page is indexed, while the default indexes the
+// raw HTML including script and navigation tokens.
+func TestExtractBodyText(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_extract_body.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, `<html><head><title>Sample</title></head><body>`+
+			`<nav>zqnavword</nav>`+
+			`<script>var zqscripttoken = 1;</script>`+
+			`<article>zqarticleword is the content</article>`+
+			`</body></html>`)
+	}))
+	defer mockServer.Close()
+
+	addLink := func(t *testing.T, path string) int64 {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+		id, err := database.GetLinkIDByURL(t.Context(), mockServer.URL+path)
+		require.NoError(t, err, "Failed to look up the link")
+		return id
+	}
+
+	rawID := addLink(t, "/raw")
+
+	handlers.ExtractBodyText = true
+	t.Cleanup(func() {
+		handlers.ExtractBodyText = false
+	})
+	readableID := addLink(t, "/readable")
+
+	searchIDs := func(t *testing.T, term string) []int64 {
+		links, err := database.Search(t.Context(), term)
+		require.NoError(t, err, "Failed to search")
+		var ids []int64
+		for _, link := range links {
+			ids = append(ids, link.ID)
+		}
+		return ids
+	}
+
+	assert.ElementsMatch(t, []int64{rawID, readableID}, searchIDs(t, "zqarticleword"), "Article text should be indexed either way")
+	assert.ElementsMatch(t, []int64{rawID}, searchIDs(t, "zqscripttoken"), "Script content should only be indexed for raw HTML")
+	assert.ElementsMatch(t, []int64{rawID}, searchIDs(t, "zqnavword"), "Navigation text should only be indexed for raw HTML")
+}
+
+// TestDisplayTitleLength verifies that the list shows a shortened title with
+// the full one in the hover tooltip, while the single-link view shows the
+// full title.
+func TestDisplayTitleLength(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_display_title.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	const fullTitle = "A rather long title that does not fit the list"
+	id, err := database.AddLink(t.Context(), "https://example.com", fullTitle, "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	t.Run("full title by default", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		_, body := testRequest(t, handler, req)
+		assert.Contains(t, string(body), ">"+fullTitle+"<", "Expected the full title without a display length")
+	})
+
+	t.Run("shortened in the list", func(t *testing.T) {
+		handlers.DisplayTitleLength = 13
+		t.Cleanup(func() {
+			handlers.DisplayTitleLength = 0
+		})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		_, body := testRequest(t, handler, req)
+		assert.Contains(t, string(body), ">A rather long...<", "Expected the shortened title in the list")
+		assert.Contains(t, string(body), `title="`+fullTitle+`"`, "Expected the full title in the tooltip")
+		assert.NotContains(t, string(body), ">"+fullTitle+"<", "The list should not show the full title")
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/%d", id), nil)
+		_, body = testRequest(t, handler, req)
+		assert.Contains(t, string(body), ">"+fullTitle+"<", "Expected the full title on the single-link view")
+	})
+}
+
+// TestSearchLead verifies that search result cards lead with the field
+// SearchLead asks for, falling back to the title for links without a
+// description, and that the plain list is unaffected.
+func TestSearchLead(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_search_lead.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	const description = "A handy description of the article"
+	_, err = database.AddLink(t.Context(), "https://example.com/described", "Described Example", description, "", nil)
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://example.com/bare", "Bare Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// The description always appears in the card body, so the assertions
+	// look at the heading anchor, whose title attribute names the link.
+	describedHeading := `title="Described Example">` + description + "</a>"
+
+	t.Run("titles lead by default", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/?s=Example", nil)
+		_, body := testRequest(t, handler, req)
+		assert.Contains(t, string(body), ">Described Example</a>", "Expected the title to lead by default")
+		assert.NotContains(t, string(body), describedHeading, "The description should not lead by default")
+	})
+
+	t.Run("descriptions lead when configured", func(t *testing.T) {
+		handlers.SearchLead = "description"
+		t.Cleanup(func() {
+			handlers.SearchLead = ""
+		})
+
+		req := httptest.NewRequest("GET", "/?s=Example", nil)
+		_, body := testRequest(t, handler, req)
+		assert.Contains(t, string(body), describedHeading, "Expected the description to lead the result card")
+		assert.Contains(t, string(body), ">Bare Example</a>", "Expected the title as fallback without a description")
+
+		req = httptest.NewRequest("GET", "/", nil)
+		_, body = testRequest(t, handler, req)
+		assert.Contains(t, string(body), ">Described Example</a>", "The plain list should still lead with the title")
+		assert.NotContains(t, string(body), describedHeading, "The plain list should not lead with the description")
+	})
+}
+
+// TestXhtmlExtraction verifies that XHTML documents yield their title and
+// meta description, including prefix-namespaced ones which the HTML parser
+// alone does not match.
+func TestXhtmlExtraction(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_xhtml.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	pages := map[string]string{
+		"/default-namespace": `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Default Namespace</title><meta name="description" content="Self-closed meta" /></head>
+<body><p>Text</p></body></html>`,
+		"/prefixed-namespace": `<?xml version="1.0" encoding="UTF-8"?>
+<x:html xmlns:x="http://www.w3.org/1999/xhtml">
+<x:head><x:title>Prefixed Namespace</x:title><x:meta name="description" content="Prefixed meta" /></x:head>
+<x:body><x:p>Text</x:p></x:body></x:html>`,
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xhtml+xml")
+		_, _ = fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer mockServer.Close()
+
+	for path, expected := range map[string][2]string{
+		"/default-namespace":  {"Default Namespace", "Self-closed meta"},
+		"/prefixed-namespace": {"Prefixed Namespace", "Prefixed meta"},
+	} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			response, _ := testRequest(t, handler, req)
+			require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+
+			id, err := database.GetLinkIDByURL(t.Context(), mockServer.URL+path)
+			require.NoError(t, err, "Failed to look up the link")
+			link, err := database.GetLink(t.Context(), id)
+			require.NoError(t, err, "Failed to get link")
+			assert.Equal(t, expected[0], link.Title, "Wrong title")
+			assert.Equal(t, expected[1], link.Description, "Wrong description")
+		})
+	}
+}
+
+// TestDeeplyNestedExtraction verifies that the extractors stay within the
+// walker's depth and node bounds on pathological trees. html.Parse refuses
+// documents deeper than its own limit, so the trees are built directly, as
+// an adversarial source could.
+func TestDeeplyNestedExtraction(t *testing.T) {
+	t.Run("deep nesting terminates quickly", func(t *testing.T) {
+		// A shallow title next to a chain two thousand times deeper than
+		// maxExtractDepth, with text at the bottom.
+		root := &html.Node{Type: html.ElementNode, Data: "body"}
+		title := &html.Node{Type: html.ElementNode, Data: "title"}
+		title.AppendChild(&html.Node{Type: html.TextNode, Data: "Shallow Title"})
+		root.AppendChild(title)
+		parent := root
+		for range maxExtractDepth * 2000 {
+			div := &html.Node{Type: html.ElementNode, Data: "div"}
+			parent.AppendChild(div)
+			parent = div
+		}
+		parent.AppendChild(&html.Node{Type: html.TextNode, Data: "buried text"})
+
+		start := time.Now()
+		assert.Equal(t, "Shallow Title", extractTitleFromHtml(root), "Wrong title")
+		text := extractTextContent(root)
+		assert.Less(t, time.Since(start), 10*time.Second, "Extraction of a deeply nested tree took too long")
+		assert.NotContains(t, text, "buried text", "Text below the depth limit should be ignored")
+	})
+
+	t.Run("node count is capped", func(t *testing.T) {
+		root := &html.Node{Type: html.ElementNode, Data: "body"}
+		for range maxExtractNodes * 2 {
+			root.AppendChild(&html.Node{Type: html.TextNode, Data: "x"})
+		}
+
+		text := extractTextContent(root)
+		assert.LessOrEqual(t, len(text), maxExtractNodes, "Expected at most one character per visited node")
+	})
+}
+
+// TestShowVersion verifies that /version reports the configured version and
+// the Go version of the build.
+func TestShowVersion(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+	handlers.Version = "v1.2.3"
+	handler := handlers.Routes()
+
+	req := httptest.NewRequest("GET", "/version", nil)
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+	var info BuildInfo
+	require.NoError(t, json.Unmarshal(body, &info), "Failed to parse the version response")
+	assert.Equal(t, "v1.2.3", info.Version, "Wrong version")
+	assert.NotEmpty(t, info.Go, "Expected the Go version of the build")
+}
+
+// TestStats verifies that /stats serves the collection summary as JSON.
+func TestStats(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_stats.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	first, err := database.AddLink(t.Context(), "https://a.example.com/one", "First", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://b.example.com/two", "Second", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.AddTag(t.Context(), first, "go"), "Failed to tag link")
+
+	response, body := testRequest(t, handler, httptest.NewRequest("GET", "/stats", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get stats")
+
+	var stats db.Stats
+	require.NoError(t, json.Unmarshal(body, &stats), "Stats is not valid JSON")
+	assert.Equal(t, 2, stats.Total, "Wrong total")
+	assert.Equal(t, 2, stats.AddedLast7Days, "Wrong 7 day count")
+	assert.Equal(t, 2, stats.AddedLast30Days, "Wrong 30 day count")
+	assert.Equal(t, 2, stats.DistinctHosts, "Wrong host count")
+	assert.Equal(t, []db.TagCount{{Name: "go", Count: 1}}, stats.TopTags, "Wrong top tags")
+}
+
+// TestBodyExtractScript verifies that the browser-path body extraction caps
+// the DOM in-browser at maxBodyLength. A browser round-trip is not available
+// here, so the evaluated expression itself is checked.
+func TestBodyExtractScript(t *testing.T) {
+	script := bodyExtractScript()
+	assert.Contains(t, script, "document.body.outerHTML", "The script should read the body element")
+	assert.Contains(t, script, fmt.Sprintf(".slice(0, %d)", maxBodyLength), "The script should slice to maxBodyLength in the browser")
+}
+
+// TestScreenshotsUnavailable verifies that an unwritable screenshots
+// directory is detected, that a failed screenshot write disables further
+// ones, and that links are still added without screenshots.
+func TestScreenshotsUnavailable(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_screenshots_unavailable.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+
+	// A plain file in place of the directory makes every write fail,
+	// regardless of the permissions the test runs with.
+	notADir := filepath.Join(t.TempDir(), "not-a-dir")
+	require.NoError(t, os.WriteFile(notADir, []byte("in the way"), 0644), "Failed to write file")
+
+	t.Run("unwritable directory is detected", func(t *testing.T) {
+		assert.NoError(t, checkWritable(t.TempDir()), "A fresh directory should be writable")
+		assert.Error(t, checkWritable(notADir), "Expected the unwritable directory to be detected")
+	})
+
+	handlers := newHandlers("../../..", database, notADir, true)
+	handler := handlers.Routes()
+
+	t.Run("failed write disables screenshots", func(t *testing.T) {
+		handlers.captureScreenshot = func(u *url.URL) ([]byte, error) {
+			return []byte("a screenshot"), nil
+		}
+		handlers.refreshScreenshot(db.Link{ID: 1, URL: "https://example.com/page"})
+		assert.True(t, handlers.screenshotsUnavailable.Load(), "Expected the failed write to disable screenshots")
+	})
+
+	t.Run("links are still added", func(t *testing.T) {
+		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = fmt.Fprint(w, "<html><head><title>Still works</title></head><body></body></html>")
+		}))
+		defer mockServer.Close()
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusCreated, response.StatusCode, "Expected the add to succeed without a screenshot")
+	})
+}
+
+// TestScreenshotsByID verifies that with id-based naming a screenshot is
+// saved as {id}.png, served from /screenshots/, removed when its deleted link
+// is purged, and that reconciliation renames files from the hash scheme.
+func TestScreenshotsByID(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_screenshots_by_id.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+	handlers.ScreenshotsByID = true
+	handler := handlers.Routes()
+
+	id, err := database.AddLink(t.Context(), "https://example.com/", "Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	idPath := filepath.Join(screenshotsDir, fmt.Sprintf("%d.png", id))
+
+	t.Run("save", func(t *testing.T) {
+		require.NoError(t, handlers.saveScreenshot(id, "https://example.com/", []byte("a screenshot")), "Failed to save screenshot")
+		assert.FileExists(t, idPath, "Expected the screenshot under the id name")
+	})
+
+	t.Run("serve", func(t *testing.T) {
+		token, err := database.CreateShareToken(t.Context(), id)
+		require.NoError(t, err, "Failed to create share token")
+		req := httptest.NewRequest("GET", "/share/"+token+"/screenshot.png", nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to serve the screenshot")
+		assert.Equal(t, "a screenshot", string(body), "Wrong screenshot content")
+	})
+
+	t.Run("purge removes the file", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/%d", id), nil)
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to delete link")
+		assert.FileExists(t, idPath, "Expected the screenshot to stay for a trashed link")
+		response, _ = testRequest(t, handler, httptest.NewRequest("DELETE", "/trash", nil))
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to purge trash")
+		assert.NoFileExists(t, idPath, "Expected the screenshot to be removed with the purge")
+	})
+
+	t.Run("reconciliation renames hash-named files", func(t *testing.T) {
+		otherID, err := database.AddLink(t.Context(), "https://other.example.com/", "Other", "", "", nil)
+		require.NoError(t, err, "Failed to add link")
+		hashed := filepath.Join(screenshotsDir, screenshotFilename("https://other.example.com/"))
+		require.NoError(t, os.WriteFile(hashed, []byte("old scheme"), 0644), "Failed to write screenshot")
+
+		handlers.reconcileScreenshots(t.Context())
+
+		assert.NoFileExists(t, hashed, "Expected the hash-named file to be renamed")
+		assert.FileExists(t, filepath.Join(screenshotsDir, fmt.Sprintf("%d.png", otherID)), "Expected the screenshot under the id name")
+	})
+}
+
+// TestRecaptureScreenshot verifies that the re-capture endpoint overwrites
+// the stored screenshot under its existing name, and answers 404 for an
+// unknown link and 503 when screenshots are disabled.
+func TestRecaptureScreenshot(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_recapture.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+	handler := handlers.Routes()
+
+	linkURL := "https://example.com/page"
+	id, err := database.AddLink(t.Context(), linkURL, "Example", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	path := filepath.Join(screenshotsDir, screenshotFilename(linkURL))
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0644), "Failed to write screenshot")
+
+	t.Run("disabled screenshots get the 503", func(t *testing.T) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/%d/screenshot", id), nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode, "Expected a 503 without a browser")
+	})
+
+	// Stands in for a running browser; the capture itself is stubbed
+	handlers.browserContext = t.Context()
+	handlers.captureScreenshot = func(u *url.URL) ([]byte, error) {
+		return []byte("recaptured"), nil
+	}
+
+	t.Run("recapture overwrites the stored image", func(t *testing.T) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/%d/screenshot", id), nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to recapture: %s", body)
+
+		var result recaptureResult
+		require.NoError(t, json.Unmarshal(body, &result), "Recapture did not return valid JSON")
+		assert.Equal(t, screenshotFilename(linkURL), result.Filename, "Expected the existing file name")
+		content, err := os.ReadFile(path)
+		require.NoError(t, err, "Failed to read screenshot")
+		assert.Equal(t, "recaptured", string(content), "Expected the screenshot to be replaced")
+	})
+
+	t.Run("unknown link gets the 404", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/9999/screenshot", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected a 404 for an unknown link")
+	})
+
+	t.Run("failed capture gets the 502", func(t *testing.T) {
+		handlers.captureScreenshot = func(u *url.URL) ([]byte, error) {
+			return nil, fmt.Errorf("browser crashed")
+		}
+		req := httptest.NewRequest("POST", fmt.Sprintf("/%d/screenshot", id), nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusBadGateway, response.StatusCode, "Expected a 502 for a failed capture")
+	})
+}
+
+// TestFetchFavicons verifies that adds fetch the icon a page declares, fall
+// back to /favicon.ico, serve it from the favicon endpoint, and answer 404
+// for a link whose site yielded no icon — and that fetching is off by
+// default.
+func TestFetchFavicons(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_favicons.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.FetchFavicons = true
+	handler := handlers.Routes()
+
+	pages := map[string]func(w http.ResponseWriter){
+		"/declared": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = fmt.Fprint(w, "<html><head><title>Declared</title><link rel='shortcut icon' href='/icons/declared.png'></head><body></body></html>")
+		},
+		"/icons/declared.png": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = fmt.Fprint(w, "declared icon")
+		},
+		"/bare": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = fmt.Fprint(w, "<html><head><title>Bare</title></head><body></body></html>")
+		},
+		"/favicon.ico": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "image/x-icon")
+			_, _ = fmt.Fprint(w, "fallback icon")
+		},
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		page(w)
+	}))
+	defer mockServer.Close()
+
+	addLink := func(t *testing.T, path string) int64 {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+		id, err := database.GetLinkIDByURL(t.Context(), mockServer.URL+path)
+		require.NoError(t, err, "Failed to look up link")
+		return id
+	}
+
+	t.Run("declared icon is fetched and served", func(t *testing.T) {
+		id := addLink(t, "/declared")
+		req := httptest.NewRequest("GET", fmt.Sprintf("/favicon/%d", id), nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get favicon")
+		assert.Equal(t, "image/png", response.Header.Get("Content-Type"), "Wrong favicon content type")
+		assert.Equal(t, "declared icon", string(body), "Wrong favicon content")
+	})
+
+	t.Run("favicon.ico is the fallback", func(t *testing.T) {
+		id := addLink(t, "/bare")
+		req := httptest.NewRequest("GET", fmt.Sprintf("/favicon/%d", id), nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get favicon")
+		assert.Equal(t, "image/x-icon", response.Header.Get("Content-Type"), "Wrong favicon content type")
+		assert.Equal(t, "fallback icon", string(body), "Wrong favicon content")
+	})
+
+	t.Run("no icon means 404", func(t *testing.T) {
+		delete(pages, "/favicon.ico")
+		id := addLink(t, "/bare?other=1")
+		req := httptest.NewRequest("GET", fmt.Sprintf("/favicon/%d", id), nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected a 404 for a link without a favicon")
+	})
+
+	t.Run("list shows the icon when enabled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		_, body := testRequest(t, handler, req)
+		assert.Contains(t, string(body), `src="./favicon/`, "Expected favicon images in the list")
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		handlers.FetchFavicons = false
+		id := addLink(t, "/declared?again=1")
+		req := httptest.NewRequest("GET", fmt.Sprintf("/favicon/%d", id), nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected no favicon to be fetched when off")
+
+		req = httptest.NewRequest("GET", "/", nil)
+		_, body := testRequest(t, handler, req)
+		assert.NotContains(t, string(body), `src="./favicon/`, "Expected no favicon images when off")
+	})
+}
+
+// TestFetchHostInterval verifies that two rapid fetches to the same host are
+// spaced by at least the configured interval.
+func TestFetchHostInterval(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_host_interval.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	const interval = 200 * time.Millisecond
+	handlers.FetchHostInterval = interval
+	handler := handlers.Routes()
+
+	var mu sync.Mutex
+	var fetchTimes []time.Time
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetchTimes = append(fetchTimes, time.Now())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	for _, path := range []string{"/first", "/second"} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	}
+
+	require.Len(t, fetchTimes, 2, "Wrong number of fetches")
+	// Timers can fire marginally early relative to the timestamps taken in
+	// the mock server, so allow a little scheduling slack.
+	assert.GreaterOrEqual(t, fetchTimes[1].Sub(fetchTimes[0]), interval-20*time.Millisecond, "Expected fetches to the same host to be spaced by the interval")
+}
+
+// TestFetchURLCooldown verifies that a repeated add of the same URL within
+// the cooldown window is answered with 429 without fetching the page again,
+// while a different URL is unaffected.
+func TestFetchURLCooldown(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_url_cooldown.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.FetchURLCooldown = time.Minute
+	handler := handlers.Routes()
+
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		// A failing page, the repeated-add case the cooldown is for
+		if r.URL.Path == "/flaky" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	add := func(path string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		return response.StatusCode
+	}
+
+	assert.Equal(t, http.StatusBadRequest, add("/flaky"), "Expected the failing page to fail the add")
+	assert.Equal(t, http.StatusTooManyRequests, add("/flaky"), "Expected the retry within the window to get a 429")
+	assert.Equal(t, http.StatusTooManyRequests, add("/flaky"), "Expected further retries to keep getting 429")
+	assert.Equal(t, http.StatusCreated, add("/other"), "Expected a different URL to be unaffected")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, fetches["/flaky"], "Expected the URL to be fetched only once within the window")
+	assert.Equal(t, 1, fetches["/other"], "Expected the other URL to be fetched")
+}
+
+// TestPublishedAt verifies that the page's published time is extracted from
+// its metadata, that an invalid value is left null, and that ?sort=published
+// orders by it.
+func TestPublishedAt(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_published.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	pages := map[string]string{
+		"/article": `<html><head><title>Article</title><meta property="article:published_time" content="2024-03-05T10:00:00Z"></head><body></body></html>`,
+		"/older":   `<html><head><title>Older</title></head><body><time datetime="2023-01-15">January 15</time></body></html>`,
+		"/invalid": `<html><head><title>Invalid</title><meta name="date" content="a while ago"></head><body></body></html>`,
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer mockServer.Close()
+
+	for _, path := range []string{"/invalid", "/article", "/older"} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+path)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add %s", path)
+	}
+
+	// The published time is stored, an unparseable one is left null
+	link, err := database.GetLink(t.Context(), 2)
+	require.NoError(t, err, "Failed to get link")
+	require.NotNil(t, link.PublishedAt, "Expected a published time for the article")
+	assert.Equal(t, time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC), link.PublishedAt.UTC(), "Wrong published time")
+	link, err = database.GetLink(t.Context(), 1)
+	require.NoError(t, err, "Failed to get link")
+	assert.Nil(t, link.PublishedAt, "Expected no published time for an unparseable date")
+
+	// sort=published orders by published time, undated links last
+	req := httptest.NewRequest("GET", "/?sort=published", nil)
+	req.Header.Set("Accept", "application/json")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list links")
+	var links []db.Link
+	require.NoError(t, json.Unmarshal(body, &links), "Failed to parse links JSON")
+	require.Len(t, links, 3, "Wrong number of links")
+	assert.Equal(t, mockServer.URL+"/article", links[0].URL, "Expected the most recently published link first")
+	assert.Equal(t, mockServer.URL+"/older", links[1].URL, "Expected the older published link second")
+	assert.Equal(t, mockServer.URL+"/invalid", links[2].URL, "Expected the undated link last")
+}
+
+// TestStoreFetchDuration verifies that an add records how long the fetch
+// took when StoreFetchDuration is on, and leaves the column null when off.
+func TestStoreFetchDuration(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_fetch_duration.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.StoreFetchDuration = true
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Sleeping makes the fetch measurably slow, so that the recorded
+		// duration cannot round down to zero.
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, "<html><head><title>Slow Page</title></head><body></body></html>")
+	}))
+	defer mockServer.Close()
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+"/slow")))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ := testRequest(t, handler, req)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+
+	link, err := database.GetLink(t.Context(), 1)
+	require.NoError(t, err, "Failed to get link")
+	require.NotNil(t, link.FetchMs, "Expected a fetch duration to be recorded")
+	assert.Positive(t, *link.FetchMs, "Expected a non-zero fetch duration")
+
+	// Off by default: no duration is recorded
+	handlers.StoreFetchDuration = false
+	req = httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+"/untimed")))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ = testRequest(t, handler, req)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+	link, err = database.GetLink(t.Context(), 2)
+	require.NoError(t, err, "Failed to get link")
+	assert.Nil(t, link.FetchMs, "Expected no fetch duration when the option is off")
+}
+
+// TestExportBookmarks verifies that the export is a Netscape bookmark file
+// served as an attachment, with ADD_DATE stamps, DD descriptions where
+// present, and HTML-escaped titles.
+func TestExportBookmarks(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_export.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	first, err := database.AddLink(t.Context(), "https://example.com/a?x=1&y=2", "Tips & Tricks", "A described link", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://example.com/b", "Bare Link", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	req := httptest.NewRequest("GET", "/export", nil)
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to export")
+	assert.Equal(t, `attachment; filename="linksaver-bookmarks.html"`, response.Header.Get("Content-Disposition"), "Wrong Content-Disposition")
+
+	export := string(body)
+	assert.True(t, strings.HasPrefix(export, "<!DOCTYPE NETSCAPE-Bookmark-file-1>"), "Expected the Netscape doctype first")
+	link, err := database.GetLink(t.Context(), first)
+	require.NoError(t, err, "Failed to get link")
+	assert.Contains(t, export,
+		fmt.Sprintf(`<DT><A HREF="https://example.com/a?x=1&amp;y=2" ADD_DATE="%d">Tips &amp; Tricks</A>`, link.AddedAt.Unix()),
+		"Expected an escaped bookmark entry with its add date")
+	assert.Contains(t, export, "<DD>A described link", "Expected the description as a DD element")
+	assert.Contains(t, export, ">Bare Link</A>", "Expected the undescribed link too")
+	assert.Equal(t, 1, strings.Count(export, "<DD>"), "Expected no DD element for an empty description")
+}
+
+// TestSetRead verifies the read toggle endpoint and that the unread filter
+// composes with the list, search and pagination.
+func TestSetRead(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_set_read.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	id, err := database.AddLink(t.Context(), "https://example.com/article", "An article", "", "", []byte("Searchable body"))
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), "https://example.com/other", "Other", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	// Mark the article read
+	req := httptest.NewRequest("POST", fmt.Sprintf("/%d/read", id), strings.NewReader("read=true"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ := testRequest(t, handler, req)
+	require.Equal(t, http.StatusNoContent, response.StatusCode, "Failed to mark link read")
+
+	// The read state comes through in JSON
+	req = httptest.NewRequest("GET", fmt.Sprintf("/%d", id), nil)
+	req.Header.Set("Accept", "application/json")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get link")
+	var link db.Link
+	require.NoError(t, json.Unmarshal(body, &link), "Response doesn't contain the expected JSON")
+	assert.True(t, link.Read, "Expected the link to be read")
+
+	// The unread filter hides it from the list
+	req = httptest.NewRequest("GET", "/?unread=1", nil)
+	req.Header.Set("Accept", "application/json")
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list links")
+	var links []db.Link
+	require.NoError(t, json.Unmarshal(body, &links), "Response doesn't contain the expected JSON")
+	require.Len(t, links, 1, "Expected one unread link")
+	assert.Equal(t, "Other", links[0].Title, "Wrong link unread")
+
+	// And from a search
+	req = httptest.NewRequest("GET", "/?s=searchable&unread=1", nil)
+	req.Header.Set("Accept", "application/json")
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to search links")
+	require.NoError(t, json.Unmarshal(body, &links), "Response doesn't contain the expected JSON")
+	assert.Empty(t, links, "Expected the read link hidden from an unread search")
+
+	// A toggle from the list swaps in the refreshed card
+	req = httptest.NewRequest("POST", fmt.Sprintf("/%d/read", id), strings.NewReader("read=false"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to toggle link")
+	assert.Contains(t, string(body), "Mark read", "Expected the fragment to offer marking read again")
+
+	// Bad input and unknown links are client errors
+	req = httptest.NewRequest("POST", fmt.Sprintf("/%d/read", id), strings.NewReader("read=maybe"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected a client error for a bad read value")
+	req = httptest.NewRequest("POST", "/9999/read", strings.NewReader("read=true"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected a 404 for an unknown link")
+}
+
+// TestLinkChecker verifies that a check sweep records the status each URL
+// answers with and that ?status=dead lists only the links which failed.
+func TestLinkChecker(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_link_checker.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	h := newHandlers("../../..", database, "", true)
+	handler := h.Routes()
+
+	aliveServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(aliveServer.Close)
+	deadServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	t.Cleanup(deadServer.Close)
+	// A server which is already gone, so the connection itself fails
+	downServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downURL := downServer.URL
+	downServer.Close()
+
+	alive, err := database.AddLink(t.Context(), aliveServer.URL, "Alive", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	dead, err := database.AddLink(t.Context(), deadServer.URL, "Dead", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	down, err := database.AddLink(t.Context(), downURL, "Down", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	h.checkLinks(t.Context())
+
+	link, err := database.GetLink(t.Context(), alive)
+	require.NoError(t, err, "Failed to get link")
+	require.NotNil(t, link.LastCheckStatus, "Expected a recorded check status")
+	assert.Equal(t, int64(http.StatusOK), *link.LastCheckStatus, "Wrong status for the healthy link")
+
+	req := httptest.NewRequest("GET", "/?status=dead", nil)
+	req.Header.Set("Accept", "application/json")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list dead links")
+	var links []db.Link
+	require.NoError(t, json.Unmarshal(body, &links), "Response doesn't contain the expected JSON")
+	require.Len(t, links, 2, "Expected the 410 link and the unreachable link")
+	ids := []int64{links[0].ID, links[1].ID}
+	assert.Contains(t, ids, dead, "Expected the link answering 410")
+	assert.Contains(t, ids, down, "Expected the link whose server is gone")
+}
+
+// TestContent verifies that the archived page text of a link can be read
+// back with its capture time, reduced to readable text so script and style
+// content never renders.
+func TestContent(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_content.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	// A raw HTML body, as stored with ExtractBodyText off
+	rawBody := []byte("<body><script>var secret = 1;</script><style>p { color: red }</style><p>The archived article text.</p></body>")
+	id, err := database.AddLink(t.Context(), "https://example.com/article", "An archived article", "", "", rawBody)
+	require.NoError(t, err, "Failed to add link")
+	bodyless, err := database.AddLink(t.Context(), "https://example.com/pdf", "A PDF", "", "", nil)
+	require.NoError(t, err, "Failed to add link without a body")
+
+	response, body := testRequest(t, handler, httptest.NewRequest("GET", fmt.Sprintf("/content/%d", id), nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get content")
+	assert.Contains(t, string(body), "An archived article", "Expected the link title")
+	assert.Contains(t, string(body), "The archived article text.", "Expected the saved page text")
+	assert.Contains(t, string(body), "Captured ", "Expected the capture time")
+	assert.NotContains(t, string(body), "var secret", "Expected script content to be stripped")
+	assert.NotContains(t, string(body), "color: red", "Expected style content to be stripped")
+
+	response, _ = testRequest(t, handler, httptest.NewRequest("GET", fmt.Sprintf("/content/%d", bodyless), nil))
+	assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected a 404 for a link without a stored body")
+	response, _ = testRequest(t, handler, httptest.NewRequest("GET", "/content/9999", nil))
+	assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected a 404 for an unknown link")
+}
+
+// TestTrash verifies that a deleted link lands in the trash, can be restored
+// from there, and that purging the trash removes the links and their
+// screenshot files for good.
+func TestTrash(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_trash.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+	handler := handlers.Routes()
+
+	linkURL := "https://example.com/trashed"
+	id, err := database.AddLink(t.Context(), linkURL, "Trashed Link", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	screenshotPath := filepath.Join(screenshotsDir, screenshotFilename(linkURL))
+	require.NoError(t, os.WriteFile(screenshotPath, []byte("fake png"), 0644), "Failed to write screenshot")
+
+	// Deleting moves the link to the trash and keeps the screenshot
+	response, _ := testRequest(t, handler, httptest.NewRequest("DELETE", fmt.Sprintf("/%d", id), nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to delete link")
+	assert.FileExists(t, screenshotPath, "Expected the screenshot to stay for a trashed link")
+
+	response, body := testRequest(t, handler, httptest.NewRequest("GET", "/trash", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list trash")
+	var trashed []db.Link
+	require.NoError(t, json.Unmarshal(body, &trashed), "Failed to parse trash listing")
+	require.Len(t, trashed, 1, "Wrong trash size")
+	assert.Equal(t, "Trashed Link", trashed[0].Title, "Wrong link in the trash")
+
+	// Restoring brings the link back to the listing
+	response, _ = testRequest(t, handler, httptest.NewRequest("POST", fmt.Sprintf("/%d/restore", id), nil))
+	require.Equal(t, http.StatusNoContent, response.StatusCode, "Failed to restore link")
+	response, body = testRequest(t, handler, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list links")
+	assert.Contains(t, string(body), "Trashed Link", "Expected the restored link in the listing")
+
+	// Restoring a link which is not in the trash is a 404
+	response, _ = testRequest(t, handler, httptest.NewRequest("POST", fmt.Sprintf("/%d/restore", id), nil))
+	assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected 404 for a link not in the trash")
+
+	// Purging empties the trash and removes the screenshot file
+	response, _ = testRequest(t, handler, httptest.NewRequest("DELETE", fmt.Sprintf("/%d", id), nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to delete link again")
+	response, body = testRequest(t, handler, httptest.NewRequest("DELETE", "/trash", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to purge trash")
+	var purged purgeResult
+	require.NoError(t, json.Unmarshal(body, &purged), "Failed to parse purge result")
+	assert.Equal(t, 1, purged.Purged, "Wrong purge count")
+	assert.NoFileExists(t, screenshotPath, "Expected the screenshot to go with the purge")
+	response, body = testRequest(t, handler, httptest.NewRequest("GET", "/trash", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to list trash")
+	assert.Equal(t, "[]", strings.TrimSpace(string(body)), "Expected an empty trash after the purge")
+}
+
+// TestImportBookmarks verifies that an uploaded Netscape bookmark file is
+// imported anchor by anchor, with the anchor text as title and ADD_DATE as
+// the added time, and that duplicates and bad entries are counted rather
+// than aborting the import.
+func TestImportBookmarks(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_import.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	// Already saved, so the import skips it
+	_, err = database.AddLink(t.Context(), "https://example.com/known", "Known", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	addDate := time.Date(2020, 5, 17, 12, 0, 0, 0, time.UTC)
+	bookmarks := fmt.Sprintf(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><A HREF="https://example.com/fresh" ADD_DATE="%d">Fresh Link</A>
+    <DD>A description the import ignores
+    <DT><A HREF="https://example.com/known">Known</A>
+    <DT><A HREF="not a url">Broken</A>
+</DL><p>
+`, addDate.Unix())
+
+	buf := &bytes.Buffer{}
+	form := multipart.NewWriter(buf)
+	part, err := form.CreateFormFile("bookmarks", "bookmarks.html")
+	require.NoError(t, err, "Failed to create file part")
+	_, err = part.Write([]byte(bookmarks))
+	require.NoError(t, err, "Failed to write file part")
+	require.NoError(t, form.Close(), "Failed to finish the form")
+
+	req := httptest.NewRequest("POST", "/import", buf)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to import: %s", body)
+
+	var summary ImportSummary
+	require.NoError(t, json.Unmarshal(body, &summary), "Import did not return valid JSON")
+	assert.Equal(t, 1, summary.Imported, "Wrong imported count")
+	assert.Equal(t, 1, summary.Skipped, "Wrong skipped count")
+	assert.Equal(t, 1, summary.Failed, "Wrong failed count")
+
+	id, err := database.GetLinkIDByURL(t.Context(), "https://example.com/fresh")
+	require.NoError(t, err, "Expected the fresh link to be imported")
+	link, err := database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get imported link")
+	assert.Equal(t, "Fresh Link", link.Title, "Expected the anchor text as title")
+	assert.Equal(t, addDate, link.AddedAt.UTC(), "Expected ADD_DATE as the added time")
+
+	// A request without a file is rejected
+	req = httptest.NewRequest("POST", "/import", strings.NewReader(""))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=empty")
+	response, _ = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected a 400 without a bookmarks file")
+}
+
+// TestExportCSV verifies that the CSV export carries the documented columns
+// with RFC3339 dates and comma-joined tags, quoting fields which hold commas
+// or newlines so the file parses back losslessly.
+func TestExportCSV(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_export_csv.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	tricky, err := database.AddLink(t.Context(), "https://example.com/a", "Tips, Tricks", "A \"quoted\" description\nwith a newline", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.AddTag(t.Context(), tricky, "go"), "Failed to tag link")
+	require.NoError(t, database.AddTag(t.Context(), tricky, "web"), "Failed to tag link")
+	_, err = database.AddLink(t.Context(), "https://example.com/b", "Bare Link", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	response, body := testRequest(t, handler, httptest.NewRequest("GET", "/export.csv", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to export")
+	assert.Equal(t, "text/csv; charset=UTF-8", response.Header.Get("Content-Type"), "Wrong Content-Type")
+	assert.Equal(t, `attachment; filename="linksaver.csv"`, response.Header.Get("Content-Disposition"), "Wrong Content-Disposition")
+
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	require.NoError(t, err, "Export is not valid CSV")
+	require.Len(t, records, 3, "Expected the header and both links")
+	assert.Equal(t, []string{"url", "title", "description", "added_at", "tags"}, records[0], "Wrong header row")
+
+	var trickyRow []string
+	for _, record := range records[1:] {
+		if record[0] == "https://example.com/a" {
+			trickyRow = record
+		}
+	}
+	require.NotNil(t, trickyRow, "Expected a row for the tricky link")
+	assert.Equal(t, "Tips, Tricks", trickyRow[1], "Expected the comma in the title to survive")
+	assert.Equal(t, "A \"quoted\" description\nwith a newline", trickyRow[2], "Expected quotes and the newline to survive")
+	_, err = time.Parse(time.RFC3339, trickyRow[3])
+	assert.NoError(t, err, "Expected the added time in RFC3339")
+	assert.Equal(t, "go,web", trickyRow[4], "Expected the tags comma-joined")
+}
+
+// TestImportCSV verifies that an uploaded CSV file is imported row by row:
+// missing trailing columns fall back to defaults, extra ones are ignored,
+// and duplicates and bad rows are counted rather than aborting the import.
+func TestImportCSV(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_import_csv.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	// Already saved, so the import skips it
+	_, err = database.AddLink(t.Context(), "https://example.com/known", "Known", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	csvFile := `url,title,description,added_at,tags
+https://example.com/fresh,Fresh Link,"A, described link",2020-05-17T12:00:00Z,"go,web"
+https://example.com/short
+https://example.com/known,Known
+not a url,Broken
+https://example.com/extra,Extra,,,,stray column
+`
+
+	buf := &bytes.Buffer{}
+	form := multipart.NewWriter(buf)
+	part, err := form.CreateFormFile("csv", "links.csv")
+	require.NoError(t, err, "Failed to create file part")
+	_, err = part.Write([]byte(csvFile))
+	require.NoError(t, err, "Failed to write file part")
+	require.NoError(t, form.Close(), "Failed to finish the form")
+
+	req := httptest.NewRequest("POST", "/import.csv", buf)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to import: %s", body)
+
+	var summary ImportSummary
+	require.NoError(t, json.Unmarshal(body, &summary), "Import did not return valid JSON")
+	assert.Equal(t, 3, summary.Imported, "Wrong imported count")
+	assert.Equal(t, 1, summary.Skipped, "Wrong skipped count")
+	assert.Equal(t, 1, summary.Failed, "Wrong failed count")
+
+	id, err := database.GetLinkIDByURL(t.Context(), "https://example.com/fresh")
+	require.NoError(t, err, "Expected the fresh link to be imported")
+	link, err := database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get imported link")
+	assert.Equal(t, "Fresh Link", link.Title, "Wrong title")
+	assert.Equal(t, "A, described link", link.Description, "Expected the quoted description intact")
+	assert.Equal(t, time.Date(2020, 5, 17, 12, 0, 0, 0, time.UTC), link.AddedAt.UTC(), "Expected the added_at column as the added time")
+	assert.Equal(t, []string{"go", "web"}, link.Tags, "Expected the comma-joined tags")
+
+	// A row cut off after the URL still imports, with the URL as title
+	id, err = database.GetLinkIDByURL(t.Context(), "https://example.com/short")
+	require.NoError(t, err, "Expected the short row to be imported")
+	link, err = database.GetLink(t.Context(), id)
+	require.NoError(t, err, "Failed to get imported link")
+	assert.Equal(t, "https://example.com/short", link.Title, "Expected the URL as title for a row without one")
+
+	// A request without a file is rejected
+	req = httptest.NewRequest("POST", "/import.csv", strings.NewReader(""))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=empty")
+	response, _ = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected a 400 without a csv file")
+}
+
+// TestManifest verifies that the web app manifest is valid JSON with the
+// fields installability requires, and that the service worker is served as a
+// script.
+func TestManifest(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_manifest.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	req := httptest.NewRequest("GET", "/manifest.webmanifest", nil)
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get manifest")
+	assert.Equal(t, "application/manifest+json", response.Header.Get("Content-Type"), "Wrong manifest Content-Type")
+
+	var manifest struct {
+		Name     string `json:"name"`
+		StartURL string `json:"start_url"`
+		Display  string `json:"display"`
+		Icons    []struct {
+			Src string `json:"src"`
+		} `json:"icons"`
+	}
+	require.NoError(t, json.Unmarshal(body, &manifest), "Manifest is not valid JSON")
+	assert.Equal(t, "MyLinks", manifest.Name, "Wrong manifest name")
+	assert.Equal(t, "/", manifest.StartURL, "Wrong manifest start_url")
+	assert.Equal(t, "standalone", manifest.Display, "Wrong manifest display mode")
+	require.NotEmpty(t, manifest.Icons, "Manifest declares no icons")
+	assert.Equal(t, "/static/favicon.svg", manifest.Icons[0].Src, "Wrong manifest icon")
+
+	req = httptest.NewRequest("GET", "/sw.js", nil)
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get service worker")
+	assert.Equal(t, "text/javascript; charset=utf-8", response.Header.Get("Content-Type"), "Wrong service worker Content-Type")
+	assert.Contains(t, string(body), "addEventListener", "Unexpected service worker content")
+}
+
+// TestStaticCache verifies that static assets carry the configured
+// Cache-Control header, and none when no policy is configured.
+func TestStaticCache(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_static_cache.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	req := httptest.NewRequest("GET", "/static/favicon.svg", nil)
+	response, _ := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get static file")
+	assert.Empty(t, response.Header.Get("Cache-Control"), "Expected no Cache-Control header by default")
+
+	handlers.StaticCacheMaxAge = time.Hour
+	response, _ = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get static file")
+	assert.Equal(t, "public, max-age=3600", response.Header.Get("Cache-Control"), "Wrong Cache-Control header")
+}
+
+// TestAtomFeed verifies that the feed lists the newest links first with
+// their title, URL, summary and added time, and that markup in a title or
+// description is escaped rather than breaking the XML.
+func TestAtomFeed(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_atom_feed.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	older := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	id, err := database.AddLink(t.Context(), "https://example.com/first", "Plain Title", "A plain description", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.SetAddedAt(t.Context(), id, older), "Failed to set added time")
+	id, err = database.AddLink(t.Context(), "https://example.com/second", "Tricky <Title> & Co", "Angle <brackets> & ampersands", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.SetAddedAt(t.Context(), id, newer), "Failed to set added time")
+
+	response, body := testRequest(t, handler, httptest.NewRequest("GET", "/feed.atom", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get feed")
+	assert.Equal(t, "application/atom+xml", response.Header.Get("Content-Type"), "Wrong feed Content-Type")
+
+	var feed atomFeed
+	require.NoError(t, xml.Unmarshal(body, &feed), "The feed is not well-formed XML")
+	assert.Equal(t, newer.Format(time.RFC3339), feed.Updated, "Expected the feed updated time to follow the newest link")
+	require.Len(t, feed.Entries, 2, "Wrong number of entries")
+	assert.Equal(t, "Tricky <Title> & Co", feed.Entries[0].Title, "Expected the newest link first, with its markup intact")
+	assert.Equal(t, "Angle <brackets> & ampersands", feed.Entries[0].Summary, "Wrong summary")
+	assert.Equal(t, "https://example.com/second", feed.Entries[0].Link.Href, "Wrong entry link")
+	assert.Equal(t, newer.Format(time.RFC3339), feed.Entries[0].Updated, "Wrong entry updated time")
+	assert.Equal(t, "https://example.com/first", feed.Entries[1].Link.Href, "Expected the older link last")
+
+	// The markup arrived escaped on the wire, not raw
+	assert.Contains(t, string(body), "Tricky &lt;Title&gt; &amp; Co", "Expected the title to be escaped")
+	assert.NotContains(t, string(body), "<brackets>", "Expected the description to be escaped")
+}
+
+// TestScreenshotCache verifies that screenshots are served with a long-lived
+// Cache-Control policy and an ETag which answers conditional requests with a
+// 304, and that a missing screenshot's 404 carries no cache headers.
+func TestScreenshotCache(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_screenshot_cache.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+	// The /screenshots/ route only registers with a running browser.
+	handlers.browserContext = t.Context()
+	handler := handlers.Routes()
+
+	filename := screenshotFilename("https://example.com/")
+	require.NoError(t, os.WriteFile(filepath.Join(screenshotsDir, filename), []byte("a screenshot"), 0644), "Failed to write screenshot")
+
+	response, body := testRequest(t, handler, httptest.NewRequest("GET", "/screenshots/"+filename, nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get screenshot")
+	assert.Equal(t, "a screenshot", string(body), "Wrong screenshot content")
+	assert.Equal(t, "public, max-age=31536000, immutable", response.Header.Get("Cache-Control"), "Wrong Cache-Control header")
+	etag := response.Header.Get("ETag")
+	require.NotEmpty(t, etag, "Expected an ETag")
+
+	// A conditional request with the ETag is answered with a 304
+	req := httptest.NewRequest("GET", "/screenshots/"+filename, nil)
+	req.Header.Set("If-None-Match", etag)
+	response, _ = testRequest(t, handler, req)
+	assert.Equal(t, http.StatusNotModified, response.StatusCode, "Expected a 304 for a matching ETag")
+
+	// A missing screenshot is a plain 404, not a cached one
+	response, _ = testRequest(t, handler, httptest.NewRequest("GET", "/screenshots/nosuchfile.png", nil))
+	require.Equal(t, http.StatusNotFound, response.StatusCode, "Expected a 404 for a missing screenshot")
+	assert.Empty(t, response.Header.Get("Cache-Control"), "Expected no Cache-Control on the 404")
+	assert.Empty(t, response.Header.Get("ETag"), "Expected no ETag on the 404")
+}
+
+// TestGzipResponse verifies that HTML and JSON responses are gzip-compressed
+// for clients which accept it, while screenshots and clients without gzip
+// support get the bytes as they are.
+func TestGzipResponse(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_gzip.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+	// The /screenshots/ route only registers with a running browser.
+	handlers.browserContext = t.Context()
+	handler := handlers.Routes()
+
+	_, err = database.AddLink(t.Context(), "https://example.com/", "Example Website", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	gunzip := func(body []byte) string {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err, "The body is not gzip")
+		decompressed, err := io.ReadAll(reader)
+		require.NoError(t, err, "Failed to decompress the body")
+		return string(decompressed)
+	}
+
+	// The HTML list page compresses
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get list page")
+	assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"), "Expected a gzip response")
+	assert.Equal(t, "Accept-Encoding", response.Header.Get("Vary"), "Expected Vary on Accept-Encoding")
+	assert.Contains(t, gunzip(body), "Example Website", "Wrong list page content")
+
+	// A JSON response compresses as well
+	req = httptest.NewRequest("GET", "/version", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get version")
+	assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"), "Expected a gzip response")
+	assert.Contains(t, gunzip(body), "version", "Wrong version content")
+
+	// A client without gzip support gets the plain bytes, but still Vary
+	response, body = testRequest(t, handler, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get list page")
+	assert.Empty(t, response.Header.Get("Content-Encoding"), "Expected no Content-Encoding without Accept-Encoding")
+	assert.Equal(t, "Accept-Encoding", response.Header.Get("Vary"), "Expected Vary on Accept-Encoding")
+	assert.Contains(t, string(body), "Example Website", "Wrong list page content")
+
+	// A PNG screenshot is already compressed and passes through untouched
+	filename := screenshotFilename("https://example.com/")
+	require.NoError(t, os.WriteFile(filepath.Join(screenshotsDir, filename), []byte("a screenshot"), 0644), "Failed to write screenshot")
+	req = httptest.NewRequest("GET", "/screenshots/"+filename, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	response, body = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get screenshot")
+	assert.Empty(t, response.Header.Get("Content-Encoding"), "Expected the screenshot uncompressed")
+	assert.Equal(t, "a screenshot", string(body), "Wrong screenshot content")
+}
+
+// TestRecoverPanic verifies that a panic in one handler is answered with a
+// 500 and that the server keeps serving requests afterwards.
+func TestRecoverPanic(t *testing.T) {
+	handlers := &Handlers{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "still here")
+	})
+	server := httptest.NewServer(handlers.recoverPanic(mux))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/panic")
+	require.NoError(t, err, "Expected a response, not a torn down connection")
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	assert.Equal(t, http.StatusInternalServerError, response.StatusCode, "Expected a 500 for the panic")
+
+	response, err = http.Get(server.URL + "/ok")
+	require.NoError(t, err, "Failed to reach the server after the panic")
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Expected the server to keep serving")
+}
+
+// TestLogRequest verifies that each request is logged with its method, path,
+// status, response size and duration, here through a JSON handler like the
+// one -log-format json installs.
+func TestLogRequest(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_log_request.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	var logged bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logged, nil)))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+	})
+
+	response, _ := testRequest(t, handler, httptest.NewRequest("GET", "/version", nil))
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get version")
+
+	var record struct {
+		Msg      string `json:"msg"`
+		Method   string `json:"method"`
+		Path     string `json:"path"`
+		Status   int    `json:"status"`
+		Size     int64  `json:"size"`
+		Duration int64  `json:"duration"`
+	}
+	require.NoError(t, json.Unmarshal(logged.Bytes(), &record), "The log line is not JSON")
+	assert.Equal(t, "request", record.Msg, "Wrong log message")
+	assert.Equal(t, "GET", record.Method, "Wrong method")
+	assert.Equal(t, "/version", record.Path, "Wrong path")
+	assert.Equal(t, http.StatusOK, record.Status, "Wrong status")
+	assert.Positive(t, record.Size, "Expected the response size to be recorded")
+
+	// An error status is recorded through the wrapped writer
+	logged.Reset()
+	response, _ = testRequest(t, handler, httptest.NewRequest("GET", "/nosuchlink", nil))
+	require.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected a client error")
+	require.NoError(t, json.Unmarshal(logged.Bytes(), &record), "The log line is not JSON")
+	assert.Equal(t, http.StatusBadRequest, record.Status, "Expected the error status in the log")
+}
+
+// TestAddRateLimit verifies that adds beyond AddRatePerMinute are answered
+// with a 429 and a Retry-After, per client IP, while other routes and other
+// clients are unaffected.
+func TestAddRateLimit(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_add_rate.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.AddRatePerMinute = 2
+	handler := handlers.Routes()
+
+	// Notes go through POST /{$} like links, without an outbound fetch
+	addNote := func(title, remoteAddr string) *http.Response {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("note-title="+title+"&note-text=text"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if remoteAddr != "" {
+			req.RemoteAddr = remoteAddr
+		}
+		response, _ := testRequest(t, handler, req)
+		return response
+	}
+
+	assert.Equal(t, http.StatusCreated, addNote("First", "").StatusCode, "Expected the first add to pass")
+	assert.Equal(t, http.StatusCreated, addNote("Second", "").StatusCode, "Expected the second add to pass")
+
+	// The third add within the minute is over the limit
+	response := addNote("Third", "")
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode, "Expected the third add to be rate limited")
+	retryAfter, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	require.NoError(t, err, "Expected a numeric Retry-After header")
+	assert.Positive(t, retryAfter, "Expected a wait until the next token")
+
+	// Another client IP has a bucket of its own
+	assert.Equal(t, http.StatusCreated, addNote("Fourth", "198.51.100.7:4242").StatusCode, "Expected another client to pass")
+
+	// Listing is not rate limited
+	response, _ = testRequest(t, handler, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Expected the listing to pass")
+}
+
+// TestHSTS verifies that the Strict-Transport-Security header is sent only
+// when configured and only on HTTPS requests.
+func TestHSTS(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_hsts.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.HSTSMaxAge = 180 * 24 * time.Hour
+	handler := handlers.Routes()
+
+	t.Run("not sent on plain HTTP", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Empty(t, response.Header.Get("Strict-Transport-Security"), "Expected no HSTS header on plain HTTP")
+	})
+
+	t.Run("sent on HTTPS", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, "max-age=15552000", response.Header.Get("Strict-Transport-Security"), "Wrong HSTS header on HTTPS")
+	})
+
+	t.Run("sent behind a proxy announcing HTTPS", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, "max-age=15552000", response.Header.Get("Strict-Transport-Security"), "Wrong HSTS header behind a proxy")
+	})
+
+	t.Run("includeSubDomains", func(t *testing.T) {
+		handlers.HSTSIncludeSubdomains = true
+		t.Cleanup(func() {
+			handlers.HSTSIncludeSubdomains = false
+		})
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, "max-age=15552000; includeSubDomains", response.Header.Get("Strict-Transport-Security"), "Wrong HSTS header with subdomains")
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		handlers.HSTSMaxAge = 0
+		t.Cleanup(func() {
+			handlers.HSTSMaxAge = 180 * 24 * time.Hour
+		})
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Empty(t, response.Header.Get("Strict-Transport-Security"), "Expected no HSTS header when not configured")
+	})
+}
+
+// TestRequestTimeout verifies that a request still running at the configured
+// deadline is answered with 503, that fast requests are unaffected and that
+// batch adds are exempt.
+func TestRequestTimeout(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_request_timeout.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.RequestTimeout = 100 * time.Millisecond
+	handler := handlers.Routes()
+
+	// The mock server answers slowly enough to outlive the deadline
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, "<html><head><title>Slow page</title></head><body></body></html>")
+	}))
+	defer mockServer.Close()
+
+	t.Run("fast request unaffected", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("slow request times out", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL+"/slow")))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, body := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "timed out", "Expected a timeout message")
+	})
+
+	t.Run("batch add exempt", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/batch-add", strings.NewReader(`["`+mockServer.URL+`/batch"]`))
+		req.Header.Set("Content-Type", "application/json")
+		response, body := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), `"created"`, "Expected the batch add to finish")
+	})
+}
+
+// TestMaxIndexBodyBytes verifies that a body beyond the configured limit is
+// truncated before indexing, while the title and description stay searchable.
+func TestMaxIndexBodyBytes(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_max_index_body.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.MaxIndexBodyBytes = 200
+	handler := handlers.Routes()
+
+	page := `<html><head><title>Big page</title></head><body>earlyword ` +
+		strings.Repeat("filler ", 100) + ` lateword</body></html>`
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, page)
+	}))
+	defer mockServer.Close()
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(mockServer.URL)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ := testRequest(t, handler, req)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+
+	links, err := database.Search(t.Context(), "body:earlyword")
+	require.NoError(t, err, "Failed to search")
+	assert.Len(t, links, 1, "Expected the start of the body to be indexed")
+
+	links, err = database.Search(t.Context(), "body:lateword")
+	require.NoError(t, err, "Failed to search")
+	assert.Empty(t, links, "Expected the body beyond the limit not to be indexed")
+
+	links, err = database.Search(t.Context(), "title:big")
+	require.NoError(t, err, "Failed to search")
+	assert.Len(t, links, 1, "Expected the title to be indexed in full")
+}
+
+// TestCompressedResponses verifies that gzip-, deflate- and brotli-encoded
+// responses are decompressed before extraction. The fetch sets its own
+// Accept-Encoding, so the transport's transparent gzip handling is off and
+// the decompression here is what keeps the HTML parseable.
+func TestCompressedResponses(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+
+	page := `<html><head><title>Compressed page</title></head><body>content</body></html>`
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			writer := gzip.NewWriter(w)
+			_, _ = writer.Write([]byte(page))
+			_ = writer.Close()
+		case "/deflate":
+			w.Header().Set("Content-Encoding", "deflate")
+			writer := zlib.NewWriter(w)
+			_, _ = writer.Write([]byte(page))
+			_ = writer.Close()
+		case "/br":
+			w.Header().Set("Content-Encoding", "br")
+			writer := brotli.NewWriter(w)
+			_, _ = writer.Write([]byte(page))
+			_ = writer.Close()
+		case "/bogus":
+			w.Header().Set("Content-Encoding", "zstd")
+			_, _ = fmt.Fprint(w, page)
+		}
+	}))
+	defer mockServer.Close()
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			pageURL, _ := url.Parse(mockServer.URL + "/" + encoding)
+			title, _, body, _, err := handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), pageURL)
+			require.NoError(t, err, "Failed to extract from a %s response", encoding)
+			assert.Equal(t, "Compressed page", title, "Wrong title from a %s response", encoding)
+			assert.Contains(t, string(body), "content", "Wrong body from a %s response", encoding)
+		})
+	}
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		pageURL, _ := url.Parse(mockServer.URL + "/bogus")
+		_, _, _, _, err := handlers.extractTitleAndDescriptionAndBodyFromURL(t.Context(), pageURL)
+		assert.ErrorContains(t, err, "unsupported content encoding", "Expected an unsupported encoding to be reported")
+	})
+}
+
+// Test_hostMatches exercises the pattern forms accepted in StripQueryHosts.
+func Test_hostMatches(t *testing.T) {
+	assert.True(t, hostMatches("example.com", "example.com"), "Exact match failed")
+	assert.False(t, hostMatches("example.com", "example.org"), "Different host matched")
+	assert.True(t, hostMatches("example.com", "*"), "Wildcard did not match")
+	assert.True(t, hostMatches("www.example.com", "*.example.com"), "Subdomain did not match")
+	assert.True(t, hostMatches("example.com", "*.example.com"), "Bare domain did not match its own wildcard")
+	assert.False(t, hostMatches("badexample.com", "*.example.com"), "Suffix without a dot matched")
+}
+
+func Test_screenshotSelectorFor(t *testing.T) {
+	handlers := &Handlers{}
+	assert.Empty(t, handlers.screenshotSelectorFor("example.com"), "Selector without configuration")
+
+	handlers.ScreenshotSelectors = []string{
+		"example.com=#main",
+		"*.blog.org=article",
+		"broken-entry",
+		"no-selector.net=",
+		"*=.content",
+	}
+	assert.Equal(t, "#main", handlers.screenshotSelectorFor("example.com"), "Exact host did not get its selector")
+	assert.Equal(t, "article", handlers.screenshotSelectorFor("www.blog.org"), "Subdomain did not get the wildcard selector")
+	assert.Equal(t, ".content", handlers.screenshotSelectorFor("other.com"), "Global pattern did not apply")
+	assert.Equal(t, ".content", handlers.screenshotSelectorFor("no-selector.net"), "Entry without a selector was not skipped")
+}
+
+// TestRetryTrailingSlash verifies that a fetch answered with 404 is retried
+// with a trailing slash appended when enabled, and that the slash-appended
+// URL is what gets saved.
+func TestRetryTrailingSlash(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_retry_slash.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	// The mock server only serves the slash-appended path
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, "<html><head><title>Slashed page</title></head><body></body></html>")
+	}))
+	defer mockServer.Close()
+
+	t.Run("no retry by default", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+mockServer.URL+"/page"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("retry rescues the add", func(t *testing.T) {
+		handlers.RetryTrailingSlash = true
+		t.Cleanup(func() {
+			handlers.RetryTrailingSlash = false
+		})
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+mockServer.URL+"/page"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Handlers returned wrong status code")
+
+		links, err := database.GetAllLinks(t.Context())
+		require.NoError(t, err, "Failed to get links")
+		require.Len(t, links, 1, "Wrong number of links")
+		assert.Equal(t, mockServer.URL+"/page/", links[0].URL, "Expected the slash-appended URL to be saved")
+		assert.Equal(t, "Slashed page", links[0].Title, "Wrong title")
+	})
+}
+
+// TestLinkSources verifies that each add path records its source, and that
+// links can be filtered by it.
+func TestLinkSources(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_sources.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	// One link through each add path
+	req := httptest.NewRequest("POST", "/", strings.NewReader("url="+mockServer.URL+"/form"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ := testRequest(t, handler, req)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add through the form")
+
+	req = httptest.NewRequest("GET", "/bookmarklet?url="+url.QueryEscape(mockServer.URL+"/bookmarklet"), nil)
+	response, _ = testRequest(t, handler, req)
+	require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add through the bookmarklet")
+
+	payload, err := json.Marshal([]string{mockServer.URL + "/batch"})
+	require.NoError(t, err, "Failed to marshal URLs")
+	req = httptest.NewRequest("POST", "/batch-add", bytes.NewReader(payload))
+	response, _ = testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Failed to add through the batch endpoint")
+
+	sources := make(map[string]string)
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	for _, link := range links {
+		sources[link.URL] = link.Source
+	}
+	assert.Equal(t, "web", sources[mockServer.URL+"/form"], "Wrong source for the form add")
+	assert.Equal(t, "bookmarklet", sources[mockServer.URL+"/bookmarklet"], "Wrong source for the bookmarklet add")
+	assert.Equal(t, "batch", sources[mockServer.URL+"/batch"], "Wrong source for the batch add")
+
+	// Filter by source
+	req = httptest.NewRequest("GET", "/?source=batch", nil)
+	req.Header.Set("Accept", "application/json")
+	response, body := testRequest(t, handler, req)
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+	var filtered []db.Link
+	require.NoError(t, json.Unmarshal(body, &filtered), "Response doesn't contain the expected JSON")
+	require.Len(t, filtered, 1, "Wrong number of filtered links")
+	assert.Equal(t, mockServer.URL+"/batch", filtered[0].URL, "Wrong link in the filtered list")
+}
+
+// Test_optimizeScreenshot verifies that re-encoding shrinks a loosely
+// compressed capture, and that input which is not a PNG is left alone.
+func Test_optimizeScreenshot(t *testing.T) {
+	// A capture stand-in: a flat image encoded without compression, like the
+	// loosely compressed PNGs chromedp hands over.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	raw := new(bytes.Buffer)
+	encoder := png.Encoder{CompressionLevel: png.NoCompression}
+	require.NoError(t, encoder.Encode(raw, img), "Failed to encode sample image")
+
+	optimized := optimizeScreenshot(raw.Bytes())
+	assert.Less(t, len(optimized), raw.Len(), "Optimized screenshot is not smaller than the raw capture")
+
+	_, err := png.Decode(bytes.NewReader(optimized))
+	assert.NoError(t, err, "Optimized screenshot is not a valid PNG")
+
+	notPng := []byte("not a PNG")
+	assert.Equal(t, notPng, optimizeScreenshot(notPng), "Input which is not a PNG was not returned unchanged")
+}
+
+// TestOpenSearch verifies that the OpenSearch description document is
+// well-formed XML with a search template pointing back at the server.
+func TestOpenSearch(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_opensearch.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	t.Run("origin derived from the request", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/opensearch.xml", nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.True(t, strings.HasPrefix(response.Header.Get("Content-Type"), "application/opensearchdescription+xml"), "Wrong Content-Type: %s", response.Header.Get("Content-Type"))
+
+		var document struct {
+			ShortName string `xml:"ShortName"`
+			URL       struct {
+				Template string `xml:"template,attr"`
+			} `xml:"Url"`
+		}
+		err := xml.Unmarshal(body, &document)
+		require.NoError(t, err, "Response is not well-formed XML")
+		assert.Equal(t, "MyLinks", document.ShortName, "Wrong short name")
+		assert.Equal(t, "http://example.com/?s={searchTerms}", document.URL.Template, "Wrong search template URL")
+	})
+
+	t.Run("configured origin", func(t *testing.T) {
+		handlers.ServerOrigin = "https://links.example.org"
+		t.Cleanup(func() {
+			handlers.ServerOrigin = ""
+		})
+
+		req := httptest.NewRequest("GET", "/opensearch.xml", nil)
+		_, body := testRequest(t, handler, req)
+
+		assert.Contains(t, string(body), `template="https://links.example.org/?s={searchTerms}"`, "Response doesn't use the configured origin")
+	})
+}
+
+// TestOpenAPI verifies that the hand-maintained OpenAPI document is valid
+// JSON and describes the core endpoints.
+func TestOpenAPI(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_openapi.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	response, body := testRequest(t, handler, req)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"), "Wrong Content-Type")
+
+	var document struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	err = json.Unmarshal(body, &document)
+	require.NoError(t, err, "Response is not valid JSON")
+	assert.True(t, strings.HasPrefix(document.OpenAPI, "3."), "Not an OpenAPI 3 document: %s", document.OpenAPI)
+	for _, path := range []string{"/", "/{id}", "/{id}/position", "/batch-add", "/admin/errors", "/oembed"} {
+		assert.Contains(t, document.Paths, path, "Path %s is not documented", path)
+	}
+}
+
+// TestBatchAddAsync verifies that an async batch add returns an id right
+// away, that its progress can be polled until it reaches the full count and
+// that the links end up saved.
+func TestBatchAddAsync(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_batch_async.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.BatchAddConcurrency = 2
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	urls := []string{mockServer.URL + "/one", mockServer.URL + "/two", mockServer.URL + "/three"}
+	payload, err := json.Marshal(urls)
+	require.NoError(t, err, "Failed to marshal URLs")
+
+	req := httptest.NewRequest("POST", "/batch-add?async=true", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusAccepted, response.StatusCode, "Handlers returned wrong status code")
+
+	var started struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(body, &started), "Response doesn't contain the expected JSON")
+	require.NotEmpty(t, started.ID, "Expected a batch id")
+	assert.Equal(t, "/batch-add/"+started.ID, response.Header.Get("Location"), "Wrong Location header")
+
+	var progress batchProgress
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest("GET", "/batch-add/"+started.ID, nil)
+		response, body := testRequest(t, handler, req)
+		if response.StatusCode != http.StatusOK {
+			return false
+		}
+		require.NoError(t, json.Unmarshal(body, &progress), "Status is not valid JSON")
+		return progress.Results != nil
+	}, 5*time.Second, 10*time.Millisecond, "The batch did not finish in time")
+
+	assert.Equal(t, len(urls), progress.Total, "Wrong total in the progress")
+	assert.Equal(t, progress.Total, progress.Done, "Expected progress to reach the full count")
+	require.Len(t, progress.Results, len(urls), "Wrong number of results")
+	for i, result := range progress.Results {
+		assert.Equal(t, "created", result.Status, "Expected URL %d to be created", i)
+	}
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Len(t, links, len(urls), "Expected every URL of the batch to be saved")
+
+	t.Run("unknown batch", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/batch-add/12345", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+}
+
+// TestReindexBody verifies that re-indexing fetches the page body of a link
+// saved without one and makes it searchable, without touching the title or
+// description, and that the batch variant covers every body-less link.
+func TestReindexBody(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_reindex.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Reindexed</title></head><body>flibbertigibbet%s</body></html>", strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer mockServer.Close()
+
+	// Saved without bodies, as links predating body indexing
+	oneID, err := database.AddLink(t.Context(), mockServer.URL+"/one", "Old link one", "Kept description", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	_, err = database.AddLink(t.Context(), mockServer.URL+"/two", "Old link two", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	links, err := database.Search(t.Context(), "flibbertigibbetone")
+	require.NoError(t, err, "Failed to search")
+	require.Empty(t, links, "Expected the body term not to be searchable before the reindex")
+
+	t.Run("single link", func(t *testing.T) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/%d/reindex", oneID), nil)
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusNoContent, response.StatusCode, "Handlers returned wrong status code")
+
+		links, err := database.Search(t.Context(), "flibbertigibbetone")
+		require.NoError(t, err, "Failed to search")
+		require.Len(t, links, 1, "Expected the body term to be searchable after the reindex")
+		assert.Equal(t, "Old link one", links[0].Title, "Expected the title to be untouched")
+		assert.Equal(t, "Kept description", links[0].Description, "Expected the description to be untouched")
+	})
+
+	t.Run("batch skips links with bodies", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/reindex", nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		var result ReindexBodyResult
+		require.NoError(t, json.Unmarshal(body, &result), "Response doesn't contain the expected JSON")
+		assert.Equal(t, 1, result.Reindexed, "Expected only the remaining body-less link to be reindexed")
+		assert.Zero(t, result.Failed, "Expected no failures")
+
+		links, err := database.Search(t.Context(), "flibbertigibbettwo")
+		require.NoError(t, err, "Failed to search")
+		require.Len(t, links, 1, "Expected the second body term to be searchable after the batch reindex")
+	})
+
+	t.Run("missing link", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/9999/reindex", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+}
+
+// TestReprocess verifies that reprocessing re-fetches pre-existing links and
+// fills in just the requested fields, only where they are missing.
+func TestReprocess(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_reprocess.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, "<html><head><title>Reprocessed</title>"+
+			"<meta name='description' content='Fetched description'>"+
+			"<meta property='article:published_time' content='2024-05-06T07:08:09Z'>"+
+			"</head><body>Some body</body></html>")
+	}))
+	defer mockServer.Close()
+
+	// Saved before the published time was extracted, one without a description
+	bareID, err := database.AddLink(t.Context(), mockServer.URL+"/bare", "Bare link", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	describedID, err := database.AddLink(t.Context(), mockServer.URL+"/described", "Described link", "Kept description", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	t.Run("fills only missing fields", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/reprocess?fields=published_at,description", nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		var result ReprocessResult
+		require.NoError(t, json.Unmarshal(body, &result), "Response doesn't contain the expected JSON")
+		assert.Equal(t, 2, result.Reprocessed, "Expected both links to be reprocessed")
+		assert.Zero(t, result.Failed, "Expected no failures")
+
+		bare, err := database.GetLink(t.Context(), bareID)
+		require.NoError(t, err, "Failed to get link")
+		require.NotNil(t, bare.PublishedAt, "Expected the published time to be filled in")
+		assert.Equal(t, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), bare.PublishedAt.UTC(), "Wrong published time")
+		assert.Equal(t, "Fetched description", bare.Description, "Expected the empty description to be filled in")
+
+		described, err := database.GetLink(t.Context(), describedID)
+		require.NoError(t, err, "Failed to get link")
+		assert.NotNil(t, described.PublishedAt, "Expected the published time to be filled in")
+		assert.Equal(t, "Kept description", described.Description, "Expected the existing description to be untouched")
+	})
+
+	t.Run("links with the fields are skipped", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/reprocess?fields=published_at", nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		var result ReprocessResult
+		require.NoError(t, json.Unmarshal(body, &result), "Response doesn't contain the expected JSON")
+		assert.Zero(t, result.Reprocessed, "Expected nothing left to reprocess")
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/reprocess?fields=word_count", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("no fields", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/reprocess", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	})
+}
+
+// TestBatchAddDryRun verifies that a dry-run batch add reports what would
+// happen for each URL without writing anything to the database.
+func TestBatchAddDryRun(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_batch_dryrun.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	knownID, err := database.AddLink(t.Context(), "https://example.com/known", "Known", "", "", nil)
+	require.NoError(t, err, "Failed to add existing link")
+
+	urls := []string{"https://example.com/known", "https://example.com/new", "not-a-url"}
+	payload, err := json.Marshal(urls)
+	require.NoError(t, err, "Failed to marshal URLs")
+
+	req := httptest.NewRequest("POST", "/batch-add?dryrun=true", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+	var results []BatchAddResult
+	require.NoError(t, json.Unmarshal(body, &results), "Response doesn't contain the expected JSON")
+	require.Len(t, results, len(urls), "Wrong number of results")
+	assert.Equal(t, "duplicate", results[0].Status, "Expected the known URL to be a duplicate")
+	assert.Equal(t, knownID, results[0].ID, "Expected the existing link's id for the duplicate")
+	assert.Equal(t, "created", results[1].Status, "Expected the new URL to be reported as would-be created")
+	assert.Equal(t, "failed", results[2].Status, "Expected the bad URL to fail")
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Len(t, links, 1, "Expected a dry run to write nothing")
+}
+
+// TestCheckURL verifies that the check endpoint reports whether a URL is
+// reachable and HTML without saving anything, and that checks are rate
+// limited.
+func TestCheckURL(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_check_url.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, "<html><head><title>A page</title></head><body></body></html>")
+		case "/data":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, "{}")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+
+	check := func(t *testing.T, urlString string) (*http.Response, checkResult) {
+		req := httptest.NewRequest("GET", "/check?url="+url.QueryEscape(urlString), nil)
+		response, body := testRequest(t, handler, req)
+		var result checkResult
+		if response.StatusCode == http.StatusOK {
+			require.NoError(t, json.Unmarshal(body, &result), "Response doesn't contain the expected JSON")
+		}
+		return response, result
+	}
+
+	t.Run("reachable HTML page", func(t *testing.T) {
+		response, result := check(t, mockServer.URL+"/page")
+		require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.True(t, result.Reachable, "Expected the page to be reachable")
+		assert.Equal(t, http.StatusOK, result.Status, "Wrong status in the result")
+		assert.Equal(t, "text/html; charset=utf-8", result.ContentType, "Wrong content type in the result")
+		assert.True(t, result.IsHTML, "Expected the page to be HTML")
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		response, _ := check(t, mockServer.URL+"/page")
+		assert.Equal(t, http.StatusTooManyRequests, response.StatusCode, "Expected an immediate second check to be rejected")
+	})
+
+	t.Run("reachable but not HTML", func(t *testing.T) {
+		time.Sleep(checkMinInterval + 100*time.Millisecond)
+		response, result := check(t, mockServer.URL+"/data")
+		require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.True(t, result.Reachable, "Expected the URL to be reachable")
+		assert.False(t, result.IsHTML, "Expected the URL not to be HTML")
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		time.Sleep(checkMinInterval + 100*time.Millisecond)
+		response, result := check(t, "http://127.0.0.1:1/")
+		require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.False(t, result.Reachable, "Expected the URL to be unreachable")
+		assert.Zero(t, result.Status, "Expected no status for an unreachable URL")
+	})
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Empty(t, links, "Expected no links to be saved by checks")
+}
+
+// TestJsonFieldNames verifies that links marshal to JSON with snake_case
+// field names and RFC3339 times, the shape of API version 2.0.
+func TestJsonFieldNames(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_json_fields.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	id, err := database.AddLink(t.Context(), "https://example.com", "Example Website", "An example", "web", nil)
+	require.NoError(t, err, "Failed to add link")
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/%d", id), nil)
+	req.Header.Set("Accept", "application/json")
+	response, body := testRequest(t, handler, req)
+	require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(body, &fields), "Response is not valid JSON")
+	for _, name := range []string{"id", "url", "title", "description", "added_at", "expires_at", "source", "position", "published_at"} {
+		assert.Contains(t, fields, name, "Missing field %s", name)
+	}
+	assert.NotContains(t, fields, "URL", "Expected no Go-named fields")
+	addedAt, ok := fields["added_at"].(string)
+	require.True(t, ok, "added_at is not a string")
+	_, err = time.Parse(time.RFC3339, addedAt)
+	assert.NoError(t, err, "added_at is not RFC3339: %s", addedAt)
+}
+
+// TestSaveOnFetchError verifies that a page answering 403 fails the add in
+// the default strict mode, but is saved with a title from the URL and a
+// marker description when SaveOnFetchError is set.
+func TestSaveOnFetchError(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_save_on_fetch_error.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, "<html><head><title>Login required</title></head><body>Forbidden</body></html>")
+	}))
+	defer mockServer.Close()
+
+	t.Run("strict mode rejects", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+mockServer.URL+"/article"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "403", "Response doesn't mention the HTTP status")
+	})
+
+	t.Run("lenient mode saves", func(t *testing.T) {
+		handlers.SaveOnFetchError = true
+		t.Cleanup(func() {
+			handlers.SaveOnFetchError = false
+		})
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+mockServer.URL+"/article"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Handlers returned wrong status code")
+
+		links, err := database.GetAllLinks(t.Context())
+		require.NoError(t, err, "Failed to get links")
+		require.Len(t, links, 1, "Wrong number of links")
+		assert.Equal(t, "article", links[0].Title, "Expected the title to come from the URL")
+		assert.Equal(t, "(fetch failed: HTTP 403)", links[0].Description, "Expected the fetch failure marker")
+	})
+}
+
+// TestFetchErrors verifies that a failed add records a fetch error which can
+// be retrieved from the admin errors page.
+func TestFetchErrors(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_fetch_errors.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	t.Run("no errors recorded", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/errors", nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "No fetch errors recorded", "Response doesn't report an empty list")
+	})
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer mockServer.Close()
+
+	t.Run("failed add records an error", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+mockServer.URL))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode, "Expected the add to fail")
+
+		req = httptest.NewRequest("GET", "/admin/errors", nil)
+		req.Header.Set("Accept", "application/json")
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+		var fetchErrors []db.FetchError
+		err := json.Unmarshal(body, &fetchErrors)
+		require.NoError(t, err, "Response doesn't contain the expected JSON")
+		require.Len(t, fetchErrors, 1, "Wrong number of fetch errors")
+		assert.Equal(t, mockServer.URL, fetchErrors[0].URL, "Wrong URL in fetch error")
+		assert.Contains(t, fetchErrors[0].Error, "404", "Fetch error doesn't mention the HTTP status")
+		assert.WithinDuration(t, time.Now(), fetchErrors[0].At, time.Minute, "Wrong time in fetch error")
+	})
+}
+
+// TestBackup verifies that the backup download is a valid SQLite database
+// containing the links.
+func TestBackup(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_backup.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	_, err = database.AddLink(t.Context(), "https://example.com", "Example Website", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	req := httptest.NewRequest("GET", "/admin/backup", nil)
+	response, body := testRequest(t, handler, req)
+
+	require.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	assert.Equal(t, "application/vnd.sqlite3", response.Header.Get("Content-Type"), "Wrong Content-Type")
+	assert.Contains(t, response.Header.Get("Content-Disposition"), "attachment", "Expected the backup as an attachment")
+
+	backupFile := filepath.Join(t.TempDir(), "backup.database")
+	require.NoError(t, os.WriteFile(backupFile, body, 0600), "Failed to write backup file")
+
+	backup, err := db.InitDB(backupFile)
+	require.NoError(t, err, "The backup is not a valid database")
+	t.Cleanup(func() {
+		_ = backup.Close()
+	})
+	links, err := backup.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links from the backup")
+	require.Len(t, links, 1, "Wrong number of links in the backup")
+	assert.Equal(t, "Example Website", links[0].Title, "Wrong link in the backup")
+}
+
+// TestRestore verifies that a good backup is staged and applied when the
+// database is next opened, and that a malformed upload or a missing
+// confirmation is rejected.
+func TestRestore(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_restore.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.DatabaseFile = dbFile
+	handler := handlers.Routes()
+
+	_, err = database.AddLink(t.Context(), "https://example.com/old", "Old", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	backupFile := filepath.Join(t.TempDir(), "backup.database")
+	backupDB, err := db.InitDB(backupFile)
+	require.NoError(t, err, "Failed to initialize backup database")
+	_, err = backupDB.AddLink(t.Context(), "https://example.com/restored", "Restored", "", "", nil)
+	require.NoError(t, err, "Failed to add link to backup")
+	require.NoError(t, backupDB.Close(), "Failed to close backup database")
+	backupBytes, err := os.ReadFile(backupFile)
+	require.NoError(t, err, "Failed to read backup file")
+
+	restoreRequest := func(t *testing.T, confirm string, backup []byte) (*http.Response, []byte) {
+		buf := new(bytes.Buffer)
+		form := multipart.NewWriter(buf)
+		require.NoError(t, form.WriteField("confirm", confirm), "Failed to write confirm field")
+		if backup != nil {
+			part, err := form.CreateFormFile("backup", "backup.sqlite")
+			require.NoError(t, err, "Failed to create file part")
+			_, err = part.Write(backup)
+			require.NoError(t, err, "Failed to write file part")
+		}
+		require.NoError(t, form.Close(), "Failed to finish the form")
+
+		req := httptest.NewRequest("POST", "/admin/restore", buf)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return testRequest(t, handler, req)
+	}
+
+	t.Run("missing confirmation", func(t *testing.T) {
+		response, body := restoreRequest(t, "", backupBytes)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "confirm", "Response doesn't mention the confirmation")
+		assert.NoFileExists(t, dbFile+".restore", "No restore should be staged")
+	})
+
+	t.Run("malformed backup", func(t *testing.T) {
+		response, body := restoreRequest(t, "replace all data", []byte("this is not a database"))
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "Not a usable backup", "Response doesn't report the bad upload")
+		assert.NoFileExists(t, dbFile+".restore", "No restore should be staged")
+	})
+
+	t.Run("good backup is staged and applied", func(t *testing.T) {
+		response, _ := restoreRequest(t, "replace all data", backupBytes)
+		require.Equal(t, http.StatusAccepted, response.StatusCode, "Handlers returned wrong status code")
+		require.FileExists(t, dbFile+".restore", "Expected the restore to be staged")
+
+		// The links are untouched until the next start.
+		links, err := database.GetAllLinks(t.Context())
+		require.NoError(t, err, "Failed to get links")
+		require.Len(t, links, 1, "Wrong number of links before the restart")
+		assert.Equal(t, "Old", links[0].Title, "The restore should not apply while the server runs")
+
+		require.NoError(t, database.Close(), "Failed to close database")
+		restored, err := db.InitDB(dbFile)
+		require.NoError(t, err, "Failed to reopen database")
+		t.Cleanup(func() {
+			_ = restored.Close()
+		})
+		links, err = restored.GetAllLinks(t.Context())
+		require.NoError(t, err, "Failed to get links after the restore")
+		require.Len(t, links, 1, "Wrong number of links after the restore")
+		assert.Equal(t, "Restored", links[0].Title, "Expected the restored link")
+		assert.NoFileExists(t, dbFile+".restore", "The staged file should be consumed")
+	})
+}
+
+// TestShareLink verifies that a link can be shared through a token, viewed
+// read-only at /share/{token}, and made private again by revoking the token.
+func TestShareLink(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_share.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	id, err := database.AddLink(t.Context(), "https://example.com", "Shared Website", "A shared description", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	var share shareResult
+	t.Run("create share token", func(t *testing.T) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/%d/share", id), nil)
+		response, body := testRequest(t, handler, req)
+
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Handlers returned wrong status code")
+		require.NoError(t, json.Unmarshal(body, &share), "Response doesn't contain the expected JSON")
+		require.NotEmpty(t, share.Token, "Expected a token")
+		assert.Equal(t, "/share/"+share.Token, share.Path, "Wrong path")
+
+		req = httptest.NewRequest("POST", fmt.Sprintf("/%d/share", id), nil)
+		response, body = testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Handlers returned wrong status code")
+		var again shareResult
+		require.NoError(t, json.Unmarshal(body, &again), "Response doesn't contain the expected JSON")
+		assert.Equal(t, share.Token, again.Token, "Sharing again should return the same token")
+	})
+
+	t.Run("view through token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", share.Path, nil)
+		response, body := testRequest(t, handler, req)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+		assert.Contains(t, string(body), "Shared Website", "Response doesn't contain the link's title")
+		assert.Contains(t, string(body), "A shared description", "Response doesn't contain the link's description")
+		assert.NotContains(t, string(body), "Delete", "The shared view should be read-only")
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/share/no-such-token", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("share of a missing link", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/9999/share", nil)
+		response, _ := testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Handlers returned wrong status code")
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/%d/share", id), nil)
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusNoContent, response.StatusCode, "Handlers returned wrong status code")
+
+		req = httptest.NewRequest("GET", share.Path, nil)
+		response, _ = testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "A revoked token should no longer work")
+
+		req = httptest.NewRequest("DELETE", fmt.Sprintf("/%d/share", id), nil)
+		response, _ = testRequest(t, handler, req)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, "Revoking again should report no token")
+	})
+}
+
+// TestWriteContext verifies that a database write is not abandoned when the
+// client goes away, but is still bounded by a deadline.
+func TestWriteContext(t *testing.T) {
+	requestContext, clientGoesAway := context.WithCancel(t.Context())
+
+	ctx, cancel := writeContext(requestContext)
+	defer cancel()
+
+	clientGoesAway()
+
+	assert.NoError(t, ctx.Err(), "The write was abandoned when the client went away")
+	deadline, hasDeadline := ctx.Deadline()
+	assert.True(t, hasDeadline, "The write is not bounded by a deadline")
+	assert.False(t, deadline.After(time.Now().Add(databaseWriteTimeout)), "Deadline too far away")
+}
+
+// TestEditLinkRefetchesMissingBody verifies that editing a link which has no
+// body stored fetches the page again, so that the body stays searchable, and
+// that a link which has one is left alone.
+func TestEditLinkRefetchesMissingBody(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_refetch.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	var fetches atomic.Int64
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><head><title>Title</title></head><body>Some indescribable body text</body></html>")
+	}))
+	defer mockServer.Close()
+
+	// A link as added before bodies were stored: no body to carry through an edit.
+	id, err := database.AddLink(t.Context(), mockServer.URL, "Old title", "Old description", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	patch := func(t *testing.T, id int64, form string) *http.Response {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%d", id), strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		return response
+	}
+
+	response := patch(t, id, "title=New title&description=New description")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	assert.Equal(t, int64(1), fetches.Load(), "Expected the page to be fetched again")
+
+	hasBody, err := database.HasBody(t.Context(), id)
+	require.NoError(t, err, "Failed to check for a body")
+	assert.True(t, hasBody, "Expected the refetched body to be stored")
+
+	links, err := database.Search(t.Context(), "indescribable")
+	require.NoError(t, err, "Failed to search")
+	assert.Len(t, links, 1, "Got %d links, expected the refetched body to be searchable", len(links))
+
+	// A second edit has a body to carry forward, and must not fetch again.
+	response = patch(t, id, "title=Newer title&description=Newer description")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	assert.Equal(t, int64(1), fetches.Load(), "Expected no fetch for a link which has a body")
+
+	links, err = database.Search(t.Context(), "indescribable")
+	require.NoError(t, err, "Failed to search")
+	assert.Len(t, links, 1, "Got %d links, expected the body to be carried over", len(links))
+
+	// A note has no page to fetch, its text is its body.
+	noteId, err := database.AddLink(t.Context(), "note:1", "Note title", "Old note text", "", nil)
+	require.NoError(t, err, "Failed to add note")
+
+	response = patch(t, noteId, "title=Note title&description=An unmistakable note")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+	assert.Equal(t, int64(1), fetches.Load(), "Expected no fetch for a note")
+
+	links, err = database.Search(t.Context(), "unmistakable")
+	require.NoError(t, err, "Failed to search")
+	assert.Len(t, links, 1, "Got %d links, expected the note text to be searchable", len(links))
+
+	// A note which has a body is re-indexed from its text as well, rather than
+	// keeping the text it no longer holds.
+	storedNoteId, err := database.AddLink(t.Context(), "note:2", "Stored note", "An inimitable note", "", []byte("An inimitable note"))
+	require.NoError(t, err, "Failed to add note")
+
+	response = patch(t, storedNoteId, "title=Stored note&description=A rewritten note")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+	links, err = database.Search(t.Context(), "rewritten")
+	require.NoError(t, err, "Failed to search")
+	assert.Len(t, links, 1, "Got %d links, expected the new note text to be searchable", len(links))
+	links, err = database.Search(t.Context(), "inimitable")
+	require.NoError(t, err, "Failed to search")
+	assert.Empty(t, links, "Expected the replaced note text to be gone from the index")
+
+	// Emptying a note must not leave its previous text searchable either.
+	response = patch(t, storedNoteId, "title=Stored note&description=")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Handlers returned wrong status code")
+
+	links, err = database.Search(t.Context(), "rewritten")
+	require.NoError(t, err, "Failed to search")
+	assert.Empty(t, links, "Expected the emptied note text to be gone from the index")
+
+	// A page which can no longer be fetched must not fail the edit.
+	goneServer := httptest.NewServer(http.NotFoundHandler())
+	goneURL := goneServer.URL
+	goneServer.Close()
+	goneId, err := database.AddLink(t.Context(), goneURL, "Gone title", "Gone description", "", nil)
+	require.NoError(t, err, "Failed to add link")
+
+	response = patch(t, goneId, "title=Still edited&description=Gone description")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "An unreachable page failed the edit")
+
+	link, err := database.GetLink(t.Context(), goneId)
+	require.NoError(t, err, "Failed to get link")
+	assert.Equal(t, "Still edited", link.Title, "The edit was not applied")
+	hasBody, err = database.HasBody(t.Context(), goneId)
+	require.NoError(t, err, "Failed to check for a body")
+	assert.False(t, hasBody, "Expected no body for an unreachable page")
+}
+
+// TestAddLinkAbandonedByClient verifies that the page is not fetched for a
+// request which the client has already given up on.
+func TestAddLinkAbandonedByClient(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_abandoned.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handler := newHandlers("../../..", database, "", true).Routes()
+
+	var fetched bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><head><title>Title</title></head><body>Body</body></html>")
+	}))
+	defer mockServer.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, "POST", "/", strings.NewReader("url="+mockServer.URL))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, _ := testRequest(t, handler, req)
+
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode, "Handlers returned wrong status code")
+	assert.False(t, fetched, "The URL was fetched for a request the client had given up on")
+
+	links, err := database.GetAllLinks(t.Context())
+	require.NoError(t, err, "Failed to get links")
+	assert.Empty(t, links, "Got %d links, expected none", len(links))
+}
+
+// TestStoreOriginalURL verifies that the URL as submitted is kept as metadata
+// when canonicalization changes it, and not stored when nothing changed.
+func TestStoreOriginalURL(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_original_url.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, "", true)
+	handlers.StoreOriginalURL = true
+	handlers.StripFragments = true
+	handler := handlers.Routes()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body></body></html>", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	add := func(urlString string) int64 {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("url="+url.QueryEscape(urlString)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response, _ := testRequest(t, handler, req)
+		require.Equal(t, http.StatusCreated, response.StatusCode, "Failed to add link")
+		id, err := strconv.ParseInt(strings.TrimPrefix(response.Header.Get("Location"), "/"), 10, 64)
+		require.NoError(t, err, "No link id in the Location header")
+		return id
+	}
+
+	t.Run("changed URL keeps the original", func(t *testing.T) {
+		id := add(mockServer.URL + "/page#section")
+
+		link, err := database.GetLink(t.Context(), id)
+		require.NoError(t, err, "Failed to get link")
+		assert.Equal(t, mockServer.URL+"/page", link.URL, "Expected the canonical URL to be stored")
+		assert.Equal(t, mockServer.URL+"/page#section", link.Meta["original_url"], "Expected the submitted URL in the metadata")
+
+		// The single-link view links to the original as well
+		req := httptest.NewRequest("GET", fmt.Sprintf("/%d", id), nil)
+		response, body := testRequest(t, handler, req)
+		require.Equal(t, http.StatusOK, response.StatusCode, "Failed to get link view")
+		assert.Contains(t, string(body), "as submitted:", "Expected the view to show the original URL")
+	})
+
+	t.Run("unchanged URL stores nothing extra", func(t *testing.T) {
+		id := add(mockServer.URL + "/plain")
+
+		link, err := database.GetLink(t.Context(), id)
+		require.NoError(t, err, "Failed to get link")
+		assert.NotContains(t, link.Meta, "original_url", "Expected no original URL when canonicalization changed nothing")
+	})
+}
+
+// TestRenderFailure verifies that a template which fails to render logs the
+// template name and the data type, bumps the failure counter and sends the
+// client only the generic 500 text.
+func TestRenderFailure(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	before := renderFailures.Value()
+	rec := httptest.NewRecorder()
+	// share.html needs a Title field, an empty struct cannot render it
+	handlers.render(rec, "share.html", struct{}{}, http.StatusOK)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code, "Expected a render failure to become a 500")
+	assert.NotContains(t, rec.Body.String(), "share.html", "Expected the client not to see the details")
+	assert.Contains(t, logged.String(), "share.html", "Expected the log to name the failing template")
+	assert.Contains(t, logged.String(), "struct {}", "Expected the log to include the data type")
+	assert.Equal(t, before+1, renderFailures.Value(), "Expected the failure counter to be bumped")
+}
+
+// TestBlockSchemeDowngrade exercises the redirect policy directly against the
+// fetch client's CheckRedirect, since the mock servers cannot serve trusted
+// https.
+func TestBlockSchemeDowngrade(t *testing.T) {
+	handlers := newHandlers("../../..", nil, "", true)
+
+	redirect := func(from, to string) error {
+		fromReq := httptest.NewRequest("GET", from, nil)
+		toReq := httptest.NewRequest("GET", to, nil)
+		return handlers.client.CheckRedirect(toReq, []*http.Request{fromReq})
+	}
+
+	t.Run("downgrade followed by default", func(t *testing.T) {
+		assert.NoError(t, redirect("https://example.com/", "http://example.com/"))
+	})
+
+	t.Run("downgrade blocked when enabled", func(t *testing.T) {
+		handlers.BlockSchemeDowngrade = true
+		t.Cleanup(func() { handlers.BlockSchemeDowngrade = false })
+
+		err := redirect("https://example.com/", "http://example.com/")
+		require.Error(t, err, "expected the https to http redirect to be refused")
+		assert.Contains(t, err.Error(), "plain http")
+	})
+
+	t.Run("upgrade always followed", func(t *testing.T) {
+		handlers.BlockSchemeDowngrade = true
+		t.Cleanup(func() { handlers.BlockSchemeDowngrade = false })
+
+		assert.NoError(t, redirect("http://example.com/", "https://example.com/"))
+		assert.NoError(t, redirect("https://example.com/", "https://example.com/other"))
+	})
+}
+
+// BenchmarkReadBody measures reading a response much larger than the body
+// limit, once with the remainder drained for connection reuse and once with
+// the connection abandoned at the cap.
+func BenchmarkReadBody(b *testing.B) {
+	large := bytes.Repeat([]byte("x"), maxBodyLength+8*1024*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write(large)
+	}))
+	defer server.Close()
+
+	for name, maxDrain := range map[string]int64{"drain": int64(len(large)), "close": -1} {
+		b.Run(name, func(b *testing.B) {
+			handlers := &Handlers{MaxDrainBytes: maxDrain}
+			client := server.Client()
+			for b.Loop() {
+				resp, err := client.Get(server.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := handlers.readBody(resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
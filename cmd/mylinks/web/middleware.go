@@ -0,0 +1,281 @@
+package web
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commonHeaders sets the security headers on every response. When HSTS is
+// configured and the request arrived over HTTPS, Strict-Transport-Security is
+// set as well; plain HTTP responses never carry it, so a development server
+// cannot lock a browser onto HTTPS.
+func (h *Handlers) commonHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy",
+			"default-src 'self'; form-action 'self'; base-uri 'self'; frame-ancestors 'none'")
+		w.Header().Set("Referrer-Policy", "same-origin")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "deny")
+
+		if h.HSTSMaxAge > 0 && requestIsHTTPS(r) {
+			value := fmt.Sprintf("max-age=%d", int64(h.HSTSMaxAge.Seconds()))
+			if h.HSTSIncludeSubdomains {
+				value += "; includeSubDomains"
+			}
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// staticCache sets the configured Cache-Control policy on static file
+// responses, so that browsers don't revalidate the assets on every page.
+func (h *Handlers) staticCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.StaticCacheMaxAge > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(h.StaticCacheMaxAge.Seconds())))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// screenshotCache sets a year-long Cache-Control policy and an ETag on
+// screenshot responses, so that the list view does not re-download its many
+// images on every page load. The ETag follows the file, so a re-captured
+// screenshot revalidates to the new image; http.ServeContent answers a
+// matching If-None-Match with a 304 by itself. A missing file passes through
+// untouched, so its 404 is not cached.
+func (h *Handlers) screenshotCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.FromSlash(path.Clean("/" + r.URL.Path))
+		if info, err := os.Stat(filepath.Join(h.screenshotsDir, name)); err == nil && info.Mode().IsRegular() {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().Unix(), info.Size()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestTimeout bounds each request with http.TimeoutHandler when
+// RequestTimeout is set: a handler still running at the deadline has its
+// context cancelled and the client gets a 503. Batch adds are exempt, they
+// fetch many pages and bound their own concurrency instead.
+func (h *Handlers) requestTimeout(next http.Handler) http.Handler {
+	if h.RequestTimeout <= 0 {
+		return next
+	}
+	timed := http.TimeoutHandler(next, h.RequestTimeout, "The request timed out")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/batch-add" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timed.ServeHTTP(w, r)
+	})
+}
+
+// gzipResponseWriter compresses a response through gzip when the handler
+// commits to a compressible content type. The decision waits until
+// WriteHeader, when the Content-Type is known: HTML and JSON compress well,
+// while the PNG screenshots are already compressed and would only grow.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		// A response without a body must not grow a gzip header from
+		// closing an empty compressor.
+		bodiless := status == http.StatusNoContent || status == http.StatusNotModified || status < 200
+		contentType := mediaTypeOf(w.Header().Get("Content-Type"))
+		if !bodiless && (contentType == "text/html" || contentType == "application/json") {
+			w.Header().Set("Content-Encoding", "gzip")
+			// The length of the uncompressed body no longer applies.
+			w.Header().Del("Content-Length")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// gzipResponse compresses HTML and JSON responses for clients which accept
+// gzip. Vary is set either way, so a cache in front does not serve a
+// compressed response to a client which did not ask for one.
+func (h *Handlers) gzipResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gzw := &gzipResponseWriter{ResponseWriter: w}
+		defer func() {
+			if gzw.gz != nil {
+				_ = gzw.gz.Close()
+			}
+		}()
+		next.ServeHTTP(gzw, r)
+	})
+}
+
+// addRateSweepInterval is how often addRateLimit drops idle buckets, so the
+// map does not grow with every IP ever seen.
+const addRateSweepInterval = 10 * time.Minute
+
+// addBucket is the token-bucket state of one client IP for addRateLimit.
+type addBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// addRateLimit bounds how often one client IP can add links, with a token
+// bucket per IP refilling at AddRatePerMinute and holding at most a minute's
+// worth. Over the limit the client gets a 429 with a Retry-After saying when
+// the next token is due.
+func (h *Handlers) addRateLimit(next http.Handler) http.Handler {
+	if h.AddRatePerMinute <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if wait := h.takeAddToken(ip); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			sendError(w, "Too many adds, try again later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeAddToken takes a token from the bucket of one client IP. It returns
+// zero when a token was available, otherwise how long until the next one
+// refills.
+func (h *Handlers) takeAddToken(ip string) time.Duration {
+	rate := float64(h.AddRatePerMinute) / float64(time.Minute)
+	now := time.Now()
+
+	h.addRateMu.Lock()
+	defer h.addRateMu.Unlock()
+	if h.addBuckets == nil {
+		h.addBuckets = make(map[string]*addBucket)
+		h.addRateSweep = now
+	}
+	if now.Sub(h.addRateSweep) > addRateSweepInterval {
+		h.addRateSweep = now
+		// A bucket idle for a minute has refilled completely, so its next
+		// request starts the same with or without it.
+		for idle, bucket := range h.addBuckets {
+			if now.Sub(bucket.last) > time.Minute {
+				delete(h.addBuckets, idle)
+			}
+		}
+	}
+	bucket, ok := h.addBuckets[ip]
+	if !ok {
+		bucket = &addBucket{tokens: float64(h.AddRatePerMinute)}
+		h.addBuckets[ip] = bucket
+	} else {
+		bucket.tokens = min(bucket.tokens+float64(now.Sub(bucket.last))*rate, float64(h.AddRatePerMinute))
+	}
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / rate)
+}
+
+// recoverPanic turns a panic in a handler into a 500 response with the stack
+// logged, instead of net/http tearing down the connection without a
+// response. http.ErrAbortHandler is re-panicked, it is how a handler aborts
+// a response on purpose.
+func (h *Handlers) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				// sendError logs the message with the stack, which still
+				// holds the panicking frames here in the deferred call.
+				sendError(w, fmt.Sprintf("panic serving %s %s: %v", r.Method, r.URL.Path, recovered), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code and
+// response size for the request log. A handler which writes without calling
+// WriteHeader implies a 200, which is what the recorder starts at.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(p)
+	rec.size += int64(n)
+	return n, err
+}
+
+// logRequest logs one structured line per request: method, path, status,
+// response size and duration. It logs through slog, whose handler main picks
+// with -log-format, so production deployments can get each request as a JSON
+// record.
+func (h *Handlers) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"size", rec.size,
+			"duration", time.Since(start))
+	})
+}
+
+// requestIsHTTPS reports whether a request arrived over HTTPS, either
+// terminated here or at a proxy in front announcing it with
+// X-Forwarded-Proto.
+func requestIsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
@@ -0,0 +1,699 @@
+package web
+
+import "net/http"
+
+// openAPIDocument is a hand-maintained OpenAPI 3 description of the JSON API,
+// served at /openapi.json. Keep it in step with Routes and the handlers: it
+// is the only machine-readable description of the API there is.
+//
+// Endpoints which only render HTML (the bookmarklet, the admin page) are
+// described too, but without response schemas. JSON responses are produced
+// when the request carries "Accept: application/json".
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "MyLinks",
+    "description": "Save, search and manage web links. Endpoints return HTML for browsers and JSON when requested with an Accept: application/json header. Version 2.0 renamed the JSON fields of links and fetch errors from Go field names to snake_case.",
+    "version": "2.0"
+  },
+  "components": {
+    "securitySchemes": {
+      "basicAuth": {
+        "type": "http",
+        "scheme": "basic",
+        "description": "Only enforced when the server runs with -basic-auth-file."
+      }
+    },
+    "schemas": {
+      "Link": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer", "format": "int64"},
+          "url": {"type": "string"},
+          "title": {"type": "string"},
+          "description": {"type": "string"},
+          "added_at": {"type": "string", "format": "date-time"},
+          "expires_at": {"type": "string", "format": "date-time", "nullable": true},
+          "source": {"type": "string"},
+          "position": {"type": "integer", "format": "int64", "nullable": true},
+          "meta": {"type": "object", "additionalProperties": {"type": "string"}, "description": "Arbitrary key/value metadata, only on single-link responses"},
+          "published_at": {"type": "string", "format": "date-time", "nullable": true},
+          "fetch_ms": {"type": "integer", "format": "int64", "nullable": true, "description": "How long the metadata fetch took in milliseconds, only recorded when enabled"},
+          "tags": {"type": "array", "items": {"type": "string"}, "description": "The link's tags in alphabetical order, omitted when it has none"},
+          "read": {"type": "boolean", "description": "Whether the link is marked as read"},
+          "last_check_status": {"type": "integer", "format": "int64", "nullable": true, "description": "The HTTP status of the last dead-link check, 0 for a connection failure, omitted for a link never checked"},
+          "last_check_at": {"type": "string", "format": "date-time", "nullable": true, "description": "When the dead-link checker last tried the URL, omitted for a link never checked"}
+        }
+      },
+      "BatchAddResult": {
+        "type": "object",
+        "properties": {
+          "url": {"type": "string"},
+          "status": {"type": "string", "enum": ["created", "duplicate", "failed"]},
+          "id": {"type": "integer", "format": "int64"},
+          "error": {"type": "string"}
+        }
+      }
+    }
+  },
+  "security": [{"basicAuth": []}],
+  "paths": {
+    "/": {
+      "get": {
+        "summary": "List or search links",
+        "parameters": [
+          {"name": "s", "in": "query", "schema": {"type": "string"}, "description": "Search string, optionally with a field qualifier like title: or body:"},
+          {"name": "limit", "in": "query", "schema": {"type": "integer"}, "description": "Maximum number of search results, overrides the configured limit"},
+          {"name": "source", "in": "query", "schema": {"type": "string"}, "description": "Only links recorded from this add path"},
+          {"name": "expired", "in": "query", "schema": {"type": "string", "enum": ["true"]}, "description": "Only expired links"},
+          {"name": "tag", "in": "query", "schema": {"type": "string"}, "description": "Only links carrying this tag"},
+          {"name": "unread", "in": "query", "schema": {"type": "string", "enum": ["1"]}, "description": "Only links not yet marked as read"},
+          {"name": "status", "in": "query", "schema": {"type": "string", "enum": ["dead"]}, "description": "Only links whose last dead-link check got a 4xx or 5xx status, or failed to connect"},
+          {"name": "page", "in": "query", "schema": {"type": "integer", "default": 1}, "description": "Page of the listing to return, a page past the end is empty"},
+          {"name": "per_page", "in": "query", "schema": {"type": "integer", "default": 50}, "description": "Links per page"},
+          {"name": "sort", "in": "query", "schema": {"type": "string", "enum": ["published", "date_desc", "date_asc", "title_asc", "title_desc"]}, "description": "Order of the listing: by the page's published time, by when it was saved in either direction, or by title"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The links, truncated searches carry an X-Results-Truncated: true header",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Link"}}}}
+          }
+        }
+      },
+      "post": {
+        "summary": "Add a link or a note",
+        "requestBody": {
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "url": {"type": "string", "description": "URL to fetch and save, leave out to add a note instead"},
+                  "expires_at": {"type": "string", "description": "Optional expiry time, e.g. 2026-12-31T12:00"},
+                  "meta": {"type": "string", "description": "JSON object of string keys and values to store as metadata"},
+                  "tags": {"type": "string", "description": "Comma-separated tags, normalized to lowercase"},
+                  "note-title": {"type": "string"},
+                  "note-text": {"type": "string"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {"description": "Created, the Location header holds the new link's path"},
+          "400": {"description": "Invalid URL or note, or the page could not be fetched"},
+          "409": {"description": "The URL, or with -dedupe-titles the title, already exists; for a URL duplicate the Location header and message point at the existing link"},
+          "429": {"description": "The client IP exceeded the -add-rate limit, Retry-After says when the next add is allowed"}
+        }
+      }
+    },
+    "/batch-add": {
+      "post": {
+        "summary": "Add several links at once",
+        "parameters": [
+          {"name": "dryrun", "in": "query", "schema": {"type": "string", "enum": ["true"]}, "description": "Preview the outcomes without fetching or saving anything"},
+          {"name": "async", "in": "query", "schema": {"type": "string", "enum": ["true"]}, "description": "Run the batch in the background; the response holds an id to poll at /batch-add/{id}"}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}
+        },
+        "responses": {
+          "200": {
+            "description": "Per-URL outcomes, in request order",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/BatchAddResult"}}}}
+          }
+        }
+      }
+    },
+    "/batch-delete": {
+      "post": {
+        "summary": "Move several links to the trash at once",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "array", "items": {"type": "integer", "format": "int64"}}}}
+        },
+        "responses": {
+          "200": {
+            "description": "How many links were deleted; ids without a link are skipped",
+            "content": {"application/json": {"schema": {"type": "object", "properties": {"deleted": {"type": "integer"}}}}}
+          }
+        }
+      }
+    },
+    "/batch-add/{batch}": {
+      "parameters": [{"name": "batch", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Poll the progress of a background batch add",
+        "responses": {
+          "200": {
+            "description": "How much of the batch is done; results is present once it has finished",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "total": {"type": "integer"},
+                    "done": {"type": "integer"},
+                    "results": {"type": "array", "items": {"$ref": "#/components/schemas/BatchAddResult"}}
+                  }
+                }
+              }
+            }
+          },
+          "404": {"description": "No such batch"}
+        }
+      }
+    },
+    "/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "get": {
+        "summary": "Get a single link",
+        "responses": {
+          "200": {"description": "The link", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Link"}}}},
+          "404": {"description": "No such link"}
+        }
+      },
+      "patch": {
+        "summary": "Edit a link's URL, title, description and expiry",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "description": "Only the fields present are changed",
+                "properties": {
+                  "url": {"type": "string", "description": "New URL, canonicalized like an add"},
+                  "title": {"type": "string", "description": "May not be empty when present"},
+                  "description": {"type": "string"},
+                  "expires_at": {"type": "string", "description": "New expiry time, empty clears it, absent leaves it alone"},
+                  "meta": {"type": "string", "description": "JSON object of metadata keys to set, an empty value removes the key, other keys are kept"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {"description": "The updated link", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Link"}}}},
+          "404": {"description": "No such link"},
+          "409": {"description": "The new URL belongs to another link"}
+        }
+      },
+      "delete": {
+        "summary": "Move a link to the trash",
+        "responses": {
+          "200": {"description": "Moved to the trash"},
+          "404": {"description": "No such link, or already in the trash"}
+        }
+      }
+    },
+    "/trash": {
+      "get": {
+        "summary": "List the links in the trash, most recently deleted first",
+        "responses": {
+          "200": {
+            "description": "The trashed links",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Link"}}}}
+          }
+        }
+      },
+      "delete": {
+        "summary": "Permanently remove every link in the trash, with their screenshots",
+        "responses": {
+          "200": {
+            "description": "How many links were removed",
+            "content": {
+              "application/json": {
+                "schema": {"type": "object", "properties": {"purged": {"type": "integer"}}}
+              }
+            }
+          }
+        }
+      }
+    },
+    "/{id}/restore": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "post": {
+        "summary": "Bring a link back from the trash",
+        "responses": {
+          "204": {"description": "Restored"},
+          "404": {"description": "The link is not in the trash"}
+        }
+      }
+    },
+    "/{id}/position": {
+      "post": {
+        "summary": "Set the manual position of a link",
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+        "requestBody": {
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {"position": {"type": "integer", "description": "Empty returns the link to date ordering"}}
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {"description": "Position set"},
+          "404": {"description": "No such link"}
+        }
+      }
+    },
+    "/{id}/read": {
+      "post": {
+        "summary": "Mark a link as read or unread",
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {"read": {"type": "boolean", "description": "The new read state"}},
+                "required": ["read"]
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {"description": "Read state set"},
+          "404": {"description": "No such link"}
+        }
+      }
+    },
+    "/{id}/share": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "post": {
+        "summary": "Share a link publicly through a token",
+        "responses": {
+          "201": {
+            "description": "The share token and the public path, existing token if the link is already shared",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "token": {"type": "string"},
+                    "path": {"type": "string"}
+                  }
+                }
+              }
+            }
+          },
+          "404": {"description": "No such link"}
+        }
+      },
+      "delete": {
+        "summary": "Revoke a link's share token",
+        "responses": {
+          "204": {"description": "Revoked"},
+          "404": {"description": "The link has no share token"}
+        }
+      }
+    },
+    "/share/{token}": {
+      "get": {
+        "summary": "View a shared link, without authentication",
+        "security": [],
+        "parameters": [{"name": "token", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "A read-only HTML view of the link"},
+          "404": {"description": "Unknown or revoked token"}
+        }
+      }
+    },
+    "/{id}/reindex": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "post": {
+        "summary": "Re-fetch a link's page and re-index just its body",
+        "responses": {
+          "204": {"description": "Body reindexed"},
+          "400": {"description": "The page could not be fetched"},
+          "404": {"description": "No such link"}
+        }
+      }
+    },
+    "/{id}/screenshot": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "post": {
+        "summary": "Re-capture a link's screenshot, overwriting the stored image",
+        "responses": {
+          "200": {
+            "description": "The file name of the new screenshot",
+            "content": {
+              "application/json": {
+                "schema": {"type": "object", "properties": {"filename": {"type": "string"}}}
+              }
+            }
+          },
+          "400": {"description": "A note, or no longer a valid URL"},
+          "404": {"description": "No such link"},
+          "502": {"description": "The capture failed"},
+          "503": {"description": "Screenshots are not available"}
+        }
+      }
+    },
+    "/favicon/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "get": {
+        "summary": "The favicon of a link's site, as fetched when the link was added",
+        "responses": {
+          "200": {
+            "description": "The favicon, with the content type it was served with",
+            "content": {"image/*": {"schema": {"type": "string", "format": "binary"}}}
+          },
+          "404": {"description": "No such link, or no favicon could be fetched for it"}
+        }
+      }
+    },
+    "/content/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "get": {
+        "summary": "The archived text of a link's page, with when it was captured",
+        "responses": {
+          "200": {
+            "description": "The saved page text, readable even if the original site is gone",
+            "content": {"text/html": {}}
+          },
+          "404": {"description": "No such link, or no body was stored for it"}
+        }
+      }
+    },
+    "/reindex": {
+      "post": {
+        "summary": "Re-fetch and re-index the body of every link which has none stored",
+        "responses": {
+          "200": {
+            "description": "How many links were reindexed and how many failed",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "reindexed": {"type": "integer"},
+                    "failed": {"type": "integer"}
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/errors": {
+      "get": {
+        "summary": "List recent fetch failures",
+        "responses": {
+          "200": {
+            "description": "The recorded fetch errors, newest first",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "array",
+                  "items": {
+                    "type": "object",
+                    "properties": {
+                      "url": {"type": "string"},
+                      "error": {"type": "string"},
+                      "at": {"type": "string", "format": "date-time"}
+                    }
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/admin/backup": {
+      "get": {
+        "summary": "Download a consistent snapshot of the database",
+        "responses": {
+          "200": {
+            "description": "The SQLite database file, as an attachment",
+            "content": {"application/vnd.sqlite3": {"schema": {"type": "string", "format": "binary"}}}
+          }
+        }
+      }
+    },
+    "/admin/restore": {
+      "post": {
+        "summary": "Stage an uploaded backup to replace the database at the next restart",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "type": "object",
+                "required": ["backup", "confirm"],
+                "properties": {
+                  "backup": {"type": "string", "format": "binary", "description": "A SQLite backup, as produced by /admin/backup"},
+                  "confirm": {"type": "string", "description": "Must be exactly 'replace all data'"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "202": {"description": "Staged, the restore is applied at the next restart"},
+          "400": {"description": "Missing confirmation or not a usable backup"}
+        }
+      }
+    },
+    "/admin/reprocess": {
+      "post": {
+        "summary": "Re-fetch links to fill in fields missing from when they were saved",
+        "parameters": [
+          {"name": "fields", "in": "query", "required": true, "schema": {"type": "string"}, "description": "Comma-separated fields to fill, from: published_at, description"}
+        ],
+        "responses": {
+          "200": {
+            "description": "How many links were reprocessed and how many failed",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "reprocessed": {"type": "integer"},
+                    "failed": {"type": "integer"}
+                  }
+                }
+              }
+            }
+          },
+          "400": {"description": "No fields given, or an unknown field"}
+        }
+      }
+    },
+    "/oembed": {
+      "get": {
+        "summary": "Get an oEmbed response for a saved link",
+        "parameters": [{"name": "url", "in": "query", "required": true, "schema": {"type": "string"}, "description": "The link's path, /{id}, optionally with the server origin in front"}],
+        "responses": {
+          "200": {"description": "An oEmbed link response"},
+          "404": {"description": "No such link"}
+        }
+      }
+    },
+    "/check": {
+      "get": {
+        "summary": "Check whether a URL is reachable and HTML, without saving it",
+        "parameters": [{"name": "url", "in": "query", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {
+            "description": "The check result; an unreachable URL has reachable false and no status",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "reachable": {"type": "boolean"},
+                    "status": {"type": "integer"},
+                    "contentType": {"type": "string"},
+                    "isHTML": {"type": "boolean"}
+                  }
+                }
+              }
+            }
+          },
+          "400": {"description": "Invalid URL"},
+          "429": {"description": "Checks are rate limited to one per second"}
+        }
+      }
+    },
+    "/version": {
+      "get": {
+        "summary": "Get the running build's version information",
+        "responses": {
+          "200": {
+            "description": "The version, commit, commit date and Go version of the build",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "version": {"type": "string"},
+                    "commit": {"type": "string"},
+                    "date": {"type": "string"},
+                    "go": {"type": "string"}
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/stats": {
+      "get": {
+        "summary": "Counts summarizing the collection, for dashboard widgets",
+        "responses": {
+          "200": {
+            "description": "Link totals, recent activity, distinct hosts and the most used tags",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "total": {"type": "integer"},
+                    "added_last_7_days": {"type": "integer"},
+                    "added_last_30_days": {"type": "integer"},
+                    "distinct_hosts": {"type": "integer", "description": "How many different hosts the saved URLs point at, notes not counted"},
+                    "top_tags": {"type": "array", "items": {"type": "object", "properties": {"name": {"type": "string"}, "count": {"type": "integer"}}}, "description": "The most used tags, most used first, omitted when nothing is tagged"}
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/feed.atom": {
+      "get": {
+        "summary": "The most recently added links as an Atom feed",
+        "responses": {
+          "200": {
+            "description": "An Atom 1.0 feed of the newest links",
+            "content": {"application/atom+xml": {"schema": {"type": "string"}}}
+          }
+        }
+      }
+    },
+    "/export": {
+      "get": {
+        "summary": "Export all links as a Netscape bookmark file",
+        "responses": {
+          "200": {
+            "description": "The links as a bookmark file browsers can import, served as an attachment",
+            "content": {"text/html": {"schema": {"type": "string"}}}
+          }
+        }
+      }
+    },
+    "/import": {
+      "post": {
+        "summary": "Import links from an uploaded Netscape bookmark file",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "type": "object",
+                "required": ["bookmarks"],
+                "properties": {
+                  "bookmarks": {"type": "string", "format": "binary", "description": "A bookmark file, as produced by /export or a browser"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "How many entries were imported, skipped as already saved, and failed",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "imported": {"type": "integer"},
+                    "skipped": {"type": "integer"},
+                    "failed": {"type": "integer"}
+                  }
+                }
+              }
+            }
+          },
+          "400": {"description": "Missing or unparsable bookmark file"}
+        }
+      }
+    },
+    "/export.csv": {
+      "get": {
+        "summary": "Export all links as CSV with url, title, description, added_at and tags columns",
+        "responses": {
+          "200": {
+            "description": "The links as CSV, dates in RFC3339 and tags comma-joined, served as an attachment",
+            "content": {"text/csv": {"schema": {"type": "string"}}}
+          }
+        }
+      }
+    },
+    "/import.csv": {
+      "post": {
+        "summary": "Import links from an uploaded CSV file in the /export.csv format",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "type": "object",
+                "required": ["csv"],
+                "properties": {
+                  "csv": {"type": "string", "format": "binary", "description": "A CSV file, as produced by /export.csv; only the url column is required"}
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "How many rows were imported, skipped as already saved, and failed",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "imported": {"type": "integer"},
+                    "skipped": {"type": "integer"},
+                    "failed": {"type": "integer"}
+                  }
+                }
+              }
+            }
+          },
+          "400": {"description": "Missing or unparsable CSV file"}
+        }
+      }
+    },
+    "/bookmarklet": {
+      "get": {
+        "summary": "Save a link from the bookmarklet popup",
+        "parameters": [{"name": "url", "in": "query", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "201": {"description": "Saved, as an HTML result page"},
+          "400": {"description": "Invalid URL or fetch failure, as an HTML result page"}
+        }
+      }
+    }
+  }
+}
+`
+
+// OpenAPI serves the OpenAPI description of the API.
+func (h *Handlers) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(openAPIDocument))
+}
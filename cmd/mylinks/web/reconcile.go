@@ -0,0 +1,94 @@
+package web
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+)
+
+// ReconcileScreenshots brings the screenshots directory back in sync with the
+// links table after a crash between deleting a link row and its screenshot
+// file, or the other way around. It runs once at startup and does nothing
+// when screenshots are disabled.
+func (h *Handlers) ReconcileScreenshots(ctx context.Context) {
+	if h.browserContext == nil || h.screenshotsDir == "" {
+		return
+	}
+	h.reconcileScreenshots(ctx)
+}
+
+// reconcileScreenshots removes screenshot files with no corresponding link
+// and logs links whose screenshot file is missing. Expired links still have
+// rows, so their screenshots are kept; notes never have one and are skipped.
+// With ScreenshotsByID it first renames files left over from the hash naming
+// scheme, so an existing collection carries over.
+func (h *Handlers) reconcileScreenshots(ctx context.Context) {
+	refs, err := h.database.GetAllLinkRefs(ctx)
+	if err != nil {
+		log.Printf("Screenshot reconciliation: failed to get links: %v", err)
+		return
+	}
+	if h.ScreenshotsByID {
+		h.migrateScreenshotNames(refs)
+	}
+	expected := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		if isNote(ref.URL) {
+			continue
+		}
+		expected[h.screenshotFile(ref.ID, ref.URL)] = ref.URL
+	}
+
+	entries, err := os.ReadDir(h.screenshotsDir)
+	if err != nil {
+		log.Printf("Screenshot reconciliation: failed to read %s: %v", h.screenshotsDir, err)
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".png") {
+			continue
+		}
+		if _, ok := expected[name]; ok {
+			delete(expected, name)
+			continue
+		}
+		if err := os.Remove(filepath.Join(h.screenshotsDir, name)); err != nil {
+			log.Printf("Screenshot reconciliation: failed to remove orphaned %s: %v", name, err)
+		} else {
+			log.Printf("Screenshot reconciliation: removed orphaned %s", name)
+		}
+	}
+
+	for _, urlString := range expected {
+		log.Printf("Screenshot reconciliation: %s has no screenshot file", urlString)
+	}
+}
+
+// migrateScreenshotNames renames each link's screenshot from the URL-hash
+// name to the {id}.png name, for a collection captured before ScreenshotsByID
+// was enabled. An id-named file already in place wins over a hash-named one.
+func (h *Handlers) migrateScreenshotNames(refs []db.LinkRef) {
+	for _, ref := range refs {
+		if isNote(ref.URL) {
+			continue
+		}
+		hashed := filepath.Join(h.screenshotsDir, screenshotFilename(ref.URL))
+		if _, err := os.Stat(hashed); err != nil {
+			continue
+		}
+		byID := filepath.Join(h.screenshotsDir, h.screenshotFile(ref.ID, ref.URL))
+		if _, err := os.Stat(byID); err == nil {
+			continue
+		}
+		if err := os.Rename(hashed, byID); err != nil {
+			log.Printf("Screenshot reconciliation: failed to rename %s to %s: %v", hashed, byID, err)
+		} else {
+			log.Printf("Screenshot reconciliation: renamed the screenshot of %s to %s", ref.URL, filepath.Base(byID))
+		}
+	}
+}
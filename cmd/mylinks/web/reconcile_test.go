@@ -0,0 +1,45 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReconcileScreenshots verifies that a screenshot file without a link is
+// removed at reconciliation, while screenshots of existing links and files
+// that are not screenshots are left alone.
+func TestReconcileScreenshots(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_reconcile.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+
+	keptURL := "https://kept.example.com/"
+	missingURL := "https://missing.example.com/"
+	for _, urlString := range []string{keptURL, missingURL} {
+		_, err := database.AddLink(t.Context(), urlString, "Title", "", "", nil)
+		require.NoError(t, err, "Failed to add link")
+	}
+
+	keptPath := filepath.Join(screenshotsDir, screenshotFilename(keptURL))
+	require.NoError(t, os.WriteFile(keptPath, []byte("kept"), 0644), "Failed to write screenshot")
+	orphanPath := filepath.Join(screenshotsDir, screenshotFilename("https://deleted.example.com/"))
+	require.NoError(t, os.WriteFile(orphanPath, []byte("orphan"), 0644), "Failed to write screenshot")
+	otherPath := filepath.Join(screenshotsDir, "notes.txt")
+	require.NoError(t, os.WriteFile(otherPath, []byte("unrelated"), 0644), "Failed to write file")
+
+	handlers.reconcileScreenshots(t.Context())
+
+	assert.NoFileExists(t, orphanPath, "Expected the orphaned screenshot to be removed")
+	assert.FileExists(t, keptPath, "Expected the existing link's screenshot to be kept")
+	assert.FileExists(t, otherPath, "Expected a non-screenshot file to be left alone")
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+)
+
+// screenshotRefreshInterval is how often the background refresher scans for
+// stale screenshots. Each scan is delayed by a random jitter of up to a tenth
+// of the interval, so several instances sharing a browser don't scan in
+// lockstep.
+const screenshotRefreshInterval = time.Hour
+
+// screenshotRefreshWorkers limits how many re-captures run at once, to keep
+// the browser responsive for interactive adds.
+const screenshotRefreshWorkers = 2
+
+// StartScreenshotRefresher launches a background goroutine which periodically
+// re-captures screenshots older than ScreenshotMaxAge. It does nothing when
+// screenshots are disabled or no maximum age is configured. The goroutine
+// stops when ctx is cancelled.
+func (h *Handlers) StartScreenshotRefresher(ctx context.Context) {
+	if h.ScreenshotMaxAge <= 0 || h.browserContext == nil {
+		return
+	}
+	log.Printf("Refreshing screenshots older than %v", h.ScreenshotMaxAge)
+	go func() {
+		for {
+			jitter := time.Duration(rand.Int64N(int64(screenshotRefreshInterval / 10)))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(screenshotRefreshInterval + jitter):
+			}
+			h.refreshStaleScreenshots(ctx)
+		}
+	}()
+}
+
+// refreshStaleScreenshots re-captures the screenshot of every link whose
+// screenshot file is older than ScreenshotMaxAge, with at most
+// screenshotRefreshWorkers captures in flight. Links without a screenshot
+// file, such as notes, are left alone.
+func (h *Handlers) refreshStaleScreenshots(ctx context.Context) {
+	if h.screenshotsUnavailable.Load() {
+		return
+	}
+	links, err := h.database.GetAllLinks(ctx)
+	if err != nil {
+		log.Printf("Screenshot refresh: failed to get links: %v", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-h.ScreenshotMaxAge)
+	semaphore := make(chan struct{}, screenshotRefreshWorkers)
+	var wg sync.WaitGroup
+	for _, link := range links {
+		info, err := os.Stat(filepath.Join(h.screenshotsDir, h.screenshotFile(link.ID, link.URL)))
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			h.refreshScreenshot(link)
+		}()
+	}
+	wg.Wait()
+}
+
+// refreshScreenshot re-captures and stores the screenshot of one link.
+// Failures are logged only: the stale screenshot stays until the next scan.
+func (h *Handlers) refreshScreenshot(link db.Link) {
+	parsedURL, err := url.Parse(link.URL)
+	if err != nil {
+		log.Printf("Screenshot refresh: invalid URL %s: %v", link.URL, err)
+		return
+	}
+	screenshot, err := h.captureScreenshot(parsedURL)
+	if err != nil {
+		log.Printf("Screenshot refresh: failed to capture %s: %v", link.URL, err)
+		return
+	}
+	if screenshot == nil {
+		return
+	}
+	if h.OptimizeScreenshots {
+		screenshot = optimizeScreenshot(screenshot)
+	}
+	if err := h.saveScreenshot(link.ID, link.URL, screenshot); err != nil {
+		log.Printf("Screenshot refresh: disabling screenshots, failed to save %s: %v", link.URL, err)
+		h.screenshotsUnavailable.Store(true)
+	}
+}
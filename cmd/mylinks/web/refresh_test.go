@@ -0,0 +1,58 @@
+package web
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRefreshStaleScreenshots verifies that only screenshots older than the
+// configured maximum age are re-captured, using a stubbed capture.
+func TestRefreshStaleScreenshots(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_refresh.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+	handlers.ScreenshotMaxAge = 24 * time.Hour
+
+	var captured []string
+	handlers.captureScreenshot = func(u *url.URL) ([]byte, error) {
+		captured = append(captured, u.String())
+		return []byte("fresh"), nil
+	}
+
+	staleURL := "https://stale.example.com/"
+	freshURL := "https://fresh.example.com/"
+	bareURL := "https://bare.example.com/"
+	for _, urlString := range []string{staleURL, freshURL, bareURL} {
+		_, err := database.AddLink(t.Context(), urlString, "Title", "", "", nil)
+		require.NoError(t, err, "Failed to add link")
+	}
+
+	stalePath := filepath.Join(screenshotsDir, screenshotFilename(staleURL))
+	require.NoError(t, os.WriteFile(stalePath, []byte("old"), 0644), "Failed to write screenshot")
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(stalePath, old, old), "Failed to age screenshot")
+	freshPath := filepath.Join(screenshotsDir, screenshotFilename(freshURL))
+	require.NoError(t, os.WriteFile(freshPath, []byte("recent"), 0644), "Failed to write screenshot")
+
+	handlers.refreshStaleScreenshots(t.Context())
+
+	assert.Equal(t, []string{staleURL}, captured, "Expected only the stale screenshot to be re-captured")
+	content, err := os.ReadFile(stalePath)
+	require.NoError(t, err, "Failed to read screenshot")
+	assert.Equal(t, "fresh", string(content), "Expected the stale screenshot to be replaced")
+	content, err = os.ReadFile(freshPath)
+	require.NoError(t, err, "Failed to read screenshot")
+	assert.Equal(t, "recent", string(content), "Expected the fresh screenshot to be left alone")
+}
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+)
+
+// screenshotRetryInterval is how often the background retrier scans for
+// failed captures whose backoff has passed.
+const screenshotRetryInterval = 10 * time.Minute
+
+// screenshotRetryMaxAttempts is how many times one capture is tried in total
+// before the retrier gives up on it.
+const screenshotRetryMaxAttempts = 5
+
+// screenshotRetryBackoff is how long to wait after the first failed attempt;
+// each further failure doubles the wait.
+const screenshotRetryBackoff = 15 * time.Minute
+
+// screenshotRetriesPending is how many failed captures still wait for a
+// retry, published as an expvar so that monitoring can watch for captures
+// failing persistently.
+var screenshotRetriesPending = expvar.NewInt("screenshot_retries_pending")
+
+// StartScreenshotRetrier launches a background goroutine which periodically
+// re-captures screenshots whose capture failed, waiting longer after each
+// failed attempt and giving up after screenshotRetryMaxAttempts. It does
+// nothing when screenshots are disabled. The goroutine stops when ctx is
+// cancelled.
+func (h *Handlers) StartScreenshotRetrier(ctx context.Context) {
+	if h.browserContext == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(screenshotRetryInterval):
+			}
+			h.retryFailedScreenshots(ctx)
+		}
+	}()
+}
+
+// retryBackoff is how long to wait after the given number of failed
+// attempts, doubling with each one.
+func retryBackoff(attempts int) time.Duration {
+	return screenshotRetryBackoff << (attempts - 1)
+}
+
+// retryFailedScreenshots re-captures every failed screenshot whose backoff
+// has passed, and updates the pending gauge.
+func (h *Handlers) retryFailedScreenshots(ctx context.Context) {
+	if h.screenshotsUnavailable.Load() {
+		return
+	}
+	retries, err := h.database.GetScreenshotRetries(ctx)
+	if err != nil {
+		log.Printf("Screenshot retry: failed to get retries: %v", err)
+		return
+	}
+
+	for _, retry := range retries {
+		if retry.Attempts >= screenshotRetryMaxAttempts {
+			continue
+		}
+		if time.Since(retry.LastAttempt) < retryBackoff(retry.Attempts) {
+			continue
+		}
+		h.retryScreenshot(ctx, retry)
+	}
+
+	pending, err := h.database.CountScreenshotRetries(ctx, screenshotRetryMaxAttempts)
+	if err != nil {
+		log.Printf("Screenshot retry: failed to count retries: %v", err)
+		return
+	}
+	screenshotRetriesPending.Set(int64(pending))
+}
+
+// retryScreenshot re-captures and stores the screenshot of one failed link.
+// Another failure counts the attempt, pushing the next try further out.
+func (h *Handlers) retryScreenshot(ctx context.Context, retry db.ScreenshotRetry) {
+	parsedURL, err := url.Parse(retry.URL)
+	if err != nil {
+		log.Printf("Screenshot retry: invalid URL %s: %v", retry.URL, err)
+		return
+	}
+	screenshot, err := h.captureScreenshot(parsedURL)
+	if err != nil || screenshot == nil {
+		log.Printf("Screenshot retry %d/%d for %s failed: %v", retry.Attempts+1, screenshotRetryMaxAttempts, retry.URL, err)
+		if err := h.database.RecordScreenshotFailure(ctx, retry.LinkID); err != nil {
+			log.Printf("Screenshot retry: failed to record the failure for link %d: %v", retry.LinkID, err)
+		}
+		return
+	}
+	if h.OptimizeScreenshots {
+		screenshot = optimizeScreenshot(screenshot)
+	}
+	if err := h.saveScreenshot(retry.LinkID, retry.URL, screenshot); err != nil {
+		log.Printf("Screenshot retry: disabling screenshots, failed to save %s: %v", retry.URL, err)
+		h.screenshotsUnavailable.Store(true)
+		return
+	}
+	if err := h.database.ClearScreenshotFailure(ctx, retry.LinkID); err != nil {
+		log.Printf("Screenshot retry: failed to clear the failure for link %d: %v", retry.LinkID, err)
+	}
+}
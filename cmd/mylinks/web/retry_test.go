@@ -0,0 +1,105 @@
+package web
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mikaelstaldal/mylinks/cmd/mylinks/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRetryFailedScreenshots verifies that a previously-failed screenshot is
+// re-captured once its backoff has passed, using a stubbed capture, and that
+// recent failures and exhausted ones are left alone.
+func TestRetryFailedScreenshots(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_retry.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	screenshotsDir := t.TempDir()
+	handlers := newHandlers("../../..", database, screenshotsDir, true)
+
+	var captured []string
+	handlers.captureScreenshot = func(u *url.URL) ([]byte, error) {
+		captured = append(captured, u.String())
+		return []byte("retried"), nil
+	}
+
+	dueURL := "https://due.example.com/"
+	recentURL := "https://recent.example.com/"
+	exhaustedURL := "https://exhausted.example.com/"
+	ids := map[string]int64{}
+	for _, urlString := range []string{dueURL, recentURL, exhaustedURL} {
+		id, err := database.AddLink(t.Context(), urlString, "Title", "", "", nil)
+		require.NoError(t, err, "Failed to add link")
+		ids[urlString] = id
+	}
+	require.NoError(t, database.RecordScreenshotFailure(t.Context(), ids[dueURL]), "Failed to record failure")
+	require.NoError(t, database.RecordScreenshotFailure(t.Context(), ids[recentURL]), "Failed to record failure")
+	for range screenshotRetryMaxAttempts {
+		require.NoError(t, database.RecordScreenshotFailure(t.Context(), ids[exhaustedURL]), "Failed to record failure")
+	}
+	// Backdate the due and exhausted failures past any backoff; the recent
+	// one keeps its just-recorded attempt time.
+	old := time.Now().UTC().Add(-24 * time.Hour)
+	for _, urlString := range []string{dueURL, exhaustedURL} {
+		_, err := database.ExecContext(t.Context(), "UPDATE screenshot_retries SET last_attempt = ? WHERE link_id = ?", old, ids[urlString])
+		require.NoError(t, err, "Failed to backdate failure")
+	}
+
+	handlers.retryFailedScreenshots(t.Context())
+
+	assert.Equal(t, []string{dueURL}, captured, "Expected only the due screenshot to be re-captured")
+	content, err := os.ReadFile(filepath.Join(screenshotsDir, handlers.screenshotFile(ids[dueURL], dueURL)))
+	require.NoError(t, err, "Failed to read screenshot")
+	assert.Equal(t, "retried", string(content), "Expected the re-captured screenshot to be saved")
+
+	// The successful capture clears its retry; the others stay recorded
+	retries, err := database.GetScreenshotRetries(t.Context())
+	require.NoError(t, err, "Failed to get retries")
+	require.Len(t, retries, 2, "Wrong number of remaining retries")
+	for _, retry := range retries {
+		assert.NotEqual(t, ids[dueURL], retry.LinkID, "Expected the successful retry to be cleared")
+	}
+
+	// The pending gauge counts only retries which have not exhausted their
+	// attempts
+	assert.Equal(t, int64(1), screenshotRetriesPending.Value(), "Wrong pending retry count")
+}
+
+// TestRetryScreenshotFailureCountsAttempt verifies that a retry which fails
+// again pushes the link's next try further out instead of clearing it.
+func TestRetryScreenshotFailureCountsAttempt(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test_retry_failure.database")
+	database, err := db.InitDB(dbFile)
+	require.NoError(t, err, "Failed to initialize database")
+	t.Cleanup(func() {
+		_ = database.Close()
+	})
+	handlers := newHandlers("../../..", database, t.TempDir(), true)
+	handlers.captureScreenshot = func(u *url.URL) ([]byte, error) {
+		return nil, fmt.Errorf("browser hiccup")
+	}
+
+	id, err := database.AddLink(t.Context(), "https://example.com/", "Title", "", "", nil)
+	require.NoError(t, err, "Failed to add link")
+	require.NoError(t, database.RecordScreenshotFailure(t.Context(), id), "Failed to record failure")
+	old := time.Now().UTC().Add(-24 * time.Hour)
+	_, err = database.ExecContext(t.Context(), "UPDATE screenshot_retries SET last_attempt = ? WHERE link_id = ?", old, id)
+	require.NoError(t, err, "Failed to backdate failure")
+
+	handlers.retryFailedScreenshots(t.Context())
+
+	retries, err := database.GetScreenshotRetries(t.Context())
+	require.NoError(t, err, "Failed to get retries")
+	require.Len(t, retries, 1, "Expected the retry to stay recorded")
+	assert.Equal(t, 2, retries[0].Attempts, "Expected the failed retry to count the attempt")
+	assert.False(t, retries[0].LastAttempt.Before(old.Add(time.Hour)), "Expected the attempt time to move forward")
+}
@@ -0,0 +1,55 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// BuildInfo describes the running build, served at /version and printed by
+// the -version flag.
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+	Go      string `json:"go"`
+}
+
+// Build returns the build information for the given release version, with
+// the commit and its date filled in from the VCS settings the Go toolchain
+// embeds. A build from outside a checkout has neither.
+func Build(version string) BuildInfo {
+	info := BuildInfo{Version: version, Go: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.Date = setting.Value
+		}
+	}
+	return info
+}
+
+// String formats the build information on one line, for the -version flag.
+func (b BuildInfo) String() string {
+	s := "mylinks " + b.Version
+	if b.Commit != "" {
+		s += fmt.Sprintf(" (%s %s)", b.Commit, b.Date)
+	}
+	return s + " " + b.Go
+}
+
+// ShowVersion serves the running build's version information.
+func (h *Handlers) ShowVersion(w http.ResponseWriter, r *http.Request) {
+	version := h.Version
+	if version == "" {
+		version = "dev"
+	}
+	h.renderJson(w, Build(version), http.StatusOK)
+}
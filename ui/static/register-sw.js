@@ -0,0 +1,5 @@
+// Registers the service worker, in its own file since the Content-Security-
+// Policy does not allow inline scripts.
+if ("serviceWorker" in navigator) {
+    navigator.serviceWorker.register("/sw.js");
+}
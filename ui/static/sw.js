@@ -0,0 +1,4 @@
+// Minimal service worker: enough to make the app installable. The app is its
+// own server, so there is no offline caching, requests pass straight through.
+self.addEventListener("fetch", () => {
+});